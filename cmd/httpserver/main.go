@@ -3,41 +3,101 @@ package main
 import (
 	"crypto/sha256"
 	"fmt"
+	"io"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
 
+	"github.com/kiefbc/http-server-1.1/internal/accesslog"
+	"github.com/kiefbc/http-server-1.1/internal/cache"
+	"github.com/kiefbc/http-server-1.1/internal/compress"
+	"github.com/kiefbc/http-server-1.1/internal/conditional"
+	"github.com/kiefbc/http-server-1.1/internal/fileserver"
 	"github.com/kiefbc/http-server-1.1/internal/headers"
+	"github.com/kiefbc/http-server-1.1/internal/metrics"
+	"github.com/kiefbc/http-server-1.1/internal/proxy"
 	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/requestid"
 	"github.com/kiefbc/http-server-1.1/internal/response"
 	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/kiefbc/http-server-1.1/internal/tracing"
 )
 
 const port = 42069
 
-func handler(w *response.Writer, req *request.Request) *server.HandlerError {
-	switch req.RequestLine.RequestTarget {
-	case "/video":
-		videoFile, err := os.ReadFile("assets/vim.mp4")
-		if err != nil {
-			return &server.HandlerError{
-				StatusCode: 500,
-				Message:    fmt.Sprintf("Failed to read video file: %v", err),
+// assets serves files from the assets directory, streaming them from disk.
+var assets = fileserver.Dir("assets")
+
+// httpbinCacheBytes caps the in-memory cache in front of the httpbin proxy.
+const httpbinCacheBytes = 64 << 20
+
+// httpbinHandler forwards /httpbin/* to https://httpbin.org/*, caching what upstream allows.
+var httpbinHandler = newHttpbinHandler()
+
+// newHttpbinHandler builds the cached reverse proxy used for the /httpbin/ routes.
+func newHttpbinHandler() server.Handler {
+	rp, err := proxy.NewReverseProxy("https://httpbin.org")
+	if err != nil {
+		log.Fatalf("Error configuring httpbin proxy: %v", err)
+	}
+	rp.StripPrefix = "/httpbin"
+
+	httpbinCache := cache.New(cache.NewMemoryStore(httpbinCacheBytes))
+	return server.Chain(rp.Handle, contentTrailers, httpbinCache.Middleware)
+}
+
+// contentTrailers sends the body's SHA-256 and length as X-Content-SHA256 and X-Content-Length
+// trailers, which /httpbin/ responses have always carried. The body is sent chunked so the
+// trailers have somewhere to go.
+func contentTrailers(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		digest := sha256.New()
+		var length int64
+		w.WrapBody(func(body io.Writer) io.Writer {
+			return writerFunc(func(p []byte) (int, error) {
+				digest.Write(p)
+				length += int64(len(p))
+				return body.Write(p)
+			})
+		})
+		w.OnWriteHeaders(func(status response.StatusCode, h headers.Headers) response.StatusCode {
+			if status < 200 || status == response.StatusNoContent || status == response.StatusNotModified {
+				return status
 			}
-		}
+			delete(h, "content-length")
+			h.Replace("transfer-encoding", "chunked")
+			h.Set("trailer", "X-Content-SHA256, X-Content-Length")
+			return status
+		})
+		w.OnWriteTrailers(func(h headers.Headers) {
+			h.Replace("x-content-sha256", fmt.Sprintf("%x", digest.Sum(nil)))
+			h.Replace("x-content-length", fmt.Sprintf("%d", length))
+		})
+		return next(w, req)
+	}
+}
 
-		responseHeaders := response.GetDefaultHeaders(len(videoFile))
-		responseHeaders.Replace("content-type", "video/mp4")
-		responseHeaders.Replace("content-length", fmt.Sprintf("%d", len(videoFile)))
+// writerFunc adapts a function to io.Writer.
+type writerFunc func(p []byte) (int, error)
 
-		w.WriteStatusLine(response.StatusOK)
-		w.WriteHeaders(responseHeaders)
-		w.WriteBody(videoFile)
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
 
-		return nil
+// registry holds the server's metrics, served on /metrics.
+var registry = metrics.NewRegistry()
+
+// httpMetrics records requests and connections in registry, labelled by the routes below.
+var httpMetrics = metrics.NewHTTP(registry, "/video", "/yourproblem", "/myproblem", "/chunked", "/metrics", "/httpbin/")
+
+func handler(w *response.Writer, req *request.Request) *server.HandlerError {
+	switch req.RequestLine.RequestTarget {
+	case "/video":
+		return assets.ServeFile(w, req, "vim.mp4")
+	case "/metrics":
+		return metrics.Handler(registry)(w, req)
 	case "/yourproblem":
 		htmlContent := []byte(`<html>
   <head>
@@ -110,61 +170,8 @@ func handler(w *response.Writer, req *request.Request) *server.HandlerError {
 		return nil
 
 	default:
-		// Check if this is a /httpbin proxy request
-		if after, ok := strings.CutPrefix(req.RequestLine.RequestTarget, "/httpbin/"); ok {
-			// Extract the path after /httpbin/ to proxy to httpbin.org
-			proxyPath := after
-			proxyURL := fmt.Sprintf("https://httpbin.org/%s", proxyPath)
-
-			fmt.Printf("Proxying request to: %s\n", proxyURL)
-
-			resp, err := http.Get(proxyURL)
-			if err != nil {
-				return &server.HandlerError{
-					StatusCode: 500,
-					Message:    fmt.Sprintf("Proxy request failed: %v", err),
-				}
-			}
-			defer resp.Body.Close()
-
-			chunkedHeaders := response.GetChunkedHeaders()
-			// Copy content-type from upstream response if present
-			if contentType := resp.Header.Get("Content-Type"); contentType != "" {
-				chunkedHeaders.Replace("content-type", contentType)
-			}
-
-			chunkedHeaders.Set("trailer", "X-Content-SHA256, X-Content-Length")
-
-			w.WriteStatusLine(response.StatusCode(resp.StatusCode))
-			w.WriteHeaders(chunkedHeaders)
-
-			var fullBody []byte
-			buffer := make([]byte, 8)
-			for {
-				n, err := resp.Body.Read(buffer)
-				if n > 0 {
-					fmt.Printf("Read %d bytes, writing chunk\n", n)
-					fullBody = append(fullBody, buffer[:n]...)
-					w.WriteChunkedBody(buffer[:n])
-				}
-				if err != nil {
-					break
-				}
-			}
-
-			hash := sha256.Sum256(fullBody)
-
-			w.WriteChunkedBodyDone()
-
-			trailerHeaders := make(headers.Headers)
-			trailerHeaders.Replace("x-content-sha256", fmt.Sprintf("%x", hash))
-			trailerHeaders.Replace("x-content-length", fmt.Sprintf("%d", len(fullBody)))
-			w.WriteTrailers(trailerHeaders)
-			w.WriteTrailersDone()
-
-			fmt.Println("Proxy streaming completed")
-
-			return nil
+		if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
+			return httpbinHandler(w, req)
 		}
 
 		// Default non-proxy response
@@ -192,7 +199,13 @@ func handler(w *response.Writer, req *request.Request) *server.HandlerError {
 
 // main starts an HTTP server that listens on port 42069 and handles graceful shutdown.
 func main() {
-	srv, err := server.Serve(port, handler)
+	compressor := compress.New()
+	accessLog := accesslog.New(accesslog.NewHandler(os.Stdout, accesslog.Combined))
+	// Spans are not exported; the tracer continues callers' traces through to httpbin
+	tracer := tracing.New(nil)
+	srv, err := server.Serve(port,
+		server.Chain(handler, accessLog.Middleware, httpMetrics.Middleware, tracer.Middleware, requestid.Middleware, compressor.Middleware, conditional.Middleware),
+		server.WithObserver(httpMetrics))
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
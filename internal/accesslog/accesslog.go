@@ -0,0 +1,98 @@
+package accesslog
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/auth"
+	"github.com/kiefbc/http-server-1.1/internal/ipfilter"
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/requestid"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+)
+
+// Attribute keys of the access log record. Handlers for other formats can pick them out.
+const (
+	KeyMethod     = "method"
+	KeyTarget     = "target"
+	KeyProto      = "proto"
+	KeyStatus     = "status"
+	KeyBytes      = "bytes"
+	KeyDuration   = "duration"
+	KeyRemoteAddr = "remote_addr"
+	KeyUser       = "user"
+	KeyUserAgent  = "user_agent"
+	KeyReferer    = "referer"
+	KeyRequestID  = "request_id"
+)
+
+// Logger is access log middleware that emits one slog record per request.
+type Logger struct {
+	handler slog.Handler
+	now     func() time.Time
+
+	// Level is the level records are logged at.
+	Level slog.Level
+}
+
+// New creates access logging to handler. NewHandler gives handlers for the usual formats;
+// any other slog.Handler works too.
+func New(handler slog.Handler) *Logger {
+	return &Logger{handler: handler, now: time.Now, Level: slog.LevelInfo}
+}
+
+// Middleware logs each request once its response is complete. The record's time is when the
+// request arrived, as in Common Log Format. It finishes the response with server.Finish, so it
+// goes first in the chain (see server.Chain) and the duration and status cover everything else.
+func (l *Logger) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		start := l.now()
+		req = requestid.Track(req)
+		handlerErr := next(w, req)
+
+		server.Finish(w, handlerErr)
+		l.log(req.Context(), req, w, start)
+		return handlerErr
+	}
+}
+
+func (l *Logger) log(ctx context.Context, req *request.Request, w *response.Writer, start time.Time) {
+	if !l.handler.Enabled(ctx, l.Level) {
+		return
+	}
+
+	record := slog.NewRecord(start, l.Level, "request", 0)
+	record.AddAttrs(
+		slog.String(KeyMethod, req.RequestLine.Method),
+		slog.String(KeyTarget, req.RequestLine.RequestTarget),
+		slog.String(KeyProto, "HTTP/"+req.RequestLine.HttpVersion),
+		slog.Int(KeyStatus, int(w.StatusCode())),
+		slog.Int64(KeyBytes, w.BytesWritten()),
+		slog.Duration(KeyDuration, l.now().Sub(start)),
+		slog.String(KeyRemoteAddr, remoteAddr(req)),
+	)
+	if p, ok := auth.FromRequest(req); ok && p.Name != "" {
+		record.AddAttrs(slog.String(KeyUser, p.Name))
+	}
+	if userAgent, ok := req.Headers.Get("User-Agent"); ok {
+		record.AddAttrs(slog.String(KeyUserAgent, userAgent))
+	}
+	if referer, ok := req.Headers.Get("Referer"); ok {
+		record.AddAttrs(slog.String(KeyReferer, referer))
+	}
+	// req was prepared with requestid.Track, so this works wherever requestid.Middleware sits
+	if requestID, ok := requestid.FromRequest(req); ok {
+		record.AddAttrs(slog.String(KeyRequestID, requestID))
+	}
+	l.handler.Handle(ctx, record)
+}
+
+// remoteAddr is the client IP, resolved through trusted proxies when that middleware ran.
+func remoteAddr(req *request.Request) string {
+	if client, ok := ipfilter.ClientIP(req); ok {
+		return client.String()
+	}
+	return req.RemoteAddr
+}
@@ -0,0 +1,151 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/requestid"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/kiefbc/http-server-1.1/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// do runs handler behind access logging in format and returns the log output.
+func do(t *testing.T, handler server.Handler, format Format, extra map[string]string) string {
+	t.Helper()
+	req := servertest.NewRequest(t, "GET", "/search?q=1", extra)
+	req.RemoteAddr = "192.0.2.7:50000"
+
+	var logs bytes.Buffer
+	l := New(NewHandler(&logs, format))
+	clock := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	l.now = func() time.Time {
+		now := clock
+		clock = clock.Add(25 * time.Millisecond)
+		return now
+	}
+
+	w := response.NewWriter(&bytes.Buffer{})
+	// Errors are passed on for outer middleware; finishing again must not write them twice
+	server.Finish(w, l.Middleware(handler)(w, req))
+	return logs.String()
+}
+
+func hello(w *response.Writer, req *request.Request) *server.HandlerError {
+	body := []byte("hello")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+	return nil
+}
+
+func TestFormats(t *testing.T) {
+	extra := map[string]string{"User-Agent": `curl/8.0 "quoted"`, "Referer": "https://example.com/", "X-Request-ID": "req-1"}
+
+	// Test: Common Log Format
+	assert.Equal(t, `192.0.2.7 - - [04/Mar/2025:05:06:07 +0000] "GET /search?q=1 HTTP/1.1" 200 5`+"\n",
+		do(t, hello, Common, extra))
+
+	// Test: Combined adds referer and escaped user agent
+	assert.Equal(t, `192.0.2.7 - - [04/Mar/2025:05:06:07 +0000] "GET /search?q=1 HTTP/1.1" 200 5 "https://example.com/" "curl/8.0 \"quoted\""`+"\n",
+		do(t, hello, Combined, extra))
+
+	// Test: JSON carries every field
+	var record map[string]any
+	require.NoError(t, json.Unmarshal([]byte(do(t, requestid.Middleware(hello), JSON, extra)), &record))
+	assert.Equal(t, "request", record["msg"])
+	assert.Equal(t, "GET", record[KeyMethod])
+	assert.Equal(t, "/search?q=1", record[KeyTarget])
+	assert.Equal(t, float64(200), record[KeyStatus])
+	assert.Equal(t, float64(5), record[KeyBytes])
+	assert.Equal(t, float64(25*time.Millisecond), record[KeyDuration])
+	assert.Equal(t, "192.0.2.7", record[KeyRemoteAddr])
+	assert.Equal(t, `curl/8.0 "quoted"`, record[KeyUserAgent])
+	assert.Equal(t, "req-1", record[KeyRequestID])
+	assert.Equal(t, "2025-03-04T05:06:07Z", record["time"])
+
+	// Test: Handler errors are logged with their status, and empty bodies as "-"
+	failing := func(w *response.Writer, req *request.Request) *server.HandlerError {
+		return &server.HandlerError{StatusCode: response.StatusNotFound, Message: "404 Not Found"}
+	}
+	assert.Contains(t, do(t, failing, Common, nil), `" 404 13`)
+	empty := func(w *response.Writer, req *request.Request) *server.HandlerError {
+		w.WriteStatusLine(response.StatusNoContent)
+		return nil
+	}
+	assert.Contains(t, do(t, empty, Common, nil), `" 204 -`)
+
+	// Test: IDs assigned by request ID middleware further in are logged
+	require.NoError(t, json.Unmarshal([]byte(do(t, requestid.Middleware(hello), JSON, nil)), &record))
+	assert.Regexp(t, `^[0-9a-f]{32}$`, record[KeyRequestID])
+
+	// Test: Without that middleware a client's X-Request-ID header is not logged as the ID
+	record = nil
+	require.NoError(t, json.Unmarshal([]byte(do(t, hello, JSON, extra)), &record))
+	assert.NotContains(t, record, KeyRequestID)
+
+	// Test: Control characters cannot forge log lines
+	assert.Equal(t, `a\x0ab\\`, escape("a\nb\\"))
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	rf, err := OpenRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	defer rf.Close()
+
+	// Test: Files rotate before exceeding the size and old backups are dropped
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n"} {
+		_, err := rf.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	read := func(name string) string {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		return string(data)
+	}
+	assert.Equal(t, "four\nfive\n", read(path))
+	assert.Equal(t, "three\n", read(path+".1"))
+	assert.Equal(t, "one\ntwo\n", read(path+".2"))
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+
+	// Test: Reopening appends and keeps counting the existing size
+	require.NoError(t, rf.Close())
+	rf, err = OpenRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	rf.Write([]byte("six\n"))
+	assert.Equal(t, "six\n", read(path))
+	assert.Equal(t, "four\nfive\n", read(path+".1"))
+	assert.Equal(t, "three\n", read(path+".2"))
+
+	// Test: A failed rotation reports the error and keeps writing to the current file
+	require.NoError(t, rf.Close())
+	path = filepath.Join(t.TempDir(), "access.log")
+	require.NoError(t, os.MkdirAll(filepath.Join(path+".1", "blocked"), 0o755))
+	rf, err = OpenRotatingFile(path, 10, 1)
+	require.NoError(t, err)
+	defer rf.Close()
+	_, err = rf.Write([]byte("one\ntwo\n"))
+	require.NoError(t, err)
+	n, err := rf.Write([]byte("three\n"))
+	assert.Error(t, err)
+	assert.Equal(t, 6, n)
+	require.NoError(t, os.RemoveAll(path+".1"))
+	_, err = rf.Write([]byte("four\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "four\n", read(path))
+	assert.Equal(t, "one\ntwo\nthree\n", read(path+".1"))
+
+	// Test: Writes after Close fail
+	require.NoError(t, rf.Close())
+	_, err = rf.Write([]byte("late\n"))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
@@ -0,0 +1,117 @@
+package accesslog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// RotatingFile is a log file that is rotated once it reaches a size: path becomes path.1,
+// path.1 becomes path.2 and so on, and the oldest backup beyond the limit is deleted.
+type RotatingFile struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	closed bool
+}
+
+// OpenRotatingFile opens path for appending. It rotates before a write would take the file
+// past maxBytes, keeping up to maxBackups old files.
+func OpenRotatingFile(path string, maxBytes int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// Write appends p, rotating first if needed. A single write is never split across files.
+// If rotation fails, p still goes to the current file, which keeps growing until a later
+// rotation succeeds, and the rotation error is returned.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.closed {
+		return 0, os.ErrClosed
+	}
+	var rotateErr error
+	if rf.file != nil && rf.size > 0 && rf.size+int64(len(p)) > rf.maxBytes {
+		rotateErr = rf.rotate()
+	}
+	// A failed rotation whose reopen also failed leaves no file; try again for this write
+	if rf.file == nil {
+		if err := rf.open(); err != nil {
+			return 0, errors.Join(rotateErr, err)
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	if err != nil {
+		return n, err
+	}
+	return n, rotateErr
+}
+
+// Close closes the current file.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	rf.closed = true
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file = file
+	rf.size = info.Size()
+	return nil
+}
+
+// rotate shifts the backups along and starts a new file. If the current file cannot be moved
+// aside it is reopened, so logging carries on in it.
+func (rf *RotatingFile) rotate() error {
+	closeErr := rf.file.Close()
+	rf.file = nil
+
+	var err error
+	if rf.maxBackups > 0 {
+		os.Remove(rf.backup(rf.maxBackups))
+		for i := rf.maxBackups - 1; i >= 1; i-- {
+			os.Rename(rf.backup(i), rf.backup(i+1))
+		}
+		err = os.Rename(rf.path, rf.backup(1))
+	} else {
+		err = os.Remove(rf.path)
+	}
+	if openErr := rf.open(); openErr != nil {
+		return errors.Join(closeErr, err, openErr)
+	}
+	if err := errors.Join(closeErr, err); err != nil {
+		return fmt.Errorf("rotating %s: %w", rf.path, err)
+	}
+	return nil
+}
+
+func (rf *RotatingFile) backup(n int) string {
+	return fmt.Sprintf("%s.%d", rf.path, n)
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+)
+
+// Principal is the authenticated identity behind a request.
+type Principal struct {
+	// Name identifies the user or client, e.g. the Basic username or a token's subject.
+	Name string
+	// Scheme is the authentication scheme that established the identity, e.g. "Basic".
+	Scheme string
+	// Claims holds whatever else the validator knows about the principal, such as token claims.
+	Claims map[string]any
+}
+
+type contextKey struct{}
+
+// FromRequest returns the principal an auth middleware attached to req.
+func FromRequest(req *request.Request) (*Principal, bool) {
+	p, ok := req.Context().Value(contextKey{}).(*Principal)
+	return p, ok
+}
+
+// withPrincipal returns a copy of req carrying p.
+func withPrincipal(req *request.Request, p *Principal) *request.Request {
+	return req.WithContext(context.WithValue(req.Context(), contextKey{}, p))
+}
+
+// credentials splits the Authorization header into its scheme and the rest
+// (RFC 9110 Section 11.6.2). The scheme is matched case-insensitively.
+func credentials(req *request.Request, scheme string) (string, bool) {
+	authorization, ok := req.Headers.Get("Authorization")
+	if !ok {
+		return "", false
+	}
+	got, rest, _ := strings.Cut(strings.TrimSpace(authorization), " ")
+	if !strings.EqualFold(got, scheme) {
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+// quote returns s as an auth-param quoted-string (RFC 9110 Section 5.6.4).
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// challenge answers with a status (401, or 400/403 for Bearer errors) and a WWW-Authenticate header.
+func challenge(w *response.Writer, status response.StatusCode, wwwAuthenticate string) {
+	body := []byte(fmt.Sprintf("%d %s", status, response.StatusText(status)))
+	responseHeaders := response.GetDefaultHeaders(len(body))
+	responseHeaders.Replace("www-authenticate", wwwAuthenticate)
+
+	w.WriteStatusLine(status)
+	w.WriteHeaders(responseHeaders)
+	w.WriteBody(body)
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/kiefbc/http-server-1.1/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// do runs handler for a GET carrying the Authorization header, if any.
+func do(t *testing.T, handler server.Handler, authorization string) *http.Response {
+	t.Helper()
+	extra := map[string]string{}
+	if authorization != "" {
+		extra["Authorization"] = authorization
+	}
+	resp, _ := servertest.Do(t, handler, servertest.NewRequest(t, "GET", "/", extra))
+	return resp
+}
+
+// whoami answers with the principal's scheme and name.
+func whoami(w *response.Writer, req *request.Request) *server.HandlerError {
+	p, ok := FromRequest(req)
+	if !ok {
+		return &server.HandlerError{StatusCode: response.StatusInternalServerError, Message: "no principal"}
+	}
+	body := []byte(p.Scheme + " " + p.Name)
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+	return nil
+}
+
+func basic(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func body(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	var b bytes.Buffer
+	_, err := b.ReadFrom(resp.Body)
+	require.NoError(t, err)
+	return b.String()
+}
+
+func TestBasic(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("s3cret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	file := fmt.Sprintf("# users\n\nalice:%s\n", strings.Replace(string(hash), "$2a$", "$2y$", 1))
+	users, err := ParseHtpasswd(strings.NewReader(file))
+	require.NoError(t, err)
+	handler := NewBasic(`the "admin" area`, users.Verify).Middleware(whoami)
+
+	// Test: Valid credentials reach the handler with a principal
+	resp := do(t, handler, basic("alice", "s3cret"))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "Basic alice", body(t, resp))
+
+	// Test: The scheme name is case-insensitive
+	resp = do(t, handler, strings.Replace(basic("alice", "s3cret"), "Basic", "bAsIc", 1))
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// Test: Missing, wrong or malformed credentials are challenged
+	for _, authorization := range []string{"", basic("alice", "wrong"), basic("bob", "s3cret"), "Basic !!!", "Bearer abc"} {
+		resp = do(t, handler, authorization)
+		assert.Equal(t, 401, resp.StatusCode, authorization)
+		assert.Equal(t, `Basic realm="the \"admin\" area", charset="UTF-8"`, resp.Header.Get("WWW-Authenticate"))
+	}
+
+	// Test: Users can be added at runtime
+	require.NoError(t, users.Set("bob", "hunter2"))
+	assert.True(t, users.Verify("bob", "hunter2"))
+
+	// Test: Non-bcrypt entries are refused
+	_, err = ParseHtpasswd(strings.NewReader("carol:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+	assert.Error(t, err)
+	_, err = ParseHtpasswd(strings.NewReader("no-colon\n"))
+	assert.Error(t, err)
+
+	// Test: Static credentials compare both fields
+	verify := StaticCredentials("admin", "pw")
+	assert.True(t, verify("admin", "pw"))
+	assert.False(t, verify("admin", "PW"))
+	assert.False(t, verify("root", "pw"))
+}
+
+var errUnknownToken = NewTokenError("unknown token")
+
+func TestBearer(t *testing.T) {
+	validator := TokenValidatorFunc(func(ctx context.Context, token string) (*Principal, error) {
+		switch token {
+		case "good-token":
+			return &Principal{Name: "svc"}, nil
+		case "read-only":
+			return nil, fmt.Errorf("token lacks write: %w", ErrInsufficientScope)
+		case "lookup-fails":
+			return nil, errors.New("db at 10.0.0.5: connection refused")
+		}
+		return nil, fmt.Errorf("token %q: %w", token, errUnknownToken)
+	})
+	b := NewBearer("api", validator)
+	b.Scope = "write"
+	handler := b.Middleware(whoami)
+
+	// Test: A valid token reaches the handler with a principal
+	resp := do(t, handler, "Bearer good-token")
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "Bearer svc", body(t, resp))
+
+	// Test: A request without a token gets a plain challenge
+	resp = do(t, handler, "")
+	assert.Equal(t, 401, resp.StatusCode)
+	assert.Equal(t, `Bearer realm="api", scope="write"`, resp.Header.Get("WWW-Authenticate"))
+
+	// Test: A rejected token says why
+	resp = do(t, handler, "Bearer bad-token")
+	assert.Equal(t, 401, resp.StatusCode)
+	assert.Equal(t, `Bearer realm="api", scope="write", error="invalid_token", error_description="unknown token"`, resp.Header.Get("WWW-Authenticate"))
+
+	// Test: Other validator errors are not echoed to the client
+	resp = do(t, handler, "Bearer lookup-fails")
+	assert.Equal(t, 401, resp.StatusCode)
+	assert.Equal(t, `Bearer realm="api", scope="write", error="invalid_token", error_description="invalid token"`, resp.Header.Get("WWW-Authenticate"))
+
+	// Test: A token without the needed scope is forbidden
+	resp = do(t, handler, "Bearer read-only")
+	assert.Equal(t, 403, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("WWW-Authenticate"), `error="insufficient_scope", error_description="insufficient scope"`)
+
+	// Test: A malformed token is a bad request
+	resp = do(t, handler, "Bearer not a token")
+	assert.Equal(t, 400, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("WWW-Authenticate"), `error="invalid_request"`)
+
+	// Test: Token syntax allows base64 padding only at the end
+	assert.True(t, validToken68("abc.DEF-_~+/=="))
+	assert.False(t, validToken68("ab=c"))
+	assert.False(t, validToken68(""))
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Basic is HTTP Basic authentication middleware (RFC 7617).
+// Basic sends the password with every request, so only use it over TLS.
+type Basic struct {
+	// Realm is sent in the challenge and tells the user which credentials to use.
+	Realm  string
+	verify func(username, password string) bool
+}
+
+// NewBasic creates Basic authentication that accepts the credentials verify approves.
+// Htpasswd.Verify is a ready-made verify function.
+func NewBasic(realm string, verify func(username, password string) bool) *Basic {
+	return &Basic{Realm: realm, verify: verify}
+}
+
+// Middleware answers 401 with a Basic challenge unless the request carries valid credentials,
+// in which case next sees the request with a Principal named after the user.
+func (b *Basic) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		username, password, ok := parseBasic(req)
+		if !ok || !b.verify(username, password) {
+			challenge(w, response.StatusUnauthorized, fmt.Sprintf("Basic realm=%s, charset=\"UTF-8\"", quote(b.Realm)))
+			return nil
+		}
+		return next(w, withPrincipal(req, &Principal{Name: username, Scheme: "Basic"}))
+	}
+}
+
+// parseBasic decodes Basic credentials: base64 of user-id ":" password (RFC 7617 Section 2).
+func parseBasic(req *request.Request) (string, string, bool) {
+	encoded, ok := credentials(req, "Basic")
+	if !ok {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	return username, password, ok
+}
+
+// Htpasswd holds users and bcrypt password hashes in the format of an Apache htpasswd file
+// (lines of "user:$2y$..."; create entries with htpasswd -B).
+type Htpasswd struct {
+	mu    sync.RWMutex
+	users map[string][]byte
+}
+
+// dummyHash is compared against for unknown users so a miss takes as long as a wrong password
+// and does not reveal which usernames exist.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("unused"), bcrypt.DefaultCost)
+
+// LoadHtpasswd reads an htpasswd file.
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseHtpasswd(file)
+}
+
+// ParseHtpasswd reads htpasswd entries. Blank lines and lines starting with # are skipped.
+// Only bcrypt hashes are accepted; MD5, SHA1 and crypt entries are insecure and are refused.
+func ParseHtpasswd(r io.Reader) (*Htpasswd, error) {
+	users := make(map[string][]byte)
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		username, hash, ok := strings.Cut(text, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf("htpasswd line %d: expected user:hash", line)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("htpasswd line %d: user %q does not have a bcrypt hash", line, username)
+		}
+		users[username] = []byte(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &Htpasswd{users: users}, nil
+}
+
+// Set adds or replaces a user with a bcrypt hash of password.
+func (h *Htpasswd) Set(username, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.users[username] = hash
+	return nil
+}
+
+// Verify reports whether password matches the stored hash for username. bcrypt compares in
+// constant time, and unknown users are checked against a dummy hash to take just as long.
+func (h *Htpasswd) Verify(username, password string) bool {
+	h.mu.RLock()
+	hash, ok := h.users[username]
+	h.mu.RUnlock()
+
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// StaticCredentials returns a verify function for a single fixed username and password,
+// compared in constant time. It suits tests and internal tools; prefer Htpasswd elsewhere.
+func StaticCredentials(username, password string) func(string, string) bool {
+	return func(gotUser, gotPassword string) bool {
+		userMatch := subtle.ConstantTimeCompare([]byte(gotUser), []byte(username))
+		passwordMatch := subtle.ConstantTimeCompare([]byte(gotPassword), []byte(password))
+		return userMatch&passwordMatch == 1
+	}
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+)
+
+// TokenValidator checks a bearer token and returns the principal it stands for.
+type TokenValidator interface {
+	Validate(ctx context.Context, token string) (*Principal, error)
+}
+
+// TokenValidatorFunc adapts a function to TokenValidator.
+type TokenValidatorFunc func(ctx context.Context, token string) (*Principal, error)
+
+func (f TokenValidatorFunc) Validate(ctx context.Context, token string) (*Principal, error) {
+	return f(ctx, token)
+}
+
+// ErrInsufficientScope may be returned, or wrapped, by a TokenValidator for a valid token
+// that does not grant access; the client then gets 403 instead of 401.
+var ErrInsufficientScope = NewTokenError("insufficient scope")
+
+// tokenError is a validator error whose text is safe to show the client.
+type tokenError struct {
+	text string
+}
+
+func (e *tokenError) Error() string { return e.text }
+
+// NewTokenError returns an error whose text Bearer may send to the client as the
+// error_description. Validators return, or wrap, such errors for the failures they want to
+// explain; any other error is described only as "invalid token", so internal details such
+// as lookup failures never reach the client. Wrapping text is not sent, only text's.
+func NewTokenError(text string) error {
+	return &tokenError{text: text}
+}
+
+// describe returns the client-safe description of err, or fallback.
+func describe(err error, fallback string) string {
+	var te *tokenError
+	if errors.As(err, &te) {
+		return te.text
+	}
+	return fallback
+}
+
+// Bearer is bearer token authentication middleware (RFC 6750).
+type Bearer struct {
+	// Realm is sent in the challenge.
+	Realm string
+	// Scope, if set, is advertised in challenges as the scope a token needs.
+	Scope     string
+	validator TokenValidator
+}
+
+// NewBearer creates bearer authentication that accepts the tokens validator approves.
+func NewBearer(realm string, validator TokenValidator) *Bearer {
+	return &Bearer{Realm: realm, validator: validator}
+}
+
+// Middleware validates the bearer token in the Authorization header. Requests without a
+// token get a plain challenge; invalid tokens get 401 with error="invalid_token"
+// (RFC 6750 Section 3.1).
+func (b *Bearer) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		token, ok := credentials(req, "Bearer")
+		if !ok {
+			challenge(w, response.StatusUnauthorized, b.challenge("", ""))
+			return nil
+		}
+		if !validToken68(token) {
+			challenge(w, response.StatusBadRequest, b.challenge("invalid_request", "malformed bearer token"))
+			return nil
+		}
+
+		principal, err := b.validator.Validate(req.Context(), token)
+		if errors.Is(err, ErrInsufficientScope) {
+			challenge(w, response.StatusForbidden, b.challenge("insufficient_scope", describe(err, "insufficient scope")))
+			return nil
+		}
+		if err != nil || principal == nil {
+			challenge(w, response.StatusUnauthorized, b.challenge("invalid_token", describe(err, "invalid token")))
+			return nil
+		}
+
+		if principal.Scheme == "" {
+			principal.Scheme = "Bearer"
+		}
+		return next(w, withPrincipal(req, principal))
+	}
+}
+
+// challenge builds the WWW-Authenticate value, adding error details when there are any.
+func (b *Bearer) challenge(errorCode, description string) string {
+	value := fmt.Sprintf("Bearer realm=%s", quote(b.Realm))
+	if b.Scope != "" {
+		value += fmt.Sprintf(", scope=%s", quote(b.Scope))
+	}
+	if errorCode != "" {
+		value += fmt.Sprintf(", error=%s, error_description=%s", quote(errorCode), quote(description))
+	}
+	return value
+}
+
+// validToken68 reports whether token matches the b64token syntax (RFC 6750 Section 2.1).
+func validToken68(token string) bool {
+	if token == "" {
+		return false
+	}
+	padding := false
+	for i := 0; i < len(token); i++ {
+		c := token[i]
+		switch {
+		case c == '=':
+			padding = true
+		case padding:
+			// Only '=' may follow padding
+			return false
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '.', c == '_', c == '~', c == '+', c == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,530 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/cookie"
+	"github.com/kiefbc/http-server-1.1/internal/headers"
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+)
+
+// revalidateTimeout bounds background revalidations, which outlive the client request.
+const revalidateTimeout = 30 * time.Second
+
+// Values of the X-Cache header that reports how a response was produced.
+const (
+	cacheHit         = "HIT"
+	cacheMiss        = "MISS"
+	cacheStale       = "STALE"
+	cacheRevalidated = "REVALIDATED"
+)
+
+// hopByHopHeaders describe one connection and are never stored (RFC 9111 Section 3.1).
+var hopByHopHeaders = []string{
+	"connection", "keep-alive", "proxy-connection", "te", "trailer", "transfer-encoding", "upgrade",
+}
+
+// Cache is an RFC 9111 shared HTTP cache that sits in front of any server.Handler.
+// It honours Cache-Control, Expires and Vary, revalidates with ETag and Last-Modified,
+// serves stale responses within stale-while-revalidate while refreshing in the background,
+// and reports the age of cached responses in the Age header.
+type Cache struct {
+	store Store
+
+	// MaxEntryBytes is the largest body that will be stored; larger responses pass through.
+	MaxEntryBytes int64
+
+	now func() time.Time
+
+	mu           sync.Mutex
+	revalidating map[string]bool
+}
+
+// New creates a Cache backed by store.
+func New(store Store) *Cache {
+	return &Cache{
+		store:         store,
+		MaxEntryBytes: 10 << 20,
+		now:           time.Now,
+		revalidating:  make(map[string]bool),
+	}
+}
+
+// Middleware returns next wrapped with the cache. Only GET responses are stored; HEAD is
+// answered from stored GET responses, and successful unsafe requests invalidate the target.
+func (c *Cache) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		method := req.RequestLine.Method
+		key := primaryKey(req)
+
+		if method != "GET" && method != "HEAD" {
+			handlerErr := next(w, req)
+			if handlerErr == nil && !isSafe(method) && w.StatusCode() < 400 {
+				// Unsafe methods change the resource, so whatever we hold is out of date (RFC 9111 Section 4.4)
+				c.store.Delete(key)
+			}
+			return handlerErr
+		}
+
+		reqCC := requestCacheControl(req.Headers)
+		entry, ok := c.lookup(key, req)
+		if !ok {
+			if reqCC.has("only-if-cached") {
+				return &server.HandlerError{
+					StatusCode: response.StatusGatewayTimeout,
+					Message:    "Gateway Timeout: response not cached (only-if-cached)",
+				}
+			}
+			return c.fetch(w, req, next, key)
+		}
+
+		now := c.now()
+		respCC := parseCacheControl(headerValue(entry.Headers, "Cache-Control"))
+		age := entry.currentAge(now)
+		lifetime := entry.freshnessLifetime()
+
+		mustRevalidate := reqCC.has("no-cache") || respCC.has("no-cache")
+		if !mustRevalidate && satisfiesRequest(reqCC, respCC, age, lifetime) {
+			writeEntry(w, req, entry, now, cacheHit)
+			return nil
+		}
+
+		// stale-while-revalidate lets us answer now and refresh afterwards (RFC 5861 Section 3)
+		if window, ok := respCC.seconds("stale-while-revalidate"); ok && !mustRevalidate &&
+			!respCC.has("must-revalidate") && !respCC.has("proxy-revalidate") && age <= lifetime+window {
+			writeEntry(w, req, entry, now, cacheStale)
+			c.revalidateInBackground(req, next, key, entry)
+			return nil
+		}
+
+		return c.revalidate(w, req, next, key, entry)
+	}
+}
+
+// satisfiesRequest reports whether an entry of the given age and lifetime may be served
+// without revalidation given the request's max-age, min-fresh and max-stale (RFC 9111 Section 5.2.1).
+func satisfiesRequest(reqCC, respCC cacheControl, age, lifetime time.Duration) bool {
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+	if minFresh, ok := reqCC.seconds("min-fresh"); ok && lifetime-age < minFresh {
+		return false
+	}
+	if age < lifetime {
+		return true
+	}
+
+	// Stale entries only go out if the client explicitly tolerates it and the origin allows it
+	if !reqCC.has("max-stale") || respCC.has("must-revalidate") || respCC.has("proxy-revalidate") {
+		return false
+	}
+	maxStale, ok := reqCC.seconds("max-stale")
+	return !ok || age-lifetime <= maxStale
+}
+
+// fetch runs next with the response streamed straight to the client while a copy is taken,
+// then stores the copy if it is cacheable and complete.
+func (c *Cache) fetch(w *response.Writer, req *request.Request, next server.Handler, key string) *server.HandlerError {
+	captured := &capture{limit: c.MaxEntryBytes}
+	w.OnWriteHeaders(func(status response.StatusCode, h headers.Headers) response.StatusCode {
+		captured.record(status, h, w.Cookies(), w.HeaderLines())
+		h.Replace("x-cache", cacheMiss)
+		return status
+	})
+	w.WrapBody(captured.tee)
+
+	requestTime := c.now()
+	handlerErr := next(w, req)
+	if handlerErr != nil || w.Hijacked() || w.Aborted() || req.RequestLine.Method != "GET" {
+		return handlerErr
+	}
+
+	c.storeCapture(key, req, captured, requestTime, c.now())
+	return nil
+}
+
+// revalidate asks next whether entry is still current and answers the client with either
+// the refreshed entry or the new response.
+func (c *Cache) revalidate(w *response.Writer, req *request.Request, next server.Handler, key string, entry *Entry) *server.HandlerError {
+	captured, requestTime, handlerErr := c.conditionalFetch(req, next, entry)
+	if handlerErr != nil {
+		return handlerErr
+	}
+
+	if captured.status == response.StatusNotModified {
+		refreshed := c.refresh(key, req, entry, captured, requestTime)
+		writeEntry(w, req, refreshed, c.now(), cacheRevalidated)
+		return nil
+	}
+
+	c.storeCapture(key, req, captured, requestTime, c.now())
+	writeCapture(w, req, captured)
+	return nil
+}
+
+// revalidateInBackground refreshes entry after the client has been answered.
+// Only one revalidation per key runs at a time.
+func (c *Cache) revalidateInBackground(req *request.Request, next server.Handler, key string, entry *Entry) {
+	c.mu.Lock()
+	if c.revalidating[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.revalidating[key] = true
+	c.mu.Unlock()
+
+	// The client request finishes first, so the refresh gets its own deadline
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), revalidateTimeout)
+	bgReq := req.WithContext(ctx)
+
+	go func() {
+		defer cancel()
+		defer func() {
+			c.mu.Lock()
+			delete(c.revalidating, key)
+			c.mu.Unlock()
+		}()
+
+		captured, requestTime, handlerErr := c.conditionalFetch(bgReq, next, entry)
+		if handlerErr != nil {
+			return
+		}
+		if captured.status == response.StatusNotModified {
+			c.refresh(key, bgReq, entry, captured, requestTime)
+			return
+		}
+		c.storeCapture(key, bgReq, captured, requestTime, c.now())
+	}()
+}
+
+// conditionalFetch runs next with the entry's validators attached, recording the response
+// instead of sending it to the client.
+func (c *Cache) conditionalFetch(req *request.Request, next server.Handler, entry *Entry) (*capture, time.Time, *server.HandlerError) {
+	condReq := req.WithContext(req.Context())
+	// A HEAD revalidates with GET, so a changed response comes with the body the entry needs
+	condReq.RequestLine.Method = "GET"
+	condReq.Headers = headers.NewHeaders()
+	for name, value := range req.Headers {
+		condReq.Headers[name] = value
+	}
+	// Validators come from the stored response (RFC 9111 Section 4.3.1)
+	delete(condReq.Headers, "if-none-match")
+	delete(condReq.Headers, "if-modified-since")
+	if etag, ok := entry.Headers.Get("ETag"); ok {
+		condReq.Headers.Replace("If-None-Match", etag)
+	}
+	if lastModified, ok := entry.Headers.Get("Last-Modified"); ok {
+		condReq.Headers.Replace("If-Modified-Since", lastModified)
+	}
+
+	captured := &capture{limit: -1}
+	recorder := response.NewWriter(io.Discard)
+	recorder.OnWriteHeaders(func(status response.StatusCode, h headers.Headers) response.StatusCode {
+		captured.record(status, h, recorder.Cookies(), recorder.HeaderLines())
+		return status
+	})
+	recorder.WrapBody(captured.tee)
+
+	requestTime := c.now()
+	handlerErr := next(recorder, condReq)
+	if handlerErr != nil {
+		return nil, requestTime, handlerErr
+	}
+	if recorder.Aborted() {
+		return nil, requestTime, &server.HandlerError{
+			StatusCode: response.StatusBadGateway,
+			Message:    "Bad Gateway: revalidation response was cut short",
+		}
+	}
+	recorder.Close()
+
+	if captured.headers == nil {
+		return nil, requestTime, &server.HandlerError{
+			StatusCode: response.StatusBadGateway,
+			Message:    "Bad Gateway: revalidation produced no response",
+		}
+	}
+	return captured, requestTime, nil
+}
+
+// refresh applies a 304's headers to entry and stores the result (RFC 9111 Section 4.3.4).
+func (c *Cache) refresh(key string, req *request.Request, entry *Entry, notModified *capture, requestTime time.Time) *Entry {
+	refreshed := &Entry{
+		StatusCode:   entry.StatusCode,
+		Headers:      headers.NewHeaders(),
+		Body:         entry.Body,
+		RequestTime:  requestTime,
+		ResponseTime: c.now(),
+	}
+	for name, value := range entry.Headers {
+		refreshed.Headers[name] = value
+	}
+	for name, value := range notModified.headers {
+		// The 304 describes the stored body, not its own empty one
+		if name == "content-length" {
+			continue
+		}
+		refreshed.Headers[name] = value
+	}
+	stripUnstorable(refreshed.Headers)
+
+	c.put(key, req, refreshed)
+	return refreshed
+}
+
+// storeCapture stores a captured response if RFC 9111 Section 3 allows it.
+func (c *Cache) storeCapture(key string, req *request.Request, captured *capture, requestTime, responseTime time.Time) {
+	// Cookies are per-client state a shared cache must not hand to anyone else
+	if captured.headers == nil || captured.overflow || captured.setsCookies() {
+		return
+	}
+
+	entry := &Entry{
+		StatusCode:   captured.status,
+		Headers:      captured.headers,
+		Body:         captured.body.Bytes(),
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+	}
+	stripUnstorable(entry.Headers)
+	entry.Headers.Replace("content-length", fmt.Sprintf("%d", len(entry.Body)))
+
+	if !storable(req, entry) {
+		return
+	}
+	c.put(key, req, entry)
+}
+
+// storable reports whether a response to req may be stored by a shared cache.
+func storable(req *request.Request, entry *Entry) bool {
+	reqCC := requestCacheControl(req.Headers)
+	respCC := parseCacheControl(headerValue(entry.Headers, "Cache-Control"))
+
+	if reqCC.has("no-store") || respCC.has("no-store") || respCC.has("private") {
+		return false
+	}
+	if vary, _ := entry.Headers.Get("Vary"); strings.TrimSpace(vary) == "*" {
+		return false
+	}
+	// Authenticated responses are private unless the origin says otherwise (RFC 9111 Section 3.5)
+	if _, ok := req.Headers.Get("Authorization"); ok &&
+		!respCC.has("public") && !respCC.has("s-maxage") && !respCC.has("must-revalidate") {
+		return false
+	}
+
+	// Explicit freshness only counts for status codes whose caching rules we know (RFC 9111 Section 3)
+	explicit := respCC.has("max-age") || respCC.has("s-maxage") || respCC.has("public")
+	if _, ok := entry.Headers.Get("Expires"); ok {
+		explicit = true
+	}
+	if !heuristicallyCacheable[entry.StatusCode] && !(explicit && understoodStatus[entry.StatusCode]) {
+		return false
+	}
+
+	// Only keep what can be served fresh or at least revalidated later
+	_, hasETag := entry.Headers.Get("ETag")
+	_, hasLastModified := entry.Headers.Get("Last-Modified")
+	return entry.freshnessLifetime() > 0 || hasETag || hasLastModified
+}
+
+// lookup finds the stored response for req, following Vary to the matching variant.
+func (c *Cache) lookup(key string, req *request.Request) (*Entry, bool) {
+	entry, ok := c.store.Get(key)
+	if !ok {
+		return nil, false
+	}
+	if len(entry.Vary) == 0 {
+		return entry, true
+	}
+	return c.store.Get(variantKey(key, entry, req))
+}
+
+// put stores entry for req, going through a Vary index entry when the response varies.
+func (c *Cache) put(key string, req *request.Request, entry *Entry) {
+	varyNames := parseVary(headerValue(entry.Headers, "Vary"))
+	if len(varyNames) == 0 {
+		c.store.Set(key, entry)
+		return
+	}
+
+	index, ok := c.store.Get(key)
+	if !ok || !equalNames(index.Vary, varyNames) {
+		// A new generation orphans variants stored under a previous index
+		index = &Entry{Vary: varyNames, Generation: c.now().UnixNano()}
+		c.store.Set(key, index)
+	}
+	c.store.Set(variantKey(key, index, req), entry)
+}
+
+// primaryKey is the cache key of a request: its target (RFC 9111 Section 2).
+// HEAD shares GET's key so it can be answered from stored GET responses.
+func primaryKey(req *request.Request) string {
+	host, _ := req.Headers.Get("Host")
+	target := req.RequestLine.RequestTarget
+	if u, err := url.ParseRequestURI(target); err == nil && u.IsAbs() {
+		return target
+	}
+	return host + target
+}
+
+// variantKey extends key with the request's values for the index entry's Vary headers.
+func variantKey(key string, index *Entry, req *request.Request) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%d", key, index.Generation)
+	for _, name := range index.Vary {
+		value, _ := req.Headers.Get(name)
+		fmt.Fprintf(&b, "\n%s=%s", name, strings.Join(strings.Fields(value), " "))
+	}
+	return b.String()
+}
+
+// parseVary returns the lowercase, sorted header names listed in Vary.
+func parseVary(vary string) []string {
+	var names []string
+	for _, name := range strings.Split(vary, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// isSafe reports whether the method is safe (RFC 9110 Section 9.2.1).
+func isSafe(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+// stripUnstorable removes headers that describe the connection or this cache's own output.
+func stripUnstorable(h headers.Headers) {
+	for _, name := range hopByHopHeaders {
+		delete(h, name)
+	}
+	delete(h, "x-cache")
+}
+
+// writeEntry answers the client from a stored entry, adding Age (RFC 9111 Section 5.1).
+func writeEntry(w *response.Writer, req *request.Request, entry *Entry, now time.Time, status string) {
+	responseHeaders := headers.NewHeaders()
+	for name, value := range entry.Headers {
+		responseHeaders[name] = value
+	}
+	responseHeaders.Replace("age", fmt.Sprintf("%d", int64(entry.currentAge(now).Seconds())))
+	responseHeaders.Replace("content-length", fmt.Sprintf("%d", len(entry.Body)))
+	responseHeaders.Replace("connection", "close")
+	responseHeaders.Replace("x-cache", status)
+
+	w.WriteStatusLine(entry.StatusCode)
+	w.WriteHeaders(responseHeaders)
+	if req.RequestLine.Method != "HEAD" {
+		w.WriteBody(entry.Body)
+	}
+}
+
+// writeCapture answers the client with a response recorded during revalidation.
+func writeCapture(w *response.Writer, req *request.Request, captured *capture) {
+	responseHeaders := headers.NewHeaders()
+	for name, value := range captured.headers {
+		responseHeaders[name] = value
+	}
+	stripUnstorable(responseHeaders)
+	responseHeaders.Replace("content-length", fmt.Sprintf("%d", captured.body.Len()))
+	responseHeaders.Replace("connection", "close")
+	responseHeaders.Replace("x-cache", cacheMiss)
+
+	for _, c := range captured.cookies {
+		w.SetCookie(c)
+	}
+	for _, line := range captured.lines {
+		w.AddHeaderLine(line.Name, line.Value)
+	}
+	w.WriteStatusLine(captured.status)
+	w.WriteHeaders(responseHeaders)
+	if req.RequestLine.Method != "HEAD" {
+		w.WriteBody(captured.body.Bytes())
+	}
+}
+
+// capture records a response as it is written.
+type capture struct {
+	status   response.StatusCode
+	headers  headers.Headers
+	cookies  []*cookie.Cookie
+	lines    []response.HeaderLine
+	body     bytes.Buffer
+	limit    int64 // maximum body bytes kept; negative means unlimited
+	overflow bool
+}
+
+// record keeps a copy of the final status, headers, cookies and separate header lines.
+func (cp *capture) record(status response.StatusCode, h headers.Headers, cookies []*cookie.Cookie, lines []response.HeaderLine) {
+	cp.status = status
+	cp.cookies = cookies
+	cp.lines = lines
+	cp.headers = headers.NewHeaders()
+	for name, value := range h {
+		cp.headers[name] = value
+	}
+}
+
+// setsCookies reports whether the response sets cookies, directly or as relayed Set-Cookie lines.
+func (cp *capture) setsCookies() bool {
+	if len(cp.cookies) > 0 {
+		return true
+	}
+	for _, line := range cp.lines {
+		if strings.EqualFold(line.Name, "Set-Cookie") {
+			return true
+		}
+	}
+	return false
+}
+
+// tee is a body filter that copies the payload into the capture on its way to next.
+func (cp *capture) tee(next io.Writer) io.Writer {
+	return &teeWriter{next: next, capture: cp}
+}
+
+type teeWriter struct {
+	next    io.Writer
+	capture *capture
+}
+
+func (tw *teeWriter) Write(p []byte) (int, error) {
+	cp := tw.capture
+	if !cp.overflow {
+		if cp.limit >= 0 && int64(cp.body.Len()+len(p)) > cp.limit {
+			cp.overflow = true
+			cp.body.Reset()
+		} else {
+			cp.body.Write(p)
+		}
+	}
+	return tw.next.Write(p)
+}
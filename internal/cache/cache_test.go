@@ -0,0 +1,280 @@
+package cache
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/cookie"
+	"github.com/kiefbc/http-server-1.1/internal/headers"
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/kiefbc/http-server-1.1/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// do runs handler for a request to target and parses what it wrote.
+func do(t *testing.T, handler server.Handler, method, target string, extra map[string]string) (*http.Response, string) {
+	t.Helper()
+	return servertest.Do(t, handler, servertest.NewRequest(t, method, target, extra))
+}
+
+// origin is a handler that counts calls and answers with the given headers and body.
+type origin struct {
+	calls   atomic.Int32
+	headers map[string]string
+	body    string
+	etag    string
+	lastReq *request.Request
+}
+
+func (o *origin) handle(w *response.Writer, req *request.Request) *server.HandlerError {
+	o.calls.Add(1)
+	o.lastReq = req
+	if inm, ok := req.Headers.Get("If-None-Match"); ok && o.etag != "" && inm == o.etag {
+		w.WriteStatusLine(response.StatusNotModified)
+		w.WriteHeaders(headers.Headers{"etag": o.etag, "cache-control": o.headers["cache-control"]})
+		return nil
+	}
+
+	responseHeaders := response.GetDefaultHeaders(len(o.body))
+	for name, value := range o.headers {
+		responseHeaders.Replace(name, value)
+	}
+	if o.etag != "" {
+		responseHeaders.Replace("etag", o.etag)
+	}
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(responseHeaders)
+	if req.RequestLine.Method != "HEAD" {
+		w.WriteBody([]byte(o.body))
+	}
+	return nil
+}
+
+// newTestCache returns a cache with a controllable clock.
+func newTestCache() (*Cache, *time.Time) {
+	clock := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := New(NewMemoryStore(1 << 20))
+	c.now = func() time.Time { return clock }
+	return c, &clock
+}
+
+func TestCacheFreshness(t *testing.T) {
+	// Test: max-age responses are served from cache with an Age header
+	c, clock := newTestCache()
+	o := &origin{headers: map[string]string{"cache-control": "max-age=60"}, body: "hello"}
+	h := c.Middleware(o.handle)
+	resp, body := do(t, h, "GET", "/fresh", nil)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "hello", body)
+	*clock = clock.Add(10 * time.Second)
+	resp, body = do(t, h, "GET", "/fresh", nil)
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "10", resp.Header.Get("Age"))
+	assert.Equal(t, "hello", body)
+	assert.Equal(t, int32(1), o.calls.Load())
+
+	// Test: HEAD is answered from the stored GET
+	resp, body = do(t, h, "HEAD", "/fresh", nil)
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "", body)
+
+	// Test: Request max-age=0 forces a trip to the origin
+	do(t, h, "GET", "/fresh", map[string]string{"Cache-Control": "max-age=0"})
+	assert.Equal(t, int32(2), o.calls.Load())
+
+	// Test: no-store responses are never stored
+	o = &origin{headers: map[string]string{"cache-control": "no-store, max-age=60"}, body: "secret"}
+	h = c.Middleware(o.handle)
+	do(t, h, "GET", "/nostore", nil)
+	resp, _ = do(t, h, "GET", "/nostore", nil)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+	assert.Equal(t, int32(2), o.calls.Load())
+
+	// Test: Responses that set cookies are not stored
+	withCookie := func(w *response.Writer, req *request.Request) *server.HandlerError {
+		w.SetCookie(&cookie.Cookie{Name: "sid", Value: "private"})
+		return o.handle(w, req)
+	}
+	h = c.Middleware(withCookie)
+	resp, _ = do(t, h, "GET", "/cookie", nil)
+	assert.Equal(t, "sid=private", resp.Header.Get("Set-Cookie"))
+	resp, _ = do(t, h, "GET", "/cookie", nil)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+	relayed := func(w *response.Writer, req *request.Request) *server.HandlerError {
+		w.AddHeaderLine("Set-Cookie", "sid=upstream; secure")
+		return o.handle(w, req)
+	}
+	h = c.Middleware(relayed)
+	do(t, h, "GET", "/relayed", nil)
+	resp, _ = do(t, h, "GET", "/relayed", nil)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "sid=upstream; secure", resp.Header.Get("Set-Cookie"))
+
+	// Test: Explicit freshness does not make an unknown status storable
+	failing := func(w *response.Writer, req *request.Request) *server.HandlerError {
+		w.WriteStatusLine(response.StatusInternalServerError)
+		w.WriteHeaders(headers.Headers{"cache-control": "max-age=60", "content-length": "0"})
+		return nil
+	}
+	h = c.Middleware(failing)
+	do(t, h, "GET", "/error", nil)
+	resp, _ = do(t, h, "GET", "/error", nil)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+
+	// Test: A response aborted mid-body is neither completed nor stored
+	o = &origin{headers: map[string]string{"cache-control": "max-age=60"}, body: "truncated"}
+	aborted := func(w *response.Writer, req *request.Request) *server.HandlerError {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(headers.Headers{"cache-control": "max-age=60", "transfer-encoding": "chunked"})
+		w.WriteChunkedBody([]byte("trunc"))
+		w.Abort()
+		return nil
+	}
+	var out bytes.Buffer
+	req := servertest.NewRequest(t, "GET", "/aborted", nil)
+	w := response.NewWriter(&out)
+	assert.Nil(t, c.Middleware(aborted)(w, req))
+	w.Close()
+	assert.True(t, strings.HasSuffix(out.String(), "5\r\ntrunc\r\n"), out.String())
+	resp, body = do(t, c.Middleware(o.handle), "GET", "/aborted", nil)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "truncated", body)
+
+	// Test: Expires relative to Date sets the lifetime
+	o = &origin{headers: map[string]string{
+		"date":    clock.Format(http.TimeFormat),
+		"expires": clock.Add(30 * time.Second).Format(http.TimeFormat),
+	}, body: "expiring"}
+	h = c.Middleware(o.handle)
+	do(t, h, "GET", "/expires", nil)
+	*clock = clock.Add(20 * time.Second)
+	resp, _ = do(t, h, "GET", "/expires", nil)
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	*clock = clock.Add(20 * time.Second)
+	resp, _ = do(t, h, "GET", "/expires", nil)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+
+	// Test: only-if-cached without an entry is a 504
+	resp, _ = do(t, h, "GET", "/never-seen", map[string]string{"Cache-Control": "only-if-cached"})
+	assert.Equal(t, 504, resp.StatusCode)
+
+	// Test: POST invalidates the stored GET
+	o = &origin{headers: map[string]string{"cache-control": "max-age=60"}, body: "v1"}
+	h = c.Middleware(o.handle)
+	do(t, h, "GET", "/item", nil)
+	do(t, h, "POST", "/item", nil)
+	resp, _ = do(t, h, "GET", "/item", nil)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+	assert.Equal(t, int32(3), o.calls.Load())
+}
+
+func TestCacheValidation(t *testing.T) {
+	// Test: Stale entries are revalidated with If-None-Match and refreshed on 304
+	c, clock := newTestCache()
+	o := &origin{headers: map[string]string{"cache-control": "max-age=10"}, body: "tagged", etag: `"v1"`}
+	h := c.Middleware(o.handle)
+	do(t, h, "GET", "/tagged", nil)
+	*clock = clock.Add(30 * time.Second)
+	resp, body := do(t, h, "GET", "/tagged", nil)
+	assert.Equal(t, "REVALIDATED", resp.Header.Get("X-Cache"))
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "tagged", body)
+	inm, _ := o.lastReq.Headers.Get("If-None-Match")
+	assert.Equal(t, `"v1"`, inm)
+	resp, _ = do(t, h, "GET", "/tagged", nil)
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Equal(t, int32(2), o.calls.Load())
+
+	// Test: A changed resource replaces the entry
+	*clock = clock.Add(30 * time.Second)
+	o.etag = `"v2"`
+	o.body = "changed"
+	resp, body = do(t, h, "GET", "/tagged", nil)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "changed", body)
+	_, body = do(t, h, "GET", "/tagged", nil)
+	assert.Equal(t, "changed", body)
+
+	// Test: A HEAD on a stale, changed entry fetches the full body for the next GET
+	*clock = clock.Add(30 * time.Second)
+	o.etag = `"v3"`
+	o.body = "changed again"
+	resp, body = do(t, h, "HEAD", "/tagged", nil)
+	assert.Equal(t, "MISS", resp.Header.Get("X-Cache"))
+	assert.Equal(t, int64(len("changed again")), resp.ContentLength)
+	assert.Equal(t, "", body)
+	assert.Equal(t, "GET", o.lastReq.RequestLine.Method)
+	resp, body = do(t, h, "GET", "/tagged", nil)
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "changed again", body)
+
+	// Test: stale-while-revalidate serves stale and refreshes in the background
+	o = &origin{headers: map[string]string{"cache-control": "max-age=10, stale-while-revalidate=60"}, body: "swr", etag: `"s1"`}
+	h = c.Middleware(o.handle)
+	do(t, h, "GET", "/swr", nil)
+	*clock = clock.Add(20 * time.Second)
+	resp, body = do(t, h, "GET", "/swr", nil)
+	assert.Equal(t, "STALE", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "swr", body)
+	assert.Eventually(t, func() bool { return o.calls.Load() == 2 }, time.Second, 5*time.Millisecond)
+	settled := func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.revalidating) == 0
+	}
+	require.Eventually(t, settled, time.Second, 5*time.Millisecond)
+
+	// Test: A HEAD that revalidates in the background keeps the stored body
+	*clock = clock.Add(20 * time.Second)
+	o.etag = `"s2"`
+	o.body = "swr again"
+	resp, _ = do(t, h, "HEAD", "/swr", nil)
+	assert.Equal(t, "STALE", resp.Header.Get("X-Cache"))
+	require.Eventually(t, func() bool { return o.calls.Load() == 3 && settled() }, time.Second, 5*time.Millisecond)
+	resp, body = do(t, h, "GET", "/swr", nil)
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Equal(t, "swr again", body)
+
+	// Test: Vary keeps one entry per header value
+	o = &origin{headers: map[string]string{"cache-control": "max-age=60", "vary": "Accept-Language"}, body: "varied"}
+	h = c.Middleware(o.handle)
+	do(t, h, "GET", "/vary", map[string]string{"Accept-Language": "en"})
+	do(t, h, "GET", "/vary", map[string]string{"Accept-Language": "fr"})
+	resp, _ = do(t, h, "GET", "/vary", map[string]string{"Accept-Language": "en"})
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	resp, _ = do(t, h, "GET", "/vary", map[string]string{"Accept-Language": "fr"})
+	assert.Equal(t, "HIT", resp.Header.Get("X-Cache"))
+	assert.Equal(t, int32(2), o.calls.Load())
+}
+
+func TestStores(t *testing.T) {
+	// Test: MemoryStore evicts the least recently used entry over its cap
+	ms := NewMemoryStore(400)
+	ms.Set("a", &Entry{Body: make([]byte, 100)})
+	ms.Set("b", &Entry{Body: make([]byte, 100)})
+	ms.Get("a")
+	ms.Set("c", &Entry{Body: make([]byte, 100)})
+	_, ok := ms.Get("b")
+	assert.False(t, ok)
+	_, ok = ms.Get("a")
+	assert.True(t, ok)
+
+	// Test: DiskStore round-trips entries and deletes them
+	ds, err := NewDiskStore(t.TempDir())
+	require.NoError(t, err)
+	ds.Set("key", &Entry{StatusCode: 200, Headers: headers.Headers{"etag": `"x"`}, Body: []byte("on disk")})
+	entry, ok := ds.Get("key")
+	require.True(t, ok)
+	assert.Equal(t, "on disk", string(entry.Body))
+	assert.Equal(t, `"x"`, entry.Headers["etag"])
+	ds.Delete("key")
+	_, ok = ds.Get("key")
+	assert.False(t, ok)
+}
@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/headers"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+)
+
+// maxHeuristicLifetime caps the freshness guessed from Last-Modified.
+const maxHeuristicLifetime = 24 * time.Hour
+
+// heuristicallyCacheable lists status codes that may be cached without explicit freshness
+// (RFC 9110 Section 15.1).
+var heuristicallyCacheable = map[response.StatusCode]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// understoodStatus lists the status codes the cache will store when the response carries
+// explicit freshness: the heuristically cacheable ones plus temporary redirects.
+var understoodStatus = map[response.StatusCode]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 302: true, 307: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// cacheControl holds parsed Cache-Control directives (RFC 9111 Section 5.2), keyed by lowercase name.
+type cacheControl map[string]string
+
+// parseCacheControl parses a Cache-Control field value. Directive names are case-insensitive
+// and quoted argument values are unquoted.
+func parseCacheControl(value string) cacheControl {
+	cc := cacheControl{}
+	for _, directive := range strings.Split(value, ",") {
+		directive = strings.TrimSpace(directive)
+		if directive == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(directive, "=")
+		cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+	}
+	return cc
+}
+
+// requestCacheControl parses the request's Cache-Control, treating Pragma: no-cache
+// as no-cache when Cache-Control is absent (RFC 9111 Section 5.4).
+func requestCacheControl(h headers.Headers) cacheControl {
+	if value, ok := h.Get("Cache-Control"); ok {
+		return parseCacheControl(value)
+	}
+	cc := cacheControl{}
+	if pragma, ok := h.Get("Pragma"); ok && strings.Contains(strings.ToLower(pragma), "no-cache") {
+		cc["no-cache"] = ""
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns a delta-seconds argument. Invalid values are reported as absent.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	arg, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// httpDate parses an HTTP-date header value (RFC 9110 Section 5.6.7).
+func httpDate(h headers.Headers, name string) (time.Time, bool) {
+	value, ok := h.Get(name)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// freshnessLifetime computes how long the entry stays fresh for a shared cache
+// (RFC 9111 Section 4.2.1).
+func (e *Entry) freshnessLifetime() time.Duration {
+	cc := parseCacheControl(headerValue(e.Headers, "Cache-Control"))
+	if lifetime, ok := cc.seconds("s-maxage"); ok {
+		return lifetime
+	}
+	if lifetime, ok := cc.seconds("max-age"); ok {
+		return lifetime
+	}
+
+	date, ok := httpDate(e.Headers, "Date")
+	if !ok {
+		date = e.ResponseTime
+	}
+	if _, present := e.Headers.Get("Expires"); present {
+		expires, ok := httpDate(e.Headers, "Expires")
+		if !ok {
+			// An invalid Expires means already expired
+			return 0
+		}
+		return max(expires.Sub(date), 0)
+	}
+
+	// Heuristic freshness: 10% of the time since the last modification (RFC 9111 Section 4.2.2)
+	if lastModified, ok := httpDate(e.Headers, "Last-Modified"); ok && heuristicallyCacheable[e.StatusCode] {
+		return min(date.Sub(lastModified)/10, maxHeuristicLifetime)
+	}
+	return 0
+}
+
+// currentAge computes the entry's age at now (RFC 9111 Section 4.2.3).
+func (e *Entry) currentAge(now time.Time) time.Duration {
+	apparentAge := time.Duration(0)
+	if date, ok := httpDate(e.Headers, "Date"); ok {
+		apparentAge = max(e.ResponseTime.Sub(date), 0)
+	}
+
+	ageValue := time.Duration(0)
+	if age, err := strconv.ParseInt(headerValue(e.Headers, "Age"), 10, 64); err == nil && age > 0 {
+		ageValue = time.Duration(age) * time.Second
+	}
+
+	responseDelay := e.ResponseTime.Sub(e.RequestTime)
+	correctedInitialAge := max(apparentAge, ageValue+responseDelay)
+	residentTime := now.Sub(e.ResponseTime)
+	return correctedInitialAge + residentTime
+}
+
+// headerValue returns the header value or "" when absent.
+func headerValue(h headers.Headers, name string) string {
+	value, _ := h.Get(name)
+	return value
+}
@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/headers"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+)
+
+// Entry is a stored response.
+type Entry struct {
+	StatusCode response.StatusCode
+	Headers    headers.Headers
+	Body       []byte
+	// RequestTime and ResponseTime bracket the request that produced the entry; they feed
+	// the age calculation.
+	RequestTime  time.Time
+	ResponseTime time.Time
+
+	// Vary is only set on the entry stored under a primary key when the response varies:
+	// such an entry has no body and points at per-variant entries via Generation.
+	Vary       []string
+	Generation int64
+}
+
+// size approximates the memory an entry holds.
+func (e *Entry) size() int64 {
+	n := int64(len(e.Body)) + 64
+	for key, value := range e.Headers {
+		n += int64(len(key) + len(value))
+	}
+	return n
+}
+
+// Store persists cache entries by key. Implementations must be safe for concurrent use.
+type Store interface {
+	Get(key string) (*Entry, bool)
+	Set(key string, entry *Entry)
+	Delete(key string)
+}
+
+// MemoryStore is an in-memory Store that evicts least recently used entries once the
+// total size exceeds its cap.
+type MemoryStore struct {
+	maxBytes int64
+
+	mu    sync.Mutex
+	size  int64
+	order *list.List // front is most recently used
+	items map[string]*list.Element
+}
+
+type memoryItem struct {
+	key   string
+	entry *Entry
+}
+
+// NewMemoryStore creates a MemoryStore holding at most maxBytes of responses.
+func NewMemoryStore(maxBytes int64) *MemoryStore {
+	return &MemoryStore{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (ms *MemoryStore) Get(key string) (*Entry, bool) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	element, ok := ms.items[key]
+	if !ok {
+		return nil, false
+	}
+	ms.order.MoveToFront(element)
+	return element.Value.(*memoryItem).entry, true
+}
+
+func (ms *MemoryStore) Set(key string, entry *Entry) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if entry.size() > ms.maxBytes {
+		ms.remove(key)
+		return
+	}
+
+	if element, ok := ms.items[key]; ok {
+		item := element.Value.(*memoryItem)
+		ms.size += entry.size() - item.entry.size()
+		item.entry = entry
+		ms.order.MoveToFront(element)
+	} else {
+		ms.items[key] = ms.order.PushFront(&memoryItem{key: key, entry: entry})
+		ms.size += entry.size()
+	}
+
+	for ms.size > ms.maxBytes {
+		oldest := ms.order.Back()
+		ms.remove(oldest.Value.(*memoryItem).key)
+	}
+}
+
+func (ms *MemoryStore) Delete(key string) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.remove(key)
+}
+
+// remove deletes key; the caller holds ms.mu.
+func (ms *MemoryStore) remove(key string) {
+	element, ok := ms.items[key]
+	if !ok {
+		return
+	}
+	ms.size -= element.Value.(*memoryItem).entry.size()
+	ms.order.Remove(element)
+	delete(ms.items, key)
+}
+
+// DiskStore is a Store that keeps one gob-encoded file per entry in a directory,
+// so cached responses survive restarts.
+type DiskStore struct {
+	dir string
+}
+
+// NewDiskStore creates a DiskStore in dir, creating the directory if needed.
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	return &DiskStore{dir: dir}, nil
+}
+
+// path maps a key to a file name that is safe whatever the key contains.
+func (ds *DiskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(ds.dir, hex.EncodeToString(sum[:])+".entry")
+}
+
+// Get returns the entry for key. Unreadable or corrupt files are treated as misses.
+func (ds *DiskStore) Get(key string) (*Entry, bool) {
+	data, err := os.ReadFile(ds.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	var entry Entry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		return nil, false
+	}
+	return &entry, true
+}
+
+// Set writes the entry to a temporary file and renames it into place so readers never see
+// a partial entry. Failures are dropped; a cache that cannot store just misses.
+func (ds *DiskStore) Set(key string, entry *Entry) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(entry); err != nil {
+		return
+	}
+
+	tmp, err := os.CreateTemp(ds.dir, "tmp-*")
+	if err != nil {
+		return
+	}
+	_, writeErr := tmp.Write(buf.Bytes())
+	closeErr := tmp.Close()
+	if writeErr != nil || closeErr != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	if err := os.Rename(tmp.Name(), ds.path(key)); err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+func (ds *DiskStore) Delete(key string) {
+	os.Remove(ds.path(key))
+}
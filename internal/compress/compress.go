@@ -0,0 +1,230 @@
+package compress
+
+import (
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/kiefbc/http-server-1.1/internal/headers"
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+)
+
+// DefaultMinSize is the smallest body worth compressing; below it the coding overhead
+// outweighs the savings.
+const DefaultMinSize = 1024
+
+// Compressor is a middleware that compresses response bodies with the best content coding
+// the client accepts. Because the compressed length is not known up front, compressed
+// responses are switched to chunked transfer coding.
+type Compressor struct {
+	encoders []Encoder
+
+	// MinSize skips bodies whose Content-Length is below it. Bodies of unknown length are
+	// always compressed.
+	MinSize int
+	// SkipTypes lists media types that are already compressed. A trailing "/*" matches a
+	// whole top-level type.
+	SkipTypes []string
+}
+
+// New creates a Compressor that offers the given encoders, in order of preference when the
+// client rates several equally. Without encoders it offers gzip and then deflate.
+func New(encoders ...Encoder) *Compressor {
+	if len(encoders) == 0 {
+		encoders = []Encoder{Gzip(DefaultLevel), Deflate(DefaultLevel)}
+	}
+	return &Compressor{
+		encoders: encoders,
+		MinSize:  DefaultMinSize,
+		SkipTypes: []string{
+			"image/*", "video/*", "audio/*",
+			"application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+			"application/x-7z-compressed", "application/x-rar-compressed", "application/x-bzip2",
+			"application/pdf", "font/woff", "font/woff2",
+		},
+	}
+}
+
+// Middleware negotiates a content coding for each request and compresses the handler's body.
+func (c *Compressor) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		acceptEncoding, _ := req.Headers.Get("Accept-Encoding")
+		encoder := c.negotiate(acceptEncoding)
+
+		filter := &compressor{}
+		w.WrapBody(func(body io.Writer) io.Writer {
+			filter.next = body
+			return filter
+		})
+		w.OnWriteHeaders(func(status response.StatusCode, h headers.Headers) response.StatusCode {
+			// The choice of coding depends on the request, so caches must key on it (RFC 9110 Section 12.5.5)
+			h.AddVary("Accept-Encoding")
+			if encoder == nil || !c.shouldCompress(status, h) {
+				return status
+			}
+
+			// A HEAD response has no body to compress, and changing its framing would make the
+			// Writer send a chunked terminator after the headers. Its Content-Length describes
+			// the identity representation, so it is left uncoded.
+			if req.RequestLine.Method == "HEAD" {
+				return status
+			}
+			writer, err := encoder.NewWriter(filter.next)
+			if err != nil {
+				return status
+			}
+			filter.encoder = writer
+
+			delete(h, "content-length")
+			h.Replace("content-encoding", encoder.Encoding())
+			h.Replace("transfer-encoding", "chunked")
+			// The compressed bytes differ from the identity ones, so a strong validator no longer holds
+			if etag, ok := h.Get("ETag"); ok && !strings.HasPrefix(etag, "W/") {
+				h.Replace("etag", "W/"+etag)
+			}
+			return status
+		})
+
+		return next(w, req)
+	}
+}
+
+// shouldCompress decides from the final status and headers whether a body is worth compressing.
+func (c *Compressor) shouldCompress(status response.StatusCode, h headers.Headers) bool {
+	// 206 bodies are byte ranges of the identity representation
+	if status < 200 || status >= 300 || status == response.StatusNoContent || status == response.StatusPartialContent {
+		return false
+	}
+	if _, ok := h.Get("Content-Encoding"); ok {
+		return false
+	}
+	if cacheControl, ok := h.Get("Cache-Control"); ok && strings.Contains(strings.ToLower(cacheControl), "no-transform") {
+		return false
+	}
+	if contentLength, ok := h.Get("Content-Length"); ok {
+		if n, err := strconv.Atoi(contentLength); err == nil && n < c.MinSize {
+			return false
+		}
+	}
+
+	contentType, _ := h.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType == ""
+	}
+	for _, skip := range c.SkipTypes {
+		if prefix, ok := strings.CutSuffix(skip, "/*"); ok {
+			// SVG is text even though it is an image
+			if strings.HasPrefix(mediaType, prefix+"/") && mediaType != "image/svg+xml" {
+				return false
+			}
+		} else if mediaType == skip {
+			return false
+		}
+	}
+	return true
+}
+
+// negotiate picks the encoder the client rates highest in Accept-Encoding
+// (RFC 9110 Section 12.5.3). It returns nil when identity should be sent: no header, no
+// acceptable encoder, or identity rated above every encoder.
+func (c *Compressor) negotiate(acceptEncoding string) Encoder {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return nil
+	}
+
+	qvalues := parseAcceptEncoding(acceptEncoding)
+	wildcard, hasWildcard := qvalues["*"]
+	weight := func(coding string) float64 {
+		if q, ok := qvalues[coding]; ok {
+			return q
+		}
+		if hasWildcard {
+			return wildcard
+		}
+		return 0
+	}
+
+	var best Encoder
+	bestQ := 0.0
+	for _, encoder := range c.encoders {
+		if q := weight(encoder.Encoding()); q > bestQ {
+			best, bestQ = encoder, q
+		}
+	}
+
+	// A client may rate identity above every coding it accepts
+	if identityQ, ok := qvalues["identity"]; ok && identityQ > bestQ {
+		return nil
+	}
+	return best
+}
+
+// parseAcceptEncoding maps each listed coding to its q-value. Codings without a valid
+// q parameter get 1; x-gzip is an alias for gzip (RFC 9110 Section 8.4.1.3).
+func parseAcceptEncoding(value string) map[string]float64 {
+	qvalues := make(map[string]float64)
+	for _, item := range strings.Split(value, ",") {
+		coding, params, _ := strings.Cut(item, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		if coding == "x-gzip" {
+			coding = "gzip"
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			if strings.EqualFold(strings.TrimSpace(name), "q") {
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && parsed >= 0 && parsed <= 1 {
+					q = parsed
+				}
+			}
+		}
+		qvalues[coding] = q
+	}
+	return qvalues
+}
+
+// compressor is the body filter. It passes bytes through until a header hook hands it an
+// encoder, and then compresses everything the handler writes.
+type compressor struct {
+	next    io.Writer
+	encoder io.WriteCloser
+}
+
+type flusher interface {
+	Flush() error
+}
+
+func (c *compressor) Write(p []byte) (int, error) {
+	if c.encoder == nil {
+		return c.next.Write(p)
+	}
+	n, err := c.encoder.Write(p)
+	if err != nil {
+		return n, err
+	}
+	// Flushing per write keeps streamed responses moving at a small cost in ratio
+	if f, ok := c.encoder.(flusher); ok {
+		if err := f.Flush(); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Close writes the end of the compressed stream.
+func (c *compressor) Close() error {
+	if c.encoder == nil {
+		return nil
+	}
+	encoder := c.encoder
+	c.encoder = nil
+	return encoder.Close()
+}
@@ -0,0 +1,253 @@
+package compress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/kiefbc/http-server-1.1/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// do runs handler for a request to /page and parses what it wrote. The body is returned as
+// sent, without undoing any content coding.
+func do(t *testing.T, handler server.Handler, method string, extra map[string]string) (*http.Response, []byte) {
+	t.Helper()
+	resp, body := servertest.Do(t, handler, servertest.NewRequest(t, method, "/page", extra))
+	return resp, []byte(body)
+}
+
+// serve returns a handler that answers with body and the given headers.
+func serve(body string, extra map[string]string) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		responseHeaders := response.GetDefaultHeaders(len(body))
+		for name, value := range extra {
+			responseHeaders.Replace(name, value)
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(responseHeaders)
+		if req.RequestLine.Method != "HEAD" {
+			w.WriteBody([]byte(body))
+		}
+		return nil
+	}
+}
+
+func gunzip(t *testing.T, data []byte) string {
+	t.Helper()
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	require.NoError(t, err)
+	out, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return string(out)
+}
+
+func TestNegotiate(t *testing.T) {
+	c := New()
+	encoding := func(acceptEncoding string) string {
+		if encoder := c.negotiate(acceptEncoding); encoder != nil {
+			return encoder.Encoding()
+		}
+		return ""
+	}
+
+	// Test: Server preference breaks ties
+	assert.Equal(t, "gzip", encoding("deflate, gzip"))
+
+	// Test: Higher q-values win and q=0 excludes
+	assert.Equal(t, "deflate", encoding("gzip;q=0.5, deflate"))
+	assert.Equal(t, "deflate", encoding("gzip;q=0, deflate;q=0.1"))
+	assert.Equal(t, "", encoding("gzip;q=0, deflate;q=0"))
+
+	// Test: Wildcard covers unlisted codings and x-gzip aliases gzip
+	assert.Equal(t, "deflate", encoding("gzip;q=0, *"))
+	assert.Equal(t, "gzip", encoding("x-gzip"))
+
+	// Test: Missing header, unknown codings and preferred identity send identity
+	assert.Equal(t, "", encoding(""))
+	assert.Equal(t, "", encoding("br"))
+	assert.Equal(t, "", encoding("identity, gzip;q=0.5"))
+}
+
+func TestMiddleware(t *testing.T) {
+	c := New()
+	page := strings.Repeat("<p>compress me</p>", 200)
+
+	// Test: Large text bodies are gzipped, chunked and marked with Vary
+	resp, body := do(t, c.Middleware(serve(page, map[string]string{"content-type": "text/html"})), "GET", map[string]string{"Accept-Encoding": "gzip"})
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, []string{"chunked"}, resp.TransferEncoding)
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Less(t, len(body), len(page))
+	assert.Equal(t, page, gunzip(t, body))
+
+	// Test: deflate uses the zlib format
+	_, body = do(t, c.Middleware(serve(page, nil)), "GET", map[string]string{"Accept-Encoding": "deflate"})
+	reader, err := zlib.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	inflated, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(inflated))
+
+	// Test: Clients that do not ask get identity, still with Vary
+	resp, body = do(t, c.Middleware(serve(page, nil)), "GET", nil)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, page, string(body))
+
+	// Test: Tiny bodies and compressed types are left alone
+	resp, body = do(t, c.Middleware(serve("tiny", nil)), "GET", map[string]string{"Accept-Encoding": "gzip"})
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "tiny", string(body))
+	resp, _ = do(t, c.Middleware(serve(page, map[string]string{"content-type": "image/png"})), "GET", map[string]string{"Accept-Encoding": "gzip"})
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	resp, _ = do(t, c.Middleware(serve(page, map[string]string{"content-type": "image/svg+xml"})), "GET", map[string]string{"Accept-Encoding": "gzip"})
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+	// Test: Existing Vary is extended and strong ETags are weakened
+	resp, _ = do(t, c.Middleware(serve(page, map[string]string{"vary": "Accept-Language", "etag": `"abc"`})), "GET", map[string]string{"Accept-Encoding": "gzip"})
+	assert.Equal(t, "Accept-Language, Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, `W/"abc"`, resp.Header.Get("ETag"))
+
+	// Test: HEAD keeps its framing and gets no body or chunk terminator
+	resp, body = do(t, c.Middleware(serve(page, nil)), "HEAD", map[string]string{"Accept-Encoding": "gzip"})
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+	assert.Equal(t, int64(len(page)), resp.ContentLength)
+	assert.Empty(t, resp.TransferEncoding)
+	assert.Empty(t, body)
+
+	// Test: A handler that writes a body for HEAD has it dropped
+	resp, body = do(t, c.Middleware(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetChunkedHeaders())
+		w.WriteChunkedBody([]byte(page))
+		return nil
+	}), "HEAD", map[string]string{"Accept-Encoding": "gzip"})
+	assert.Empty(t, body)
+
+	// Test: Streamed chunked bodies are compressed as they are written
+	streamed := func(w *response.Writer, req *request.Request) *server.HandlerError {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetChunkedHeaders())
+		w.WriteChunkedBody([]byte("first,"))
+		w.WriteChunkedBody([]byte("second"))
+		w.WriteChunkedBodyDone()
+		w.WriteTrailersDone()
+		return nil
+	}
+	resp, body = do(t, c.Middleware(streamed), "GET", map[string]string{"Accept-Encoding": "gzip"})
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "first,second", gunzip(t, body))
+}
+
+// upper is a toy coding that shows encoders are pluggable.
+type upper struct{}
+
+func (upper) Encoding() string { return "x-upper" }
+
+func (upper) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return upperWriter{w}, nil
+}
+
+type upperWriter struct {
+	io.Writer
+}
+
+func (u upperWriter) Write(p []byte) (int, error) {
+	return u.Writer.Write(bytes.ToUpper(p))
+}
+
+func (upperWriter) Close() error { return nil }
+
+func TestCustomEncoder(t *testing.T) {
+	// Test: A custom Encoder is negotiated and used
+	c := New(upper{})
+	c.MinSize = 0
+	resp, body := do(t, c.Middleware(serve("hello", nil)), "GET", map[string]string{"Accept-Encoding": "x-upper, gzip"})
+	assert.Equal(t, "x-upper", resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "HELLO", string(body))
+}
+
+// doBody runs handler for a POST carrying body with the given Content-Encoding.
+func doBody(t *testing.T, handler server.Handler, contentEncoding string, body []byte) (*http.Response, []byte) {
+	t.Helper()
+	raw := fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: example.com\r\nContent-Encoding: %s\r\nContent-Length: %d\r\n\r\n", contentEncoding, len(body))
+	req, err := request.RequestFromReader(io.MultiReader(strings.NewReader(raw), bytes.NewReader(body)))
+	require.NoError(t, err)
+	resp, respBody := servertest.Do(t, handler, req)
+	return resp, []byte(respBody)
+}
+
+func TestBodyDecoder(t *testing.T) {
+	var seen *request.Request
+	echo := func(w *response.Writer, req *request.Request) *server.HandlerError {
+		seen = req
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(req.Body)))
+		w.WriteBody(req.Body)
+		return nil
+	}
+	bd := NewBodyDecoder()
+	handler := bd.Middleware(echo)
+	payload := strings.Repeat("upload ", 100)
+
+	// Test: gzip bodies are decoded and the headers describe the plain body
+	var gz bytes.Buffer
+	gzw := gzip.NewWriter(&gz)
+	gzw.Write([]byte(payload))
+	gzw.Close()
+	resp, body := doBody(t, handler, "gzip", gz.Bytes())
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, payload, string(body))
+	_, hasEncoding := seen.Headers.Get("Content-Encoding")
+	assert.False(t, hasEncoding)
+	contentLength, _ := seen.Headers.Get("Content-Length")
+	assert.Equal(t, fmt.Sprintf("%d", len(payload)), contentLength)
+
+	// Test: deflate accepts both zlib and raw deflate streams
+	var zl bytes.Buffer
+	zw := zlib.NewWriter(&zl)
+	zw.Write([]byte(payload))
+	zw.Close()
+	_, body = doBody(t, handler, "deflate", zl.Bytes())
+	assert.Equal(t, payload, string(body))
+	var raw bytes.Buffer
+	fw, _ := flate.NewWriter(&raw, flate.DefaultCompression)
+	fw.Write([]byte(payload))
+	fw.Close()
+	_, body = doBody(t, handler, "deflate", raw.Bytes())
+	assert.Equal(t, payload, string(body))
+
+	// Test: Stacked codings are undone in reverse order
+	var stacked bytes.Buffer
+	zw = zlib.NewWriter(&stacked)
+	zw.Write(gz.Bytes())
+	zw.Close()
+	_, body = doBody(t, handler, "gzip, deflate", stacked.Bytes())
+	assert.Equal(t, payload, string(body))
+
+	// Test: Unsupported codings are refused with 415 and Accept-Encoding
+	resp, _ = doBody(t, handler, "br", []byte("???"))
+	assert.Equal(t, 415, resp.StatusCode)
+	assert.Equal(t, "gzip, deflate", resp.Header.Get("Accept-Encoding"))
+
+	// Test: Bodies that decode past the limit are refused with 413
+	bd.MaxBytes = 100
+	resp, _ = doBody(t, handler, "gzip", gz.Bytes())
+	assert.Equal(t, 413, resp.StatusCode)
+
+	// Test: Corrupt data is a 400
+	resp, _ = doBody(t, handler, "gzip", []byte("not gzip at all"))
+	assert.Equal(t, 400, resp.StatusCode)
+}
@@ -0,0 +1,196 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/kiefbc/http-server-1.1/internal/headers"
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+)
+
+// DefaultMaxDecodedBytes caps how large a request body may grow when it is decompressed.
+const DefaultMaxDecodedBytes = 10 << 20
+
+// Decoder undoes one content coding of a request body.
+type Decoder interface {
+	// Encoding is the content-coding token it handles, e.g. "gzip".
+	Encoding() string
+	// NewReader returns a reader for the decoded form of r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+type gzipDecoder struct{}
+
+// GzipDecoder returns a Decoder for the gzip coding.
+func GzipDecoder() Decoder {
+	return gzipDecoder{}
+}
+
+func (gzipDecoder) Encoding() string {
+	return "gzip"
+}
+
+func (gzipDecoder) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+type deflateDecoder struct{}
+
+// DeflateDecoder returns a Decoder for the deflate coding. Some clients send a raw deflate
+// stream instead of the zlib format the coding names, so both are accepted.
+func DeflateDecoder() Decoder {
+	return deflateDecoder{}
+}
+
+func (deflateDecoder) Encoding() string {
+	return "deflate"
+}
+
+func (deflateDecoder) NewReader(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	// A zlib header is CMF FLG with CM=8 and a check value that divides by 31 (RFC 1950 Section 2.2)
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
+
+// BodyDecoder is an opt-in middleware that decompresses request bodies sent with
+// Content-Encoding, so handlers only ever see the plain content.
+type BodyDecoder struct {
+	decoders map[string]Decoder
+	accepted string
+
+	// MaxBytes is the largest decoded body allowed. Anything bigger is refused with 413,
+	// which stops a small upload from expanding into gigabytes.
+	MaxBytes int64
+}
+
+// NewBodyDecoder creates a BodyDecoder for the given decoders, or gzip and deflate if none are given.
+func NewBodyDecoder(decoders ...Decoder) *BodyDecoder {
+	if len(decoders) == 0 {
+		decoders = []Decoder{GzipDecoder(), DeflateDecoder()}
+	}
+
+	bd := &BodyDecoder{
+		decoders: make(map[string]Decoder, len(decoders)),
+		MaxBytes: DefaultMaxDecodedBytes,
+	}
+	var names []string
+	for _, decoder := range decoders {
+		bd.decoders[decoder.Encoding()] = decoder
+		names = append(names, decoder.Encoding())
+	}
+	bd.accepted = strings.Join(names, ", ")
+	return bd
+}
+
+// errTooLarge is returned when a decoded body passes MaxBytes.
+var errTooLarge = errors.New("decoded body too large")
+
+// Middleware decodes the request body before calling next. The request next sees has no
+// Content-Encoding and a Content-Length matching the decoded body.
+// Unsupported codings get 415, bodies that decode past MaxBytes get 413, and corrupt data gets 400.
+func (bd *BodyDecoder) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		contentEncoding, ok := req.Headers.Get("Content-Encoding")
+		if !ok {
+			return next(w, req)
+		}
+
+		// Codings are listed in the order they were applied (RFC 9110 Section 8.4)
+		var codings []string
+		for _, coding := range strings.Split(contentEncoding, ",") {
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "x-gzip" {
+				coding = "gzip"
+			}
+			if coding != "" && coding != "identity" {
+				codings = append(codings, coding)
+			}
+		}
+
+		for _, coding := range codings {
+			if _, ok := bd.decoders[coding]; !ok {
+				bd.unsupported(w, coding)
+				return nil
+			}
+		}
+
+		if len(req.Body) == 0 {
+			return next(w, req)
+		}
+
+		body := req.Body
+		for i := len(codings) - 1; i >= 0; i-- {
+			decoded, err := bd.decode(bd.decoders[codings[i]], body)
+			if errors.Is(err, errTooLarge) {
+				return &server.HandlerError{
+					StatusCode: response.StatusContentTooLarge,
+					Message:    fmt.Sprintf("Content Too Large: decoded body exceeds %d bytes", bd.MaxBytes),
+				}
+			}
+			if err != nil {
+				return &server.HandlerError{
+					StatusCode: response.StatusBadRequest,
+					Message:    fmt.Sprintf("Bad Request: invalid %s body: %v", codings[i], err),
+				}
+			}
+			body = decoded
+		}
+
+		decodedReq := *req
+		decodedReq.Headers = headers.NewHeaders()
+		for name, value := range req.Headers {
+			decodedReq.Headers[name] = value
+		}
+		delete(decodedReq.Headers, "content-encoding")
+		decodedReq.Headers.Replace("content-length", fmt.Sprintf("%d", len(body)))
+		decodedReq.Body = body
+
+		return next(w, &decodedReq)
+	}
+}
+
+// decode runs body through decoder, stopping once the output passes MaxBytes.
+func (bd *BodyDecoder) decode(decoder Decoder, body []byte) ([]byte, error) {
+	reader, err := decoder.NewReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	decoded, err := io.ReadAll(io.LimitReader(reader, bd.MaxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decoded)) > bd.MaxBytes {
+		return nil, errTooLarge
+	}
+	return decoded, nil
+}
+
+// unsupported answers 415 and lists the codings that would have been accepted
+// (RFC 9110 Section 15.5.16).
+func (bd *BodyDecoder) unsupported(w *response.Writer, coding string) {
+	body := []byte(fmt.Sprintf("Unsupported Media Type: content coding %q", coding))
+	responseHeaders := response.GetDefaultHeaders(len(body))
+	responseHeaders.Replace("accept-encoding", bd.accepted)
+
+	w.WriteStatusLine(response.StatusUnsupportedMediaType)
+	w.WriteHeaders(responseHeaders)
+	w.WriteBody(body)
+}
@@ -0,0 +1,57 @@
+package compress
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+)
+
+// Encoder produces one content coding (RFC 9110 Section 8.4.1). Implement it to add codings
+// such as zstd or br without this package depending on them.
+type Encoder interface {
+	// Encoding is the content-coding token sent in Content-Encoding, e.g. "gzip".
+	Encoding() string
+	// NewWriter returns a writer that compresses into w. Close must flush everything
+	// still buffered. If the writer has a Flush() error method it is used to push out
+	// data after every write so streamed responses are not held back.
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+}
+
+type gzipEncoder struct {
+	level int
+}
+
+// Gzip returns an Encoder for the gzip coding at the given compress/gzip level.
+func Gzip(level int) Encoder {
+	return gzipEncoder{level: level}
+}
+
+func (e gzipEncoder) Encoding() string {
+	return "gzip"
+}
+
+func (e gzipEncoder) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, e.level)
+}
+
+type deflateEncoder struct {
+	level int
+}
+
+// Deflate returns an Encoder for the deflate coding at the given compress/flate level.
+// HTTP's deflate is the zlib format (RFC 9110 Section 8.4.1.2), not a raw deflate stream.
+func Deflate(level int) Encoder {
+	return deflateEncoder{level: level}
+}
+
+func (e deflateEncoder) Encoding() string {
+	return "deflate"
+}
+
+func (e deflateEncoder) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zlib.NewWriterLevel(w, e.level)
+}
+
+// DefaultLevel is the compression level used by New for its default encoders.
+const DefaultLevel = flate.DefaultCompression
@@ -0,0 +1,195 @@
+package conditional
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/headers"
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+)
+
+// Validators describe the current representation of the target resource.
+type Validators struct {
+	// ETag is the entity tag, including quotes and any W/ prefix. Empty if there is none.
+	ETag string
+	// LastModified is the modification time. The zero time means it is unknown.
+	LastModified time.Time
+	// Missing is set when the target has no current representation, e.g. a PUT that would
+	// create it. "*" then fails If-Match and passes If-None-Match.
+	Missing bool
+}
+
+// fromHeaders reads the validators a handler put in its response headers.
+func fromHeaders(h headers.Headers) Validators {
+	var v Validators
+	v.ETag, _ = h.Get("ETag")
+	if lastModified, ok := h.Get("Last-Modified"); ok {
+		v.LastModified, _ = http.ParseTime(lastModified)
+	}
+	return v
+}
+
+// Result is the outcome of evaluating a request's preconditions.
+type Result int
+
+const (
+	// Proceed means every precondition passed and the request should be handled normally.
+	Proceed Result = iota
+	// NotModified means the client's cached copy is current; answer 304.
+	NotModified
+	// PreconditionFailed means the client's assumptions about the resource are wrong; answer 412.
+	PreconditionFailed
+)
+
+// Evaluate checks the conditional headers of req against v in the order given by
+// RFC 9110 Section 13.2.2. If-Range is left to range handling, which comes after this.
+func Evaluate(req *request.Request, v Validators) Result {
+	method := req.RequestLine.Method
+	safe := method == "GET" || method == "HEAD"
+
+	// Step 1 and 2: If-Match, or If-Unmodified-Since when If-Match is absent
+	if ifMatch, ok := req.Headers.Get("If-Match"); ok {
+		if v.Missing || !matchAny(ifMatch, v.ETag, StrongMatch) {
+			return PreconditionFailed
+		}
+	} else if since, ok := headerTime(req, "If-Unmodified-Since"); ok && !v.LastModified.IsZero() {
+		if v.LastModified.Truncate(time.Second).After(since) {
+			return PreconditionFailed
+		}
+	}
+
+	// Step 3 and 4: If-None-Match, or If-Modified-Since for GET and HEAD when it is absent
+	if ifNoneMatch, ok := req.Headers.Get("If-None-Match"); ok {
+		if !v.Missing && matchAny(ifNoneMatch, v.ETag, WeakMatch) {
+			if safe {
+				return NotModified
+			}
+			return PreconditionFailed
+		}
+	} else if since, ok := headerTime(req, "If-Modified-Since"); ok && safe && !v.LastModified.IsZero() {
+		if !v.LastModified.Truncate(time.Second).After(since) {
+			return NotModified
+		}
+	}
+
+	return Proceed
+}
+
+// headerTime parses an HTTP-date header. Invalid dates are ignored (RFC 9110 Section 13.1.3).
+func headerTime(req *request.Request, name string) (time.Time, bool) {
+	value, ok := req.Headers.Get(name)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(value)
+	return t, err == nil
+}
+
+// Check evaluates the request's preconditions against v and, if one of them decides the
+// response, writes the 304 or 412 and returns true. Handlers call it before doing any work,
+// which is the only safe way to honour If-Match on methods that change state:
+//
+//	if conditional.Check(w, req, conditional.Validators{ETag: etag}) {
+//		return nil
+//	}
+func Check(w *response.Writer, req *request.Request, v Validators) bool {
+	switch Evaluate(req, v) {
+	case NotModified:
+		responseHeaders := headers.NewHeaders()
+		responseHeaders.Set("connection", "close")
+		setValidators(responseHeaders, v)
+
+		w.WriteStatusLine(response.StatusNotModified)
+		w.WriteHeaders(responseHeaders)
+		return true
+	case PreconditionFailed:
+		body := []byte("Precondition Failed")
+		responseHeaders := response.GetDefaultHeaders(len(body))
+		setValidators(responseHeaders, v)
+
+		w.WriteStatusLine(response.StatusPreconditionFailed)
+		w.WriteHeaders(responseHeaders)
+		w.WriteBody(body)
+		return true
+	}
+	return false
+}
+
+// setValidators adds the ETag and Last-Modified a 304 must repeat (RFC 9110 Section 15.4.5).
+func setValidators(h headers.Headers, v Validators) {
+	if v.ETag != "" {
+		h.Replace("etag", v.ETag)
+	}
+	if !v.LastModified.IsZero() {
+		h.Replace("last-modified", v.LastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// Middleware answers conditional GET and HEAD requests for handlers that declare their
+// validators by sending ETag or Last-Modified with a 2xx response. When a precondition decides
+// the outcome the status becomes 304 or 412 and the handler's body is dropped, so the handler
+// needs no conditional logic of its own. Other methods are passed through untouched, because
+// by the time the headers are written the change has already been made; use Check for those.
+func Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		method := req.RequestLine.Method
+		if method != "GET" && method != "HEAD" {
+			return next(w, req)
+		}
+
+		drop := false
+		w.WrapBody(func(body io.Writer) io.Writer {
+			return &dropper{next: body, drop: &drop}
+		})
+		w.OnWriteHeaders(func(status response.StatusCode, h headers.Headers) response.StatusCode {
+			// Preconditions only apply when the response would otherwise be a 2xx (RFC 9110 Section 13.2.1)
+			if status < 200 || status >= 300 {
+				return status
+			}
+			v := fromHeaders(h)
+			if v.ETag == "" && v.LastModified.IsZero() {
+				return status
+			}
+
+			switch Evaluate(req, v) {
+			case NotModified:
+				// A 304 carries the validators and caching metadata but no content (RFC 9110 Section 15.4.5)
+				removeContentHeaders(h)
+				drop = true
+				return response.StatusNotModified
+			case PreconditionFailed:
+				removeContentHeaders(h)
+				h.Replace("content-length", "0")
+				drop = true
+				return response.StatusPreconditionFailed
+			}
+			return status
+		})
+
+		return next(w, req)
+	}
+}
+
+// removeContentHeaders strips the fields that describe the body being dropped.
+func removeContentHeaders(h headers.Headers) {
+	for _, name := range []string{"content-length", "content-type", "content-range", "content-encoding", "transfer-encoding", "accept-ranges"} {
+		delete(h, name)
+	}
+}
+
+// dropper is a body filter that discards the handler's body once the response has been
+// turned into a 304 or 412.
+type dropper struct {
+	next io.Writer
+	drop *bool
+}
+
+func (d *dropper) Write(p []byte) (int, error) {
+	if *d.drop {
+		return len(p), nil
+	}
+	return d.next.Write(p)
+}
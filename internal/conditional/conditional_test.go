@@ -0,0 +1,173 @@
+package conditional
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/kiefbc/http-server-1.1/internal/servertest"
+	"github.com/stretchr/testify/assert"
+)
+
+// newRequest parses a request for /resource with the given extra headers.
+func newRequest(t *testing.T, method string, extra map[string]string) *request.Request {
+	t.Helper()
+	return servertest.NewRequest(t, method, "/resource", extra)
+}
+
+// do runs handler for a request to /resource and parses what it wrote.
+func do(t *testing.T, handler server.Handler, method string, extra map[string]string) (*http.Response, string) {
+	t.Helper()
+	return servertest.Do(t, handler, newRequest(t, method, extra))
+}
+
+var (
+	modTime = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	before  = modTime.Add(-time.Hour).Format(http.TimeFormat)
+	after   = modTime.Add(time.Hour).Format(http.TimeFormat)
+)
+
+func TestETags(t *testing.T) {
+	// Test: Strong ETags follow the content
+	assert.Equal(t, StrongETag([]byte("a")), StrongETag([]byte("a")))
+	assert.NotEqual(t, StrongETag([]byte("a")), StrongETag([]byte("b")))
+	assert.False(t, IsWeak(StrongETag([]byte("a"))))
+	assert.True(t, IsWeak(WeakETag(10, modTime)))
+	assert.False(t, IsWeak(FileETag(10, modTime)))
+	assert.NotEqual(t, FileETag(10, modTime), FileETag(10, modTime.Add(time.Nanosecond)))
+
+	// Test: Strong comparison rejects weak tags, weak comparison ignores weakness
+	assert.True(t, StrongMatch(`"x"`, `"x"`))
+	assert.False(t, StrongMatch(`W/"x"`, `"x"`))
+	assert.True(t, WeakMatch(`W/"x"`, `"x"`))
+	assert.False(t, WeakMatch(`"x"`, `"y"`))
+
+	// Test: Lists are scanned, including tags that contain commas
+	assert.Equal(t, []string{`"a"`, `W/"b,c"`, `"d"`}, parseETags(`"a", W/"b,c" ,"d"`))
+	assert.Equal(t, []string{"*"}, parseETags(" * "))
+	assert.Equal(t, []string{`"a"`}, parseETags(`"a", bogus`))
+}
+
+func TestEvaluate(t *testing.T) {
+	v := Validators{ETag: `"v1"`, LastModified: modTime}
+
+	// Test: No conditional headers proceeds
+	assert.Equal(t, Proceed, Evaluate(newRequest(t, "GET", nil), v))
+
+	// Test: If-None-Match uses weak comparison and gives 304 for GET, 412 otherwise
+	assert.Equal(t, NotModified, Evaluate(newRequest(t, "GET", map[string]string{"If-None-Match": `W/"v1"`}), v))
+	assert.Equal(t, NotModified, Evaluate(newRequest(t, "HEAD", map[string]string{"If-None-Match": "*"}), v))
+	assert.Equal(t, PreconditionFailed, Evaluate(newRequest(t, "PUT", map[string]string{"If-None-Match": `"v1"`}), v))
+	assert.Equal(t, Proceed, Evaluate(newRequest(t, "GET", map[string]string{"If-None-Match": `"v0"`}), v))
+
+	// Test: If-None-Match takes precedence over If-Modified-Since
+	assert.Equal(t, Proceed, Evaluate(newRequest(t, "GET", map[string]string{"If-None-Match": `"v0"`, "If-Modified-Since": after}), v))
+
+	// Test: If-Modified-Since compares whole seconds and only applies to GET and HEAD
+	assert.Equal(t, NotModified, Evaluate(newRequest(t, "GET", map[string]string{"If-Modified-Since": modTime.Format(http.TimeFormat)}), v))
+	assert.Equal(t, Proceed, Evaluate(newRequest(t, "GET", map[string]string{"If-Modified-Since": before}), v))
+	assert.Equal(t, Proceed, Evaluate(newRequest(t, "POST", map[string]string{"If-Modified-Since": after}), v))
+	assert.Equal(t, Proceed, Evaluate(newRequest(t, "GET", map[string]string{"If-Modified-Since": "yesterday"}), v))
+
+	// Test: If-Match uses strong comparison and is checked first
+	assert.Equal(t, Proceed, Evaluate(newRequest(t, "PUT", map[string]string{"If-Match": `"v0", "v1"`}), v))
+	assert.Equal(t, PreconditionFailed, Evaluate(newRequest(t, "PUT", map[string]string{"If-Match": `W/"v1"`}), v))
+	assert.Equal(t, PreconditionFailed, Evaluate(newRequest(t, "GET", map[string]string{"If-Match": `"v0"`, "If-None-Match": `"v1"`}), v))
+
+	// Test: If-Match takes precedence over If-Unmodified-Since
+	assert.Equal(t, Proceed, Evaluate(newRequest(t, "PUT", map[string]string{"If-Match": `"v1"`, "If-Unmodified-Since": before}), v))
+
+	// Test: If-Unmodified-Since fails once the resource has changed
+	assert.Equal(t, PreconditionFailed, Evaluate(newRequest(t, "DELETE", map[string]string{"If-Unmodified-Since": before}), v))
+	assert.Equal(t, Proceed, Evaluate(newRequest(t, "DELETE", map[string]string{"If-Unmodified-Since": after}), v))
+
+	// Test: The wildcard depends on whether a representation exists
+	missing := Validators{Missing: true}
+	assert.Equal(t, PreconditionFailed, Evaluate(newRequest(t, "PUT", map[string]string{"If-Match": "*"}), missing))
+	assert.Equal(t, Proceed, Evaluate(newRequest(t, "PUT", map[string]string{"If-None-Match": "*"}), missing))
+	assert.Equal(t, PreconditionFailed, Evaluate(newRequest(t, "PUT", map[string]string{"If-None-Match": "*"}), v))
+}
+
+func TestMiddleware(t *testing.T) {
+	calls := 0
+	handler := Middleware(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		calls++
+		body := []byte("representation")
+		responseHeaders := response.GetDefaultHeaders(len(body))
+		responseHeaders.Replace("etag", StrongETag(body))
+		responseHeaders.Replace("last-modified", modTime.Format(http.TimeFormat))
+		responseHeaders.Replace("cache-control", "max-age=60")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(responseHeaders)
+		w.WriteBody(body)
+		return nil
+	})
+	etag := StrongETag([]byte("representation"))
+
+	// Test: Unconditional requests get the full response
+	resp, body := do(t, handler, "GET", nil)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "representation", body)
+
+	// Test: A matching If-None-Match becomes a bodiless 304 with the validators
+	resp, body = do(t, handler, "GET", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, 304, resp.StatusCode)
+	assert.Equal(t, "", body)
+	assert.Equal(t, etag, resp.Header.Get("ETag"))
+	assert.Equal(t, "max-age=60", resp.Header.Get("Cache-Control"))
+	assert.Empty(t, resp.Header.Get("Content-Length"))
+
+	// Test: If-Modified-Since alone is enough for a 304
+	resp, _ = do(t, handler, "GET", map[string]string{"If-Modified-Since": after})
+	assert.Equal(t, 304, resp.StatusCode)
+
+	// Test: A failed If-Match becomes an empty 412
+	resp, body = do(t, handler, "GET", map[string]string{"If-Match": `"other"`})
+	assert.Equal(t, 412, resp.StatusCode)
+	assert.Equal(t, "", body)
+
+	// Test: Unsafe methods are not rewritten after the fact
+	resp, _ = do(t, handler, "PUT", map[string]string{"If-Match": `"other"`})
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, 5, calls)
+
+	// Test: Responses without validators or with an error status are left alone
+	plain := Middleware(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		return &server.HandlerError{StatusCode: response.StatusNotFound, Message: "Not Found"}
+	})
+	resp, _ = do(t, plain, "GET", map[string]string{"If-None-Match": "*"})
+	assert.Equal(t, 404, resp.StatusCode)
+}
+
+func TestCheck(t *testing.T) {
+	v := Validators{ETag: `"v2"`, LastModified: modTime}
+	updated := false
+	handler := func(w *response.Writer, req *request.Request) *server.HandlerError {
+		if Check(w, req, v) {
+			return nil
+		}
+		updated = true
+		w.WriteStatusLine(response.StatusNoContent)
+		w.WriteHeaders(nil)
+		return nil
+	}
+
+	// Test: A stale If-Match stops the update with 412
+	resp, _ := do(t, handler, "PUT", map[string]string{"If-Match": `"v1"`})
+	assert.Equal(t, 412, resp.StatusCode)
+	assert.False(t, updated)
+
+	// Test: A current If-Match lets the update through
+	resp, _ = do(t, handler, "PUT", map[string]string{"If-Match": `"v2"`})
+	assert.Equal(t, 204, resp.StatusCode)
+	assert.True(t, updated)
+
+	// Test: 304 from Check repeats the validators
+	resp, _ = do(t, handler, "GET", map[string]string{"If-None-Match": `"v2"`})
+	assert.Equal(t, 304, resp.StatusCode)
+	assert.Equal(t, `"v2"`, resp.Header.Get("ETag"))
+	assert.Equal(t, modTime.Format(http.TimeFormat), resp.Header.Get("Last-Modified"))
+}
@@ -0,0 +1,96 @@
+package conditional
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StrongETag returns a strong entity tag derived from a hash of content, so it changes
+// whenever a single byte of the representation does (RFC 9110 Section 8.8.3).
+func StrongETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// WeakETag returns a weak entity tag built from a size and modification time. It is cheap to
+// compute for files but cannot tell apart two versions written within the same instant with
+// the same size, which is why it is marked weak (RFC 9110 Section 8.8.1).
+func WeakETag(size int64, modTime time.Time) string {
+	return fmt.Sprintf(`W/"%x-%x"`, size, modTime.UnixNano())
+}
+
+// FileETag returns a strong entity tag built from a file's size and modification time in
+// nanoseconds. Like Apache and nginx it trusts a file to change its modification time whenever
+// its bytes change, which lets byte ranges be resumed with If-Range, where only strong tags
+// match (RFC 9110 Section 13.1.5). Use WeakETag where that does not hold.
+func FileETag(size int64, modTime time.Time) string {
+	return fmt.Sprintf(`"%x-%x"`, size, modTime.UnixNano())
+}
+
+// IsWeak reports whether an entity tag carries the W/ weakness indicator.
+func IsWeak(etag string) bool {
+	return strings.HasPrefix(etag, "W/")
+}
+
+// StrongMatch compares two entity tags with the strong comparison function: both must be
+// strong and character-for-character identical (RFC 9110 Section 8.8.3.2).
+func StrongMatch(a, b string) bool {
+	return !IsWeak(a) && !IsWeak(b) && a == b
+}
+
+// WeakMatch compares two entity tags with the weak comparison function: their opaque tags must
+// match, whether or not either is weak.
+func WeakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// parseETags splits an If-Match or If-None-Match value into its entity tags. The wildcard
+// comes back as a single "*". Entity tags may contain commas, so the value is scanned rather
+// than split. Malformed input stops the scan; whatever was parsed so far is returned.
+func parseETags(value string) []string {
+	value = strings.TrimSpace(value)
+	if value == "*" {
+		return []string{"*"}
+	}
+
+	var etags []string
+	for value != "" {
+		value = strings.TrimLeft(value, " \t,")
+		if value == "" {
+			break
+		}
+
+		start := 0
+		if strings.HasPrefix(value, "W/") {
+			start = 2
+		}
+		if len(value) <= start || value[start] != '"' {
+			break
+		}
+		end := strings.IndexByte(value[start+1:], '"')
+		if end < 0 {
+			break
+		}
+		end += start + 2
+
+		etags = append(etags, value[:end])
+		value = value[end:]
+	}
+	return etags
+}
+
+// matchAny reports whether etag matches any tag in the header value under match.
+func matchAny(value, etag string, match func(a, b string) bool) bool {
+	for _, candidate := range parseETags(value) {
+		if candidate == "*" {
+			return true
+		}
+		if etag != "" && match(candidate, etag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,262 @@
+package cookie
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SameSite controls whether a cookie is sent with cross-site requests.
+type SameSite int
+
+const (
+	// SameSiteDefault omits the attribute and leaves the choice to the browser.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	}
+	return ""
+}
+
+// Cookie is an HTTP cookie (RFC 6265). Requests only carry Name and Value; the other
+// fields are the attributes of a Set-Cookie response header.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time // zero means no Expires attribute
+	// MaxAge is the lifetime in seconds. Zero means no Max-Age attribute and a negative
+	// value deletes the cookie now (sent as Max-Age=0).
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite SameSite
+	// Partitioned keys the cookie to the top-level site (CHIPS). Browsers require Secure with it.
+	Partitioned bool
+
+	// Unparsed holds attributes ParseSetCookie did not recognise, written back unchanged.
+	Unparsed []string
+}
+
+// Valid reports whether the cookie can be written to a Set-Cookie header.
+func (c *Cookie) Valid() error {
+	if c == nil {
+		return errors.New("cookie: nil cookie")
+	}
+	if !isToken(c.Name) {
+		return fmt.Errorf("cookie: invalid name %q", c.Name)
+	}
+	if !validValue(c.Value) {
+		return fmt.Errorf("cookie: invalid value for %q", c.Name)
+	}
+	if strings.ContainsAny(c.Path, ";\r\n") {
+		return fmt.Errorf("cookie: invalid path %q", c.Path)
+	}
+	if c.Domain != "" && !validDomain(c.Domain) {
+		return fmt.Errorf("cookie: invalid domain %q", c.Domain)
+	}
+	if c.Partitioned && !c.Secure {
+		return fmt.Errorf("cookie: %q is Partitioned but not Secure", c.Name)
+	}
+	for _, attr := range c.Unparsed {
+		if strings.ContainsAny(attr, ";\r\n") {
+			return fmt.Errorf("cookie: invalid attribute %q", attr)
+		}
+	}
+	return nil
+}
+
+// String returns the Set-Cookie header value for the cookie (RFC 6265 Section 4.1.1).
+// The cookie should be checked with Valid first.
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	b.WriteString(c.Value)
+
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		// A leading dot is ignored by user agents (RFC 6265 Section 5.2.3)
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(http.TimeFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=" + c.SameSite.String())
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	for _, attr := range c.Unparsed {
+		b.WriteString("; " + attr)
+	}
+	return b.String()
+}
+
+// Parse parses the value of a Cookie request header, "name=value; name2=value2"
+// (RFC 6265 Section 5.4). Pairs with an invalid name or value are skipped. Commas also
+// separate pairs, since repeated Cookie fields are joined with them and no valid value contains one.
+func Parse(header string) []*Cookie {
+	var cookies []*Cookie
+	separator := func(r rune) bool { return r == ';' || r == ',' }
+	for _, pair := range strings.FieldsFunc(header, separator) {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !isToken(name) {
+			continue
+		}
+		value, ok = unquote(value)
+		if !ok {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// ParseSetCookie parses one Set-Cookie header value, e.g. from an upstream response
+// (RFC 6265 Section 5.2). Unknown attributes are kept in Unparsed.
+func ParseSetCookie(line string) (*Cookie, error) {
+	parts := strings.Split(line, ";")
+	name, value, ok := strings.Cut(strings.TrimSpace(parts[0]), "=")
+	if !ok || !isToken(name) {
+		return nil, fmt.Errorf("cookie: invalid Set-Cookie %q", line)
+	}
+	value, ok = unquote(value)
+	if !ok {
+		return nil, fmt.Errorf("cookie: invalid value in Set-Cookie %q", line)
+	}
+
+	c := &Cookie{Name: name, Value: value}
+	for _, attr := range parts[1:] {
+		attr = strings.TrimSpace(attr)
+		if attr == "" {
+			continue
+		}
+		key, val, _ := strings.Cut(attr, "=")
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "path":
+			c.Path = val
+		case "domain":
+			c.Domain = val
+		case "expires":
+			expires, err := http.ParseTime(val)
+			if err != nil {
+				c.Unparsed = append(c.Unparsed, attr)
+				continue
+			}
+			c.Expires = expires
+		case "max-age":
+			seconds, err := strconv.Atoi(val)
+			if err != nil {
+				c.Unparsed = append(c.Unparsed, attr)
+				continue
+			}
+			c.MaxAge = seconds
+			if seconds <= 0 {
+				c.MaxAge = -1
+			}
+		case "secure":
+			c.Secure = true
+		case "httponly":
+			c.HttpOnly = true
+		case "samesite":
+			switch strings.ToLower(val) {
+			case "lax":
+				c.SameSite = SameSiteLax
+			case "strict":
+				c.SameSite = SameSiteStrict
+			case "none":
+				c.SameSite = SameSiteNone
+			default:
+				c.Unparsed = append(c.Unparsed, attr)
+			}
+		case "partitioned":
+			c.Partitioned = true
+		default:
+			c.Unparsed = append(c.Unparsed, attr)
+		}
+	}
+	return c, nil
+}
+
+// unquote strips optional double quotes around a cookie value and validates it.
+func unquote(value string) (string, bool) {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	return value, validValue(value)
+}
+
+// validValue reports whether every byte is a cookie-octet (RFC 6265 Section 4.1.1):
+// printable US-ASCII except whitespace, DQUOTE, comma, semicolon and backslash.
+func validValue(value string) bool {
+	for i := 0; i < len(value); i++ {
+		b := value[i]
+		if b <= 0x20 || b >= 0x7f || b == '"' || b == ',' || b == ';' || b == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// isToken reports whether s is a non-empty token (RFC 9110 Section 5.6.2).
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		switch {
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", b) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validDomain accepts host names made of letters, digits, hyphens and dots.
+func validDomain(domain string) bool {
+	domain = strings.TrimPrefix(domain, ".")
+	if domain == "" || len(domain) > 253 {
+		return false
+	}
+	for i := 0; i < len(domain); i++ {
+		b := domain[i]
+		if !(b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '-' || b == '.') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,89 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Pairs are split on semicolons, quotes are removed
+	cookies := Parse(`session=abc123; theme="dark"; lang=en`)
+	require.Len(t, cookies, 3)
+	assert.Equal(t, "session", cookies[0].Name)
+	assert.Equal(t, "abc123", cookies[0].Value)
+	assert.Equal(t, "dark", cookies[1].Value)
+	assert.Equal(t, "lang", cookies[2].Name)
+
+	// Test: Repeated Cookie fields joined with commas still split
+	cookies = Parse("a=1; b=2, c=3")
+	require.Len(t, cookies, 3)
+	assert.Equal(t, "c", cookies[2].Name)
+
+	// Test: Malformed pairs are skipped
+	cookies = Parse(`bad name=1; novalue; ok=yes; quote=a"b; =empty`)
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "ok", cookies[0].Name)
+
+	// Test: Empty values are allowed
+	cookies = Parse("empty=")
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "", cookies[0].Value)
+}
+
+func TestString(t *testing.T) {
+	// Test: Every attribute is written in order
+	c := &Cookie{
+		Name:        "id",
+		Value:       "a3fWa",
+		Path:        "/",
+		Domain:      ".example.com",
+		Expires:     time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteStrict,
+		Partitioned: true,
+	}
+	require.NoError(t, c.Valid())
+	assert.Equal(t, "id=a3fWa; Path=/; Domain=example.com; Expires=Wed, 02 Jan 2030 03:04:05 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=Strict; Partitioned", c.String())
+
+	// Test: A negative MaxAge deletes the cookie
+	assert.Equal(t, "id=; Max-Age=0", (&Cookie{Name: "id", MaxAge: -1}).String())
+
+	// Test: Invalid cookies are rejected
+	assert.Error(t, (&Cookie{Name: "bad name", Value: "x"}).Valid())
+	assert.Error(t, (&Cookie{Name: "id", Value: "has space"}).Valid())
+	assert.Error(t, (&Cookie{Name: "id", Value: "x", Path: "/a;b"}).Valid())
+	assert.Error(t, (&Cookie{Name: "id", Value: "x", Domain: "exa mple.com"}).Valid())
+	assert.Error(t, (&Cookie{Name: "id", Value: "x", Partitioned: true}).Valid())
+}
+
+func TestParseSetCookie(t *testing.T) {
+	// Test: Known attributes are parsed and unknown ones kept
+	c, err := ParseSetCookie("sid=xyz; Path=/app; Expires=Wed, 02 Jan 2030 03:04:05 GMT; Max-Age=60; secure; HttpOnly; SameSite=Lax; Priority=High")
+	require.NoError(t, err)
+	assert.Equal(t, "sid", c.Name)
+	assert.Equal(t, "xyz", c.Value)
+	assert.Equal(t, "/app", c.Path)
+	assert.Equal(t, 2030, c.Expires.Year())
+	assert.Equal(t, 60, c.MaxAge)
+	assert.True(t, c.Secure)
+	assert.True(t, c.HttpOnly)
+	assert.Equal(t, SameSiteLax, c.SameSite)
+	assert.Equal(t, []string{"Priority=High"}, c.Unparsed)
+
+	// Test: It round-trips through String
+	assert.Equal(t, "sid=xyz; Path=/app; Expires=Wed, 02 Jan 2030 03:04:05 GMT; Max-Age=60; Secure; HttpOnly; SameSite=Lax; Priority=High", c.String())
+
+	// Test: Max-Age=0 means delete
+	c, err = ParseSetCookie("sid=; Max-Age=0")
+	require.NoError(t, err)
+	assert.Equal(t, -1, c.MaxAge)
+
+	// Test: A missing name is an error
+	_, err = ParseSetCookie("=value")
+	assert.Error(t, err)
+}
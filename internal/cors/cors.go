@@ -0,0 +1,193 @@
+package cors
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/headers"
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+)
+
+// CORS is Cross-Origin Resource Sharing middleware (Fetch Standard Section 3.2).
+// It answers preflight requests itself and adds Access-Control-* headers to the responses
+// of allowed cross-origin requests.
+type CORS struct {
+	// AllowedOrigins lists origins such as "https://app.example.com". "*" allows any origin
+	// and "https://*.example.com" allows any subdomain of example.com over https.
+	AllowedOrigins []string
+	// AllowedOriginPatterns allows origins matching any of these expressions. Anchor them;
+	// an unanchored pattern matches origins that merely contain it.
+	AllowedOriginPatterns []*regexp.Regexp
+	// AllowedMethods lists the methods cross-origin requests may use.
+	AllowedMethods []string
+	// AllowedHeaders lists the request headers cross-origin requests may send. "*" allows any.
+	AllowedHeaders []string
+	// ExposedHeaders lists the response headers scripts may read beyond the safelisted ones.
+	ExposedHeaders []string
+	// AllowCredentials lets requests carry cookies and HTTP authentication. The allowed origin
+	// is then echoed rather than sent as "*", which browsers refuse with credentials, and a "*"
+	// in AllowedOrigins is ignored: credentialed requests need origins listed explicitly.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight result; 0 leaves it to the browser.
+	MaxAge time.Duration
+}
+
+// New creates CORS middleware for origins that allows GET, HEAD and POST.
+func New(origins ...string) *CORS {
+	return &CORS{
+		AllowedOrigins: origins,
+		AllowedMethods: []string{"GET", "HEAD", "POST"},
+	}
+}
+
+// Middleware answers valid preflight requests with 204 and invalid ones with 403 without
+// calling next. Other requests from an allowed origin reach next and get CORS headers on their
+// response. Every response carries Vary: Origin, since whether it has CORS headers depends on it.
+func (c *CORS) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		origin, hasOrigin := req.Headers.Get("Origin")
+		requestMethod, hasRequestMethod := req.Headers.Get("Access-Control-Request-Method")
+
+		if req.RequestLine.Method == "OPTIONS" && hasOrigin && hasRequestMethod {
+			c.preflight(w, req, origin, requestMethod)
+			return nil
+		}
+
+		allowed := hasOrigin && c.originAllowed(origin)
+		w.OnWriteHeaders(func(status response.StatusCode, h headers.Headers) response.StatusCode {
+			h.AddVary("Origin")
+			if !allowed {
+				return status
+			}
+			c.allowOrigin(h, origin)
+			if len(c.ExposedHeaders) > 0 {
+				h.Replace("access-control-expose-headers", strings.Join(c.ExposedHeaders, ", "))
+			}
+			return status
+		})
+		return next(w, req)
+	}
+}
+
+// preflight answers an OPTIONS request that asks whether the real request may be sent.
+func (c *CORS) preflight(w *response.Writer, req *request.Request, origin, method string) {
+	h := headers.NewHeaders()
+	h.AddVary("Origin")
+	h.AddVary("Access-Control-Request-Method")
+	h.AddVary("Access-Control-Request-Headers")
+
+	requestHeaders := parseList(headerValue(req, "Access-Control-Request-Headers"))
+	if !c.originAllowed(origin) || !slices.Contains(c.AllowedMethods, method) || !c.headersAllowed(requestHeaders) {
+		h.Replace("content-length", "0")
+		w.WriteStatusLine(response.StatusForbidden)
+		w.WriteHeaders(h)
+		return
+	}
+
+	c.allowOrigin(h, origin)
+	h.Replace("access-control-allow-methods", strings.Join(c.AllowedMethods, ", "))
+	if len(requestHeaders) > 0 {
+		// Echoing the requested names also covers "*", which browsers ignore with credentials
+		h.Replace("access-control-allow-headers", strings.Join(requestHeaders, ", "))
+	}
+	if c.MaxAge > 0 {
+		h.Replace("access-control-max-age", fmt.Sprintf("%d", int(c.MaxAge.Seconds())))
+	}
+
+	w.WriteStatusLine(response.StatusNoContent)
+	w.WriteHeaders(h)
+}
+
+// allowOrigin sets Access-Control-Allow-Origin and, if enabled, Allow-Credentials.
+func (c *CORS) allowOrigin(h headers.Headers, origin string) {
+	if !c.AllowCredentials && slices.Contains(c.AllowedOrigins, "*") {
+		h.Replace("access-control-allow-origin", "*")
+		return
+	}
+	h.Replace("access-control-allow-origin", origin)
+	if c.AllowCredentials {
+		h.Replace("access-control-allow-credentials", "true")
+	}
+}
+
+// originAllowed matches origin against the exact, wildcard and pattern rules.
+// Origins are compared case-insensitively since scheme and host are (RFC 6454 Section 4).
+func (c *CORS) originAllowed(origin string) bool {
+	// Sandboxed documents and file: URLs send "null", which must never be trusted as an origin
+	if origin == "" || origin == "null" {
+		return false
+	}
+	origin = strings.ToLower(origin)
+	for _, allowed := range c.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		// Echoing any origin with credentials would let every site act as the user
+		if allowed == "*" && c.AllowCredentials {
+			continue
+		}
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		if prefix, suffix, ok := strings.Cut(allowed, "*"); ok {
+			// The wildcard stands for one or more subdomain labels: "https://*.example.com"
+			// matches https://a.example.com and https://a.b.example.com but not https://example.com
+			if len(origin) > len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+				isLabels(origin[len(prefix):len(origin)-len(suffix)]) {
+				return true
+			}
+		}
+	}
+	for _, pattern := range c.AllowedOriginPatterns {
+		if pattern.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLabels reports whether s looks like dot-separated host labels, so a wildcard cannot
+// swallow a port, userinfo or path.
+func isLabels(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// headersAllowed reports whether every requested header is allowed.
+func (c *CORS) headersAllowed(requested []string) bool {
+	if slices.Contains(c.AllowedHeaders, "*") {
+		return true
+	}
+	for _, name := range requested {
+		if !slices.ContainsFunc(c.AllowedHeaders, func(allowed string) bool {
+			return strings.EqualFold(allowed, name)
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseList splits a comma-separated header value into lowercase names.
+func parseList(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func headerValue(req *request.Request, name string) string {
+	value, _ := req.Headers.Get(name)
+	return value
+}
@@ -0,0 +1,121 @@
+package cors
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/kiefbc/http-server-1.1/internal/servertest"
+	"github.com/stretchr/testify/assert"
+)
+
+// do runs handler for a request to /api on api.example.com with the extra headers.
+func do(t *testing.T, handler server.Handler, method string, extra map[string]string) *http.Response {
+	t.Helper()
+	req := servertest.NewRequest(t, method, "/api", extra)
+	req.Headers.Replace("Host", "api.example.com")
+	resp, _ := servertest.Do(t, handler, req)
+	return resp
+}
+
+func TestCORS(t *testing.T) {
+	calls := 0
+	ok := func(w *response.Writer, req *request.Request) *server.HandlerError {
+		calls++
+		body := []byte("ok")
+		h := response.GetDefaultHeaders(len(body))
+		h.Replace("vary", "Accept-Encoding")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(h)
+		w.WriteBody(body)
+		return nil
+	}
+	c := New("https://app.example.com", "https://*.example.org")
+	c.AllowedOriginPatterns = []*regexp.Regexp{regexp.MustCompile(`^http://localhost:\d+$`)}
+	c.AllowedMethods = []string{"GET", "POST", "DELETE"}
+	c.AllowedHeaders = []string{"Content-Type", "Authorization"}
+	c.ExposedHeaders = []string{"X-Request-Id"}
+	c.AllowCredentials = true
+	c.MaxAge = 10 * time.Minute
+	handler := c.Middleware(ok)
+
+	// Test: A valid preflight is answered with 204 without reaching the handler
+	resp := do(t, handler, "OPTIONS", map[string]string{
+		"Origin":                         "https://app.example.com",
+		"Access-Control-Request-Method":  "DELETE",
+		"Access-Control-Request-Headers": "content-type, Authorization",
+	})
+	assert.Equal(t, 204, resp.StatusCode)
+	assert.Equal(t, 0, calls)
+	assert.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "GET, POST, DELETE", resp.Header.Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "content-type, authorization", resp.Header.Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", resp.Header.Get("Access-Control-Max-Age"))
+	assert.Equal(t, "Origin, Access-Control-Request-Method, Access-Control-Request-Headers", resp.Header.Get("Vary"))
+
+	// Test: Preflights for disallowed origins, methods or headers are refused
+	for _, extra := range []map[string]string{
+		{"Origin": "https://evil.example", "Access-Control-Request-Method": "GET"},
+		{"Origin": "https://app.example.com", "Access-Control-Request-Method": "PATCH"},
+		{"Origin": "https://app.example.com", "Access-Control-Request-Method": "GET", "Access-Control-Request-Headers": "X-Secret"},
+	} {
+		resp = do(t, handler, "OPTIONS", extra)
+		assert.Equal(t, 403, resp.StatusCode, extra)
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+
+	// Test: Actual requests from allowed origins get CORS headers and Vary: Origin
+	resp = do(t, handler, "GET", map[string]string{"Origin": "https://a.b.example.org"})
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "https://a.b.example.org", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Request-Id", resp.Header.Get("Access-Control-Expose-Headers"))
+	assert.Equal(t, "Accept-Encoding, Origin", resp.Header.Get("Vary"))
+	resp = do(t, handler, "GET", map[string]string{"Origin": "http://localhost:5173"})
+	assert.Equal(t, "http://localhost:5173", resp.Header.Get("Access-Control-Allow-Origin"))
+
+	// Test: Other origins reach the handler but get no CORS headers
+	for _, origin := range []string{"https://example.org", "https://x.example.org:8443", "null", "http://a.example.org"} {
+		resp = do(t, handler, "GET", map[string]string{"Origin": origin})
+		assert.Equal(t, 200, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"), origin)
+		assert.Equal(t, "Accept-Encoding, Origin", resp.Header.Get("Vary"))
+	}
+
+	// Test: OPTIONS without Access-Control-Request-Method is an ordinary request
+	calls = 0
+	do(t, handler, "OPTIONS", map[string]string{"Origin": "https://app.example.com"})
+	assert.Equal(t, 1, calls)
+
+	// Test: A wildcard origin without credentials answers "*"
+	open := New("*")
+	open.AllowedHeaders = []string{"*"}
+	resp = do(t, open.Middleware(ok), "OPTIONS", map[string]string{
+		"Origin":                         "https://anywhere.example",
+		"Access-Control-Request-Method":  "POST",
+		"Access-Control-Request-Headers": "x-anything",
+	})
+	assert.Equal(t, 204, resp.StatusCode)
+	assert.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "x-anything", resp.Header.Get("Access-Control-Allow-Headers"))
+	assert.Empty(t, resp.Header.Get("Access-Control-Allow-Credentials"))
+
+	// Test: With credentials a wildcard allows nothing; only listed origins are echoed
+	open = New("*", "https://app.example.com")
+	open.AllowCredentials = true
+	resp = do(t, open.Middleware(ok), "OPTIONS", map[string]string{
+		"Origin":                        "https://evil.example",
+		"Access-Control-Request-Method": "GET",
+	})
+	assert.Equal(t, 403, resp.StatusCode)
+	resp = do(t, open.Middleware(ok), "GET", map[string]string{"Origin": "https://evil.example"})
+	assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Empty(t, resp.Header.Get("Access-Control-Allow-Credentials"))
+	resp = do(t, open.Middleware(ok), "GET", map[string]string{"Origin": "https://app.example.com"})
+	assert.Equal(t, "https://app.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"))
+}
@@ -0,0 +1,295 @@
+package fileserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/kiefbc/http-server-1.1/internal/conditional"
+	"github.com/kiefbc/http-server-1.1/internal/headers"
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+)
+
+// copyBufferSize is how much of a file is read per write while streaming it.
+const copyBufferSize = 32 * 1024
+
+// sniffLen is how many bytes content sniffing looks at (WHATWG MIME Sniffing).
+const sniffLen = 512
+
+// FileServer serves files from an fs.FS, such as a directory on disk (see Dir) or an embed.FS.
+// Files are streamed rather than loaded into memory, and directories are served through their
+// index file.
+type FileServer struct {
+	fsys fs.FS
+
+	// StripPrefix is removed from the request path before it is looked up in the file system.
+	StripPrefix string
+	// IndexFile is served for requests that name a directory.
+	IndexFile string
+}
+
+// New creates a FileServer for fsys.
+func New(fsys fs.FS) *FileServer {
+	return &FileServer{
+		fsys:      fsys,
+		IndexFile: "index.html",
+	}
+}
+
+// Dir returns a FileServer for a directory on disk. Unlike os.DirFS, symlinks that lead out of
+// dir are not followed: such files are reported as missing.
+func Dir(dir string) *FileServer {
+	return New(rootFS(dir))
+}
+
+// rootFS is a directory on disk opened through os.Root, which refuses to resolve a path to
+// anything outside it. The root is opened per call so the directory may appear after startup.
+type rootFS string
+
+func (dir rootFS) Open(name string) (fs.File, error) {
+	root, err := os.OpenRoot(string(dir))
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	// Files opened through a root stay usable after it is closed
+	defer root.Close()
+
+	file, err := root.FS().Open(name)
+	var errno syscall.Errno
+	if err != nil && !errors.As(err, &errno) && !errors.Is(err, fs.ErrNotExist) {
+		// An escaping path fails with an error of os.Root's own rather than an errno
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return file, err
+}
+
+// Handle is a server.Handler that serves the file named by the request path.
+func (s *FileServer) Handle(w *response.Writer, req *request.Request) *server.HandlerError {
+	target, err := url.ParseRequestURI(req.RequestLine.RequestTarget)
+	if err != nil {
+		return &server.HandlerError{
+			StatusCode: response.StatusBadRequest,
+			Message:    fmt.Sprintf("Bad Request: %v", err),
+		}
+	}
+
+	requestPath := strings.TrimPrefix(target.Path, s.StripPrefix)
+	name, ok := cleanName(requestPath)
+	if !ok {
+		return &server.HandlerError{
+			StatusCode: response.StatusBadRequest,
+			Message:    "Bad Request: invalid path",
+		}
+	}
+
+	info, err := fs.Stat(s.fsys, name)
+	if err != nil {
+		return openError(err)
+	}
+
+	if info.IsDir() {
+		// Relative links inside an index page only resolve against a trailing slash
+		if !strings.HasSuffix(target.Path, "/") {
+			location := target.Path + "/"
+			if target.RawQuery != "" {
+				location += "?" + target.RawQuery
+			}
+			redirect(w, location)
+			return nil
+		}
+		name = path.Join(name, s.IndexFile)
+	}
+
+	return s.ServeFile(w, req, name)
+}
+
+// ServeFile serves the named file from the file system regardless of the request path.
+// name uses fs.FS conventions: slash-separated and unrooted, e.g. "videos/intro.mp4".
+func (s *FileServer) ServeFile(w *response.Writer, req *request.Request, name string) *server.HandlerError {
+	if req.RequestLine.Method != "GET" && req.RequestLine.Method != "HEAD" {
+		body := []byte("Method Not Allowed")
+		responseHeaders := response.GetDefaultHeaders(len(body))
+		responseHeaders.Replace("allow", "GET, HEAD")
+
+		w.WriteStatusLine(response.StatusMethodNotAllowed)
+		w.WriteHeaders(responseHeaders)
+		w.WriteBody(body)
+		return nil
+	}
+
+	if !fs.ValidPath(name) {
+		return &server.HandlerError{
+			StatusCode: response.StatusBadRequest,
+			Message:    "Bad Request: invalid path",
+		}
+	}
+
+	file, err := s.fsys.Open(name)
+	if err != nil {
+		return openError(err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return openError(err)
+	}
+	if info.IsDir() {
+		return &server.HandlerError{
+			StatusCode: response.StatusNotFound,
+			Message:    "Not Found",
+		}
+	}
+
+	content, contentType, err := detectContentType(file, name)
+	if err != nil {
+		return &server.HandlerError{
+			StatusCode: response.StatusInternalServerError,
+			Message:    fmt.Sprintf("Failed to read file: %v", err),
+		}
+	}
+
+	validators := fileValidators(info)
+	if conditional.Check(w, req, validators) {
+		return nil
+	}
+
+	responseHeaders := fileHeaders(info, contentType, validators)
+	// Ranges need random access; files that cannot seek are always sent whole
+	seeker, seekable := content.(io.ReadSeeker)
+	if seekable {
+		responseHeaders.Replace("accept-ranges", "bytes")
+	}
+
+	// GET is the only method with defined range semantics (RFC 9110 Section 14.2)
+	rangeHeader, hasRange := req.Headers.Get("Range")
+	if hasRange && seekable && req.RequestLine.Method == "GET" && ifRangeAllows(req, responseHeaders) {
+		if serveRanges(w, rangeHeader, seeker, info.Size(), responseHeaders) {
+			return nil
+		}
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return &server.HandlerError{
+				StatusCode: response.StatusInternalServerError,
+				Message:    fmt.Sprintf("Failed to read file: %v", err),
+			}
+		}
+	}
+
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(responseHeaders)
+	if req.RequestLine.Method == "HEAD" {
+		return nil
+	}
+
+	// The status line is out, so a failed copy can only cut the body short
+	io.CopyBuffer(bodyWriter{w}, content, make([]byte, copyBufferSize))
+	return nil
+}
+
+// fileValidators derives a strong ETag and Last-Modified from the file's size and modification
+// time, so If-Range works with either.
+func fileValidators(info fs.FileInfo) conditional.Validators {
+	modTime := info.ModTime()
+	// embed.FS files have no modification time
+	if modTime.IsZero() || modTime.Unix() <= 0 {
+		return conditional.Validators{}
+	}
+	return conditional.Validators{
+		ETag:         conditional.FileETag(info.Size(), modTime),
+		LastModified: modTime,
+	}
+}
+
+// fileHeaders builds the response headers for a file.
+func fileHeaders(info fs.FileInfo, contentType string, validators conditional.Validators) headers.Headers {
+	responseHeaders := response.GetDefaultHeaders(int(info.Size()))
+	responseHeaders.Replace("content-type", contentType)
+	if validators.ETag != "" {
+		responseHeaders.Replace("etag", validators.ETag)
+	}
+	if !validators.LastModified.IsZero() {
+		responseHeaders.Replace("last-modified", validators.LastModified.UTC().Format(http.TimeFormat))
+	}
+	return responseHeaders
+}
+
+// cleanName turns a URL path into an fs.FS name, refusing anything that could escape the root.
+func cleanName(urlPath string) (string, bool) {
+	if strings.ContainsAny(urlPath, "\\\x00") {
+		return "", false
+	}
+	// Cleaning a rooted path resolves every ".." without ever climbing above "/"
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		name = "."
+	}
+	return name, fs.ValidPath(name)
+}
+
+// detectContentType picks a Content-Type from the file extension, falling back to sniffing the
+// first bytes. It returns a reader that yields the whole file, including any sniffed bytes.
+func detectContentType(file fs.File, name string) (io.Reader, string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return file, contentType, nil
+	}
+
+	sniffed := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, sniffed)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, "", err
+	}
+	sniffed = sniffed[:n]
+
+	contentType := http.DetectContentType(sniffed)
+	if seeker, ok := file.(io.Seeker); ok {
+		if _, err := seeker.Seek(0, io.SeekStart); err == nil {
+			return file, contentType, nil
+		}
+	}
+	return io.MultiReader(bytes.NewReader(sniffed), file), contentType, nil
+}
+
+// openError maps a file system error to a response.
+func openError(err error) *server.HandlerError {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return &server.HandlerError{StatusCode: response.StatusNotFound, Message: "Not Found"}
+	case errors.Is(err, fs.ErrPermission):
+		return &server.HandlerError{StatusCode: response.StatusForbidden, Message: "Forbidden"}
+	}
+	return &server.HandlerError{
+		StatusCode: response.StatusInternalServerError,
+		Message:    fmt.Sprintf("Failed to open file: %v", err),
+	}
+}
+
+// redirect sends a 301 to location.
+func redirect(w *response.Writer, location string) {
+	body := []byte("Moved Permanently")
+	responseHeaders := response.GetDefaultHeaders(len(body))
+	responseHeaders.Replace("location", location)
+
+	w.WriteStatusLine(response.StatusMovedPermanently)
+	w.WriteHeaders(responseHeaders)
+	w.WriteBody(body)
+}
+
+// bodyWriter adapts a response.Writer to io.Writer for io.Copy.
+type bodyWriter struct {
+	w *response.Writer
+}
+
+func (bw bodyWriter) Write(p []byte) (int, error) {
+	return bw.w.WriteBody(p)
+}
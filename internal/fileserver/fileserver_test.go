@@ -0,0 +1,250 @@
+package fileserver
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/kiefbc/http-server-1.1/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// do runs handler for a request to target and parses what it wrote.
+func do(t *testing.T, handler server.Handler, method, target string, extra map[string]string) (*http.Response, string) {
+	t.Helper()
+	return servertest.Do(t, handler, servertest.NewRequest(t, method, target, extra))
+}
+
+var modTime = time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func testFS() fstest.MapFS {
+	return fstest.MapFS{
+		"index.html":      {Data: []byte("<h1>home</h1>"), ModTime: modTime},
+		"style.css":       {Data: []byte("body{}"), ModTime: modTime},
+		"notes":           {Data: []byte("%PDF-1.4 fake"), ModTime: modTime},
+		"docs/index.html": {Data: []byte("<h1>docs</h1>"), ModTime: modTime},
+		"empty/.keep":     {Data: nil},
+	}
+}
+
+func TestFileServer(t *testing.T) {
+	fileServer := New(testFS())
+
+	// Test: File is served with extension-based Content-Type, length and Last-Modified
+	resp, body := do(t, fileServer.Handle, "GET", "/style.css", nil)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "body{}", body)
+	assert.Equal(t, "text/css; charset=utf-8", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "6", resp.Header.Get("Content-Length"))
+	assert.Equal(t, "Sat, 01 Jun 2024 12:00:00 GMT", resp.Header.Get("Last-Modified"))
+
+	// Test: Content-Type is sniffed when the extension is unknown
+	resp, body = do(t, fileServer.Handle, "GET", "/notes", nil)
+	assert.Equal(t, "application/pdf", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "%PDF-1.4 fake", body)
+
+	// Test: Directories serve their index file
+	resp, body = do(t, fileServer.Handle, "GET", "/", nil)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "<h1>home</h1>", body)
+	resp, body = do(t, fileServer.Handle, "GET", "/docs/", nil)
+	assert.Equal(t, "<h1>docs</h1>", body)
+
+	// Test: Directory without trailing slash redirects
+	resp, _ = do(t, fileServer.Handle, "GET", "/docs?x=1", nil)
+	assert.Equal(t, 301, resp.StatusCode)
+	assert.Equal(t, "/docs/?x=1", resp.Header.Get("Location"))
+
+	// Test: Directory without an index is not found
+	resp, _ = do(t, fileServer.Handle, "GET", "/empty/", nil)
+	assert.Equal(t, 404, resp.StatusCode)
+
+	// Test: Path traversal stays inside the root
+	resp, body = do(t, fileServer.Handle, "GET", "/../../docs/../style.css", nil)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "body{}", body)
+	resp, _ = do(t, fileServer.Handle, "GET", "/%2e%2e/%2e%2e/etc/passwd", nil)
+	assert.Equal(t, 404, resp.StatusCode)
+	resp, _ = do(t, fileServer.Handle, "GET", "/..%5c..%5cstyle.css", nil)
+	assert.Equal(t, 400, resp.StatusCode)
+
+	// Test: Missing files are 404
+	resp, _ = do(t, fileServer.Handle, "GET", "/missing.txt", nil)
+	assert.Equal(t, 404, resp.StatusCode)
+
+	// Test: HEAD sends headers only
+	resp, body = do(t, fileServer.Handle, "HEAD", "/style.css", nil)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "", body)
+
+	// Test: Other methods are refused with Allow
+	resp, _ = do(t, fileServer.Handle, "POST", "/style.css", nil)
+	assert.Equal(t, 405, resp.StatusCode)
+	assert.Equal(t, "GET, HEAD", resp.Header.Get("Allow"))
+
+	// Test: Files carry a strong ETag and answer conditional requests with 304
+	resp, _ = do(t, fileServer.Handle, "GET", "/style.css", nil)
+	etag := resp.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+	assert.True(t, strings.HasPrefix(etag, `"`))
+	resp, body = do(t, fileServer.Handle, "GET", "/style.css", map[string]string{"If-None-Match": etag})
+	assert.Equal(t, 304, resp.StatusCode)
+	assert.Equal(t, "", body)
+	resp, _ = do(t, fileServer.Handle, "GET", "/style.css", map[string]string{"If-Modified-Since": "Sat, 01 Jun 2024 12:00:00 GMT"})
+	assert.Equal(t, 304, resp.StatusCode)
+
+	// Test: StripPrefix maps a mount point onto the root
+	fileServer.StripPrefix = "/static"
+	_, body = do(t, fileServer.Handle, "GET", "/static/style.css", nil)
+	assert.Equal(t, "body{}", body)
+}
+
+func TestDir(t *testing.T) {
+	parent := t.TempDir()
+	root := filepath.Join(parent, "public")
+	require.NoError(t, os.Mkdir(root, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(parent, "secret.txt"), []byte("secret"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "page.txt"), []byte("public page"), 0o644))
+	require.NoError(t, os.Symlink("page.txt", filepath.Join(root, "alias.txt")))
+	require.NoError(t, os.Symlink("../secret.txt", filepath.Join(root, "escape.txt")))
+	require.NoError(t, os.Symlink(parent, filepath.Join(root, "up")))
+	fileServer := Dir(root)
+
+	// Test: Files and symlinks that stay inside the directory are served
+	resp, body := do(t, fileServer.Handle, "GET", "/page.txt", nil)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "public page", body)
+	_, body = do(t, fileServer.Handle, "GET", "/alias.txt", nil)
+	assert.Equal(t, "public page", body)
+
+	// Test: Symlinks that lead out of the directory are not followed
+	for _, target := range []string{"/escape.txt", "/up/secret.txt"} {
+		resp, body = do(t, fileServer.Handle, "GET", target, nil)
+		assert.Equal(t, 404, resp.StatusCode, target)
+		assert.NotContains(t, body, "secret", target)
+	}
+
+	// Test: A missing directory is a 404, not a startup failure
+	resp, _ = do(t, Dir(filepath.Join(parent, "missing")).Handle, "GET", "/page.txt", nil)
+	assert.Equal(t, 404, resp.StatusCode)
+}
+
+func TestParseRange(t *testing.T) {
+	// Test: First-last, open-ended and suffix ranges
+	ranges, err := ParseRange("bytes=0-4, 10-, -3", 20)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 5}, {Start: 10, Length: 10}, {Start: 17, Length: 3}}, ranges)
+
+	// Test: Last position past the end is clamped
+	ranges, err = ParseRange("bytes=15-100", 20)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 15, Length: 5}}, ranges)
+
+	// Test: Suffix longer than the representation selects all of it
+	ranges, err = ParseRange("bytes=-50", 20)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 20}}, ranges)
+
+	// Test: Ranges starting past the end are unsatisfiable
+	_, err = ParseRange("bytes=20-30", 20)
+	assert.ErrorIs(t, err, ErrUnsatisfiable)
+
+	// Test: Syntax errors and unknown units are not ErrUnsatisfiable
+	for _, value := range []string{"items=0-1", "bytes=5-2", "bytes=abc", "bytes=+1-2", "bytes="} {
+		_, err = ParseRange(value, 20)
+		require.Error(t, err, value)
+		assert.NotErrorIs(t, err, ErrUnsatisfiable, value)
+	}
+}
+
+func TestFileServerRanges(t *testing.T) {
+	fsys := fstest.MapFS{"clip.mp4": {Data: []byte("0123456789abcdefghij"), ModTime: modTime}}
+	fileServer := New(fsys)
+	lastModified := modTime.Format(http.TimeFormat)
+
+	// Test: Full responses advertise byte ranges
+	resp, _ := do(t, fileServer.Handle, "GET", "/clip.mp4", nil)
+	assert.Equal(t, "bytes", resp.Header.Get("Accept-Ranges"))
+
+	// Test: Single range returns 206 with Content-Range
+	resp, body := do(t, fileServer.Handle, "GET", "/clip.mp4", map[string]string{"Range": "bytes=2-5"})
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "2345", body)
+	assert.Equal(t, "bytes 2-5/20", resp.Header.Get("Content-Range"))
+	assert.Equal(t, "4", resp.Header.Get("Content-Length"))
+
+	// Test: Multiple ranges return multipart/byteranges
+	resp, body = do(t, fileServer.Handle, "GET", "/clip.mp4", map[string]string{"Range": "bytes=0-1,-2"})
+	assert.Equal(t, 206, resp.StatusCode)
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+	assert.Equal(t, fmt.Sprintf("%d", len(body)), resp.Header.Get("Content-Length"))
+	reader := multipart.NewReader(strings.NewReader(body), params["boundary"])
+	var parts []string
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		data, _ := io.ReadAll(part)
+		parts = append(parts, part.Header.Get("Content-Range")+"="+string(data))
+		assert.Equal(t, "video/mp4", part.Header.Get("Content-Type"))
+	}
+	assert.Equal(t, []string{"bytes 0-1/20=01", "bytes 18-19/20=ij"}, parts)
+
+	// Test: Overlapping and repeated ranges are merged instead of sending bytes twice
+	resp, body = do(t, fileServer.Handle, "GET", "/clip.mp4", map[string]string{"Range": "bytes=0-," + strings.Repeat("0-,", 98) + "0-"})
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "bytes 0-19/20", resp.Header.Get("Content-Range"))
+	assert.Equal(t, "0123456789abcdefghij", body)
+	resp, body = do(t, fileServer.Handle, "GET", "/clip.mp4", map[string]string{"Range": "bytes=4-6,0-1,2-3,5-9"})
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "bytes 0-9/20", resp.Header.Get("Content-Range"))
+	assert.Equal(t, "0123456789", body)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 5}, {Start: 10, Length: 10}}, coalesce([]ByteRange{{Start: 10, Length: 10}, {Start: 17, Length: 3}, {Start: 0, Length: 5}}))
+
+	// Test: Unsatisfiable ranges return 416 with the full size
+	resp, _ = do(t, fileServer.Handle, "GET", "/clip.mp4", map[string]string{"Range": "bytes=50-60"})
+	assert.Equal(t, 416, resp.StatusCode)
+	assert.Equal(t, "bytes */20", resp.Header.Get("Content-Range"))
+
+	// Test: Invalid ranges are ignored
+	resp, body = do(t, fileServer.Handle, "GET", "/clip.mp4", map[string]string{"Range": "bytes=9-1"})
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "0123456789abcdefghij", body)
+
+	// Test: If-Range with the current Last-Modified honours the range
+	resp, body = do(t, fileServer.Handle, "GET", "/clip.mp4", map[string]string{"Range": "bytes=0-0", "If-Range": lastModified})
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "0", body)
+
+	// Test: If-Range with the current ETag honours the range
+	etag := resp.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+	resp, body = do(t, fileServer.Handle, "GET", "/clip.mp4", map[string]string{"Range": "bytes=0-0", "If-Range": etag})
+	assert.Equal(t, 206, resp.StatusCode)
+	assert.Equal(t, "0", body)
+	resp, _ = do(t, fileServer.Handle, "GET", "/clip.mp4", map[string]string{"Range": "bytes=0-0", "If-Range": "W/" + etag})
+	assert.Equal(t, 200, resp.StatusCode)
+
+	// Test: If-Range with a stale validator sends the whole file
+	stale := modTime.Add(-time.Hour).Format(http.TimeFormat)
+	resp, body = do(t, fileServer.Handle, "GET", "/clip.mp4", map[string]string{"Range": "bytes=0-0", "If-Range": stale})
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, 20, len(body))
+
+	// Test: HEAD ignores Range
+	resp, _ = do(t, fileServer.Handle, "HEAD", "/clip.mp4", map[string]string{"Range": "bytes=0-0"})
+	assert.Equal(t, 200, resp.StatusCode)
+}
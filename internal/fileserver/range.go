@@ -0,0 +1,260 @@
+package fileserver
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kiefbc/http-server-1.1/internal/conditional"
+	"github.com/kiefbc/http-server-1.1/internal/headers"
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+)
+
+// maxRanges limits how many ranges one request may ask for before it is refused as abusive
+// (RFC 9110 Section 15.5.17).
+const maxRanges = 100
+
+// ErrUnsatisfiable means a Range header was understood but none of its ranges overlap the
+// representation, or it asked for too many ranges.
+var ErrUnsatisfiable = errors.New("range not satisfiable")
+
+// errInvalidRange means a Range header could not be parsed and must be ignored.
+var errInvalidRange = errors.New("invalid range")
+
+// ByteRange is a satisfiable byte range of a representation.
+type ByteRange struct {
+	Start  int64
+	Length int64
+}
+
+// contentRange formats the range for a Content-Range header (RFC 9110 Section 14.4).
+func (br ByteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.Start, br.Start+br.Length-1, size)
+}
+
+// ParseRange parses a Range header value against a representation of size bytes
+// (RFC 9110 Section 14.1.2). It supports first-last, open-ended first- and suffix -length
+// ranges. Ranges that fall entirely outside the representation are dropped; if none are
+// left ErrUnsatisfiable is returned. Syntax errors and unknown units return a different
+// error and should cause the header to be ignored.
+func ParseRange(value string, size int64) ([]ByteRange, error) {
+	specs, ok := strings.CutPrefix(strings.TrimSpace(value), "bytes=")
+	if !ok {
+		return nil, errInvalidRange
+	}
+
+	var ranges []ByteRange
+	count := 0
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		count++
+		if count > maxRanges {
+			return nil, ErrUnsatisfiable
+		}
+
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+
+		if first == "" {
+			// suffix-range: the final N bytes
+			suffix, err := parseDigits(last)
+			if err != nil {
+				return nil, errInvalidRange
+			}
+			if suffix == 0 || size == 0 {
+				continue
+			}
+			suffix = min(suffix, size)
+			ranges = append(ranges, ByteRange{Start: size - suffix, Length: suffix})
+			continue
+		}
+
+		start, err := parseDigits(first)
+		if err != nil {
+			return nil, errInvalidRange
+		}
+		end := size - 1
+		if last != "" {
+			end, err = parseDigits(last)
+			if err != nil || end < start {
+				return nil, errInvalidRange
+			}
+			end = min(end, size-1)
+		}
+		if start >= size {
+			continue
+		}
+		ranges = append(ranges, ByteRange{Start: start, Length: end - start + 1})
+	}
+
+	if count == 0 {
+		return nil, errInvalidRange
+	}
+	if len(ranges) == 0 {
+		return nil, ErrUnsatisfiable
+	}
+	return ranges, nil
+}
+
+// coalesce merges overlapping and adjacent ranges into ascending, disjoint ones, so a
+// response never carries the same bytes twice however the ranges were asked for
+// (RFC 9110 Section 14.2).
+func coalesce(ranges []ByteRange) []ByteRange {
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b ByteRange) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+	merged := sorted[:1]
+	for _, br := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if end := last.Start + last.Length; br.Start <= end {
+			last.Length = max(end, br.Start+br.Length) - last.Start
+			continue
+		}
+		merged = append(merged, br)
+	}
+	return merged
+}
+
+// parseDigits parses a non-empty run of ASCII digits; signs and spaces are not allowed.
+func parseDigits(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, errInvalidRange
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// ifRangeAllows evaluates If-Range (RFC 9110 Section 13.1.5): the Range header only applies
+// if the validator still matches the representation about to be sent.
+func ifRangeAllows(req *request.Request, responseHeaders headers.Headers) bool {
+	ifRange, ok := req.Headers.Get("If-Range")
+	if !ok {
+		return true
+	}
+	ifRange = strings.TrimSpace(ifRange)
+
+	if strings.HasPrefix(ifRange, `"`) || conditional.IsWeak(ifRange) {
+		// If-Range needs a strong match; weak tags never match
+		etag, _ := responseHeaders.Get("ETag")
+		return conditional.StrongMatch(ifRange, etag)
+	}
+
+	lastModified, ok := responseHeaders.Get("Last-Modified")
+	if !ok {
+		return false
+	}
+	since, err := http.ParseTime(ifRange)
+	modified, modErr := http.ParseTime(lastModified)
+	return err == nil && modErr == nil && since.Equal(modified)
+}
+
+// serveRanges answers a range request for content of size bytes. It writes 206 with the
+// single range or a multipart/byteranges body, or 416 if nothing is satisfiable. Overlapping
+// and adjacent ranges are merged first, so the body is never larger than the content.
+// It returns false if the Range header is unusable and the full representation should be sent.
+func serveRanges(w *response.Writer, rangeHeader string, content io.ReadSeeker, size int64, responseHeaders headers.Headers) bool {
+	ranges, err := ParseRange(rangeHeader, size)
+	if errors.Is(err, ErrUnsatisfiable) {
+		body := []byte("Range Not Satisfiable")
+		errorHeaders := response.GetDefaultHeaders(len(body))
+		errorHeaders.Replace("content-range", fmt.Sprintf("bytes */%d", size))
+		errorHeaders.Replace("accept-ranges", "bytes")
+
+		w.WriteStatusLine(response.StatusRangeNotSatisfiable)
+		w.WriteHeaders(errorHeaders)
+		w.WriteBody(body)
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	ranges = coalesce(ranges)
+
+	if len(ranges) == 1 {
+		br := ranges[0]
+		if _, err := content.Seek(br.Start, io.SeekStart); err != nil {
+			return false
+		}
+		responseHeaders.Replace("content-range", br.contentRange(size))
+		responseHeaders.Replace("content-length", fmt.Sprintf("%d", br.Length))
+
+		w.WriteStatusLine(response.StatusPartialContent)
+		w.WriteHeaders(responseHeaders)
+		io.CopyBuffer(bodyWriter{w}, io.LimitReader(content, br.Length), make([]byte, copyBufferSize))
+		return true
+	}
+
+	// Multiple ranges go out as multipart/byteranges (RFC 9110 Section 14.6).
+	// A dry run without the data gives the exact Content-Length up front.
+	contentType, _ := responseHeaders.Get("Content-Type")
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	counter := &countingWriter{}
+	writeByteranges(counter, nil, ranges, size, contentType, boundary)
+	contentLength := counter.n
+	for _, br := range ranges {
+		contentLength += br.Length
+	}
+
+	responseHeaders.Replace("content-type", "multipart/byteranges; boundary="+boundary)
+	responseHeaders.Replace("content-length", fmt.Sprintf("%d", contentLength))
+
+	w.WriteStatusLine(response.StatusPartialContent)
+	w.WriteHeaders(responseHeaders)
+	writeByteranges(bodyWriter{w}, content, ranges, size, contentType, boundary)
+	return true
+}
+
+// writeByteranges writes the multipart body. With a nil content only the boundaries and
+// part headers are written, which is how the Content-Length is computed.
+func writeByteranges(dst io.Writer, content io.ReadSeeker, ranges []ByteRange, size int64, contentType, boundary string) error {
+	mw := multipart.NewWriter(dst)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+
+	for _, br := range ranges {
+		partHeader := textproto.MIMEHeader{}
+		if contentType != "" {
+			partHeader.Set("Content-Type", contentType)
+		}
+		partHeader.Set("Content-Range", br.contentRange(size))
+
+		part, err := mw.CreatePart(partHeader)
+		if err != nil {
+			return err
+		}
+		if content == nil {
+			continue
+		}
+		if _, err := content.Seek(br.Start, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(part, content, br.Length); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.n += int64(len(p))
+	return len(p), nil
+}
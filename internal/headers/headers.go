@@ -89,6 +89,22 @@ func (h Headers) Get(key string) (value string, ok bool) {
 	return "", false
 }
 
+// AddVary adds field to the Vary header unless it, or "*", is already listed (RFC 9110 Section 12.5.5).
+func (h Headers) AddVary(field string) {
+	vary, ok := h.Get("Vary")
+	if !ok || strings.TrimSpace(vary) == "" {
+		h.Replace("vary", field)
+		return
+	}
+	for _, listed := range strings.Split(vary, ",") {
+		listed = strings.TrimSpace(listed)
+		if listed == "*" || strings.EqualFold(listed, field) {
+			return
+		}
+	}
+	h.Replace("vary", vary+", "+field)
+}
+
 // validationToken checks if the header key contains only valid ASCII characters.
 func validationToken(key string) bool {
 	// Validate header key contains only ASCII characters (RFC 9110 Section 5.1)
@@ -106,3 +106,17 @@ func TestParse(t *testing.T) {
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 }
+
+func TestAddVary(t *testing.T) {
+	// Test: Fields are appended once, case-insensitively
+	h := NewHeaders()
+	h.AddVary("Origin")
+	h.AddVary("Accept-Encoding")
+	h.AddVary("origin")
+	assert.Equal(t, "Origin, Accept-Encoding", h["vary"])
+
+	// Test: Vary: * already covers every field
+	h.Replace("vary", "*")
+	h.AddVary("Origin")
+	assert.Equal(t, "*", h["vary"])
+}
@@ -0,0 +1,146 @@
+package ipfilter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+)
+
+// ParsePrefixes parses CIDRs such as "10.0.0.0/8". Bare addresses become single-host prefixes.
+func ParsePrefixes(cidrs ...string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("parse %q: %w", cidr, err)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("parse %q: %w", cidr, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// TrustedProxies resolves the real client address of requests that arrive through proxies
+// it trusts. Forwarding headers are only believed when the peer that sent them is trusted;
+// anyone else could set them to any address.
+type TrustedProxies struct {
+	trusted []netip.Prefix
+}
+
+// NewTrustedProxies trusts the proxies in cidrs. With none, every request's client is its peer.
+func NewTrustedProxies(cidrs ...string) (*TrustedProxies, error) {
+	trusted, err := ParsePrefixes(cidrs...)
+	if err != nil {
+		return nil, err
+	}
+	return &TrustedProxies{trusted: trusted}, nil
+}
+
+// Resolve returns the client address of req. Starting from the peer, it walks the Forwarded
+// header (RFC 7239), or X-Forwarded-For if there is none, from the nearest hop outwards and
+// stops at the first address that is not a trusted proxy. A hop it cannot parse, such as an
+// obfuscated identifier, ends the walk at the proxy that reported it.
+func (tp *TrustedProxies) Resolve(req *request.Request) (netip.Addr, bool) {
+	client, ok := parseHost(req.RemoteAddr)
+	if !ok {
+		return netip.Addr{}, false
+	}
+
+	hops := forwardedFor(req)
+	for i := len(hops) - 1; i >= 0 && contains(tp.trusted, client); i-- {
+		hop, ok := parseHost(hops[i])
+		if !ok {
+			break
+		}
+		client = hop
+	}
+	return client, true
+}
+
+// Middleware resolves each request's client address, available through ClientIP.
+func (tp *TrustedProxies) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		if client, ok := tp.Resolve(req); ok {
+			req = req.WithContext(context.WithValue(req.Context(), contextKey{}, client))
+		}
+		return next(w, req)
+	}
+}
+
+type contextKey struct{}
+
+// ClientIP returns the client address TrustedProxies.Middleware resolved for req, or, without
+// that middleware, the address of the peer. RemoteAddr itself is left as the peer so the
+// reverse proxy still appends the right hop to X-Forwarded-For.
+func ClientIP(req *request.Request) (netip.Addr, bool) {
+	if client, ok := req.Context().Value(contextKey{}).(netip.Addr); ok {
+		return client, true
+	}
+	return parseHost(req.RemoteAddr)
+}
+
+// forwardedFor lists the client addresses recorded by proxies, nearest last.
+func forwardedFor(req *request.Request) []string {
+	if forwarded, ok := req.Headers.Get("Forwarded"); ok {
+		var hops []string
+		for _, element := range strings.Split(forwarded, ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(name, "for") {
+					hop = strings.Trim(value, `"`)
+				}
+			}
+			// An element without "for" still stands for a hop that did not disclose its client
+			hops = append(hops, hop)
+		}
+		return hops
+	}
+
+	xff, ok := req.Headers.Get("X-Forwarded-For")
+	if !ok {
+		return nil
+	}
+	hops := strings.Split(xff, ",")
+	for i := range hops {
+		hops[i] = strings.TrimSpace(hops[i])
+	}
+	return hops
+}
+
+// parseHost parses an address with or without a port, in the forms used by RemoteAddr,
+// X-Forwarded-For ("192.0.2.1", "2001:db8::1") and Forwarded ("[2001:db8::1]:4711").
+func parseHost(s string) (netip.Addr, bool) {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
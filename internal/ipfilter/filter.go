@@ -0,0 +1,51 @@
+package ipfilter
+
+import (
+	"net/netip"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+)
+
+// Filter is middleware that admits or refuses requests by client address.
+type Filter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// NewFilter creates a Filter from CIDR lists. A client in deny is refused even if it is also in
+// allow. If allow is empty every client not denied is admitted; otherwise only clients in
+// allow are.
+func NewFilter(allow, deny []string) (*Filter, error) {
+	allowPrefixes, err := ParsePrefixes(allow...)
+	if err != nil {
+		return nil, err
+	}
+	denyPrefixes, err := ParsePrefixes(deny...)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{allow: allowPrefixes, deny: denyPrefixes}, nil
+}
+
+// Allowed reports whether addr passes the rules.
+func (f *Filter) Allowed(addr netip.Addr) bool {
+	if contains(f.deny, addr) {
+		return false
+	}
+	return len(f.allow) == 0 || contains(f.allow, addr)
+}
+
+// Middleware answers 403 Forbidden to clients the rules refuse, and to requests whose client
+// address is unknown. The address comes from ClientIP, so place TrustedProxies.Middleware
+// first when running behind proxies.
+func (f *Filter) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		client, ok := ClientIP(req)
+		if !ok || !f.Allowed(client) {
+			return &server.HandlerError{StatusCode: response.StatusForbidden, Message: "403 Forbidden"}
+		}
+		return next(w, req)
+	}
+}
@@ -0,0 +1,116 @@
+package ipfilter
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/kiefbc/http-server-1.1/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRequest parses a GET from remoteAddr with the extra headers.
+func newRequest(t *testing.T, remoteAddr string, extra map[string]string) *request.Request {
+	t.Helper()
+	req := servertest.NewRequest(t, "GET", "/", extra)
+	req.RemoteAddr = remoteAddr
+	return req
+}
+
+// do runs handler for a request from remoteAddr and returns the response status and body.
+func do(t *testing.T, handler server.Handler, remoteAddr string, extra map[string]string) (int, string) {
+	t.Helper()
+	resp, body := servertest.Do(t, handler, newRequest(t, remoteAddr, extra))
+	return resp.StatusCode, body
+}
+
+// echo answers with the resolved client IP.
+func echo(w *response.Writer, req *request.Request) *server.HandlerError {
+	client, _ := ClientIP(req)
+	body := []byte(client.String())
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+	return nil
+}
+
+func TestTrustedProxies(t *testing.T) {
+	tp, err := NewTrustedProxies("10.0.0.0/8", "2001:db8:ffff::/48")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name       string
+		remoteAddr string
+		extra      map[string]string
+		want       string
+	}{
+		{"no proxy", "198.51.100.7:5000", nil, "198.51.100.7"},
+		{"untrusted peer's header is ignored", "198.51.100.7:5000", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "198.51.100.7"},
+		{"trusted peer", "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "203.0.113.9"}, "203.0.113.9"},
+		{"spoofed leftmost entry is skipped", "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "1.2.3.4, 203.0.113.9, 10.0.0.2"}, "203.0.113.9"},
+		{"all hops trusted", "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3"},
+		{"Forwarded wins", "10.0.0.1:5000", map[string]string{"Forwarded": `for=192.0.2.60;proto=https, for="[2001:db8::1]:4711"`, "X-Forwarded-For": "1.2.3.4"}, "2001:db8::1"},
+		{"obfuscated hop stops the walk", "10.0.0.1:5000", map[string]string{"Forwarded": "for=192.0.2.60, for=_hidden"}, "10.0.0.1"},
+		{"IPv6 peer", "[2001:db8:ffff::1]:443", map[string]string{"X-Forwarded-For": "::ffff:192.0.2.4"}, "192.0.2.4"},
+	} {
+		// Test: Forwarding headers are followed only through trusted proxies
+		client, ok := tp.Resolve(newRequest(t, tc.remoteAddr, tc.extra))
+		require.True(t, ok, tc.name)
+		assert.Equal(t, tc.want, client.String(), tc.name)
+	}
+
+	// Test: The middleware exposes the resolved address while RemoteAddr stays the peer
+	_, body := do(t, tp.Middleware(echo), "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "203.0.113.9"})
+	assert.Equal(t, "203.0.113.9", body)
+	_, body = do(t, echo, "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "203.0.113.9"})
+	assert.Equal(t, "10.0.0.1", body)
+
+	// Test: Bad CIDRs are reported
+	_, err = NewTrustedProxies("10.0.0.0/33")
+	assert.Error(t, err)
+}
+
+func TestFilter(t *testing.T) {
+	f, err := NewFilter([]string{"192.0.2.0/24", "2001:db8::/32"}, []string{"192.0.2.13"})
+	require.NoError(t, err)
+	handler := f.Middleware(echo)
+
+	// Test: Allowed clients pass, denied and unlisted ones are forbidden
+	status, _ := do(t, handler, "192.0.2.1:5000", nil)
+	assert.Equal(t, 200, status)
+	status, _ = do(t, handler, "[2001:db8::5]:5000", nil)
+	assert.Equal(t, 200, status)
+	status, _ = do(t, handler, "192.0.2.13:5000", nil)
+	assert.Equal(t, 403, status)
+	status, _ = do(t, handler, "198.51.100.1:5000", nil)
+	assert.Equal(t, 403, status)
+	status, _ = do(t, handler, "", nil)
+	assert.Equal(t, 403, status)
+
+	// Test: A deny-only filter admits everyone else
+	f, err = NewFilter(nil, []string{"198.51.100.0/24"})
+	require.NoError(t, err)
+	assert.True(t, f.Allowed(mustAddr(t, "192.0.2.1")))
+	assert.False(t, f.Allowed(mustAddr(t, "198.51.100.200")))
+
+	// Test: Behind a trusted proxy the filter sees the real client
+	tp, err := NewTrustedProxies("10.0.0.0/8")
+	require.NoError(t, err)
+	f, err = NewFilter(nil, []string{"203.0.113.9"})
+	require.NoError(t, err)
+	handler = server.Chain(echo, tp.Middleware, f.Middleware)
+	status, _ = do(t, handler, "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "203.0.113.9"})
+	assert.Equal(t, 403, status)
+	status, _ = do(t, handler, "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "203.0.113.10"})
+	assert.Equal(t, 200, status)
+}
+
+func mustAddr(t *testing.T, s string) netip.Addr {
+	t.Helper()
+	addr, ok := parseHost(s)
+	require.True(t, ok)
+	return addr
+}
@@ -0,0 +1,218 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// Key is one verification key from a JSON Web Key Set (RFC 7517).
+type Key struct {
+	ID string
+	// Algorithm is the JWK "alg" member; if set, the key only verifies tokens signed with it.
+	Algorithm string
+	// Public is an *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey or, for HS256, a []byte secret.
+	Public crypto.PublicKey
+}
+
+// jwk holds the JWK members this package understands (RFC 7518 Section 6).
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// ParseJWKS parses a JWK Set document. Keys meant for encryption ("use": "enc") and key
+// types this package cannot verify with are skipped.
+func ParseJWKS(data []byte) ([]Key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse JWKS: %w", err)
+	}
+
+	var keys []Key
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		public, err := k.publicKey()
+		if errors.Is(err, errUnsupportedKey) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse JWKS key %d (%q): %w", i, k.Kid, err)
+		}
+		keys = append(keys, Key{ID: k.Kid, Algorithm: k.Alg, Public: public})
+	}
+	return keys, nil
+}
+
+var errUnsupportedKey = errors.New("unsupported key type")
+
+// publicKey decodes the key material for the key types of the supported algorithms.
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("modulus: %w", err)
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("exponent: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("exponent out of range")
+		}
+		if n.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must have at least 2048 bits")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, errUnsupportedKey
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("x: %w", err)
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("y: %w", err)
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("point is not on P-256")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errUnsupportedKey
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return nil, fmt.Errorf("k: %w", err)
+		}
+		// RFC 7518 Section 3.2: the key must be at least as long as the hash output
+		if len(secret) < 32 {
+			return nil, errors.New("HS256 secrets must be at least 32 bytes")
+		}
+		return secret, nil
+	}
+	return nil, errUnsupportedKey
+}
+
+// decodeInt decodes a base64url big-endian unsigned integer (RFC 7518 Section 2).
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// KeySet is a JWKS file that is reloaded when it changes on disk, so keys can be rotated
+// without a restart.
+type KeySet struct {
+	path string
+	now  func() time.Time
+
+	// CheckInterval is how often the file is checked for changes; 0 checks on every lookup.
+	CheckInterval time.Duration
+	// OnError, if set, is told when a changed file cannot be loaded. The previous keys stay in use.
+	OnError func(err error)
+
+	mu        sync.Mutex
+	keys      []Key
+	modTime   time.Time
+	size      int64
+	lastCheck time.Time
+}
+
+// LoadKeySet reads the JWKS file at path and returns a KeySet that checks it for changes
+// every 10 seconds.
+func LoadKeySet(path string) (*KeySet, error) {
+	ks := &KeySet{path: path, now: time.Now, CheckInterval: 10 * time.Second}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := ks.load(info); err != nil {
+		return nil, err
+	}
+	ks.lastCheck = ks.now()
+	return ks, nil
+}
+
+// Keys returns the current keys, reloading the file first if it has changed.
+func (ks *KeySet) Keys() []Key {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	now := ks.now()
+	if now.Sub(ks.lastCheck) >= ks.CheckInterval {
+		ks.lastCheck = now
+		ks.reload()
+	}
+	return ks.keys
+}
+
+// reload reparses the file if its modification time or size changed.
+func (ks *KeySet) reload() {
+	info, err := os.Stat(ks.path)
+	if err == nil && info.ModTime().Equal(ks.modTime) && info.Size() == ks.size {
+		return
+	}
+	if err == nil {
+		err = ks.load(info)
+	}
+	if err != nil && ks.OnError != nil {
+		ks.OnError(err)
+	}
+}
+
+// load replaces the keys with the file's contents.
+func (ks *KeySet) load(info os.FileInfo) error {
+	data, err := os.ReadFile(ks.path)
+	if err != nil {
+		return err
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return err
+	}
+	ks.keys = keys
+	ks.modTime = info.ModTime()
+	ks.size = info.Size()
+	return nil
+}
@@ -0,0 +1,264 @@
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/auth"
+)
+
+// Errors returned by Validate. They are auth token errors, so the Bearer middleware sends
+// their text to the client as the error_description; they say what failed without echoing
+// the token.
+var (
+	ErrMalformed        = auth.NewTokenError("malformed token")
+	ErrAlgorithm        = auth.NewTokenError("signing algorithm not allowed")
+	ErrUnknownKey       = auth.NewTokenError("no key matches the token")
+	ErrInvalidSignature = auth.NewTokenError("invalid signature")
+	ErrExpired          = auth.NewTokenError("token has expired")
+	ErrNotYetValid      = auth.NewTokenError("token is not valid yet")
+	ErrIssuer           = auth.NewTokenError("unexpected issuer")
+	ErrAudience         = auth.NewTokenError("token is not meant for this audience")
+)
+
+// KeySource supplies the keys tokens are verified with. *KeySet is the file-backed implementation.
+type KeySource interface {
+	Keys() []Key
+}
+
+// Validator verifies JWS compact serialised JWTs (RFC 7519) and plugs into
+// auth.NewBearer as its TokenValidator.
+type Validator struct {
+	keys KeySource
+	now  func() time.Time
+
+	// Algorithms lists the accepted "alg" values. "none" is never accepted.
+	Algorithms []string
+	// Issuer, if set, must equal the "iss" claim.
+	Issuer string
+	// Audience, if set, must appear in the "aud" claim.
+	Audience string
+	// Skew is the clock difference tolerated when checking "exp" and "nbf".
+	Skew time.Duration
+}
+
+// NewValidator creates a Validator that accepts RS256, ES256, EdDSA and HS256 signatures
+// from keys, with one minute of clock skew.
+func NewValidator(keys KeySource) *Validator {
+	return &Validator{
+		keys:       keys,
+		now:        time.Now,
+		Algorithms: []string{"RS256", "ES256", "EdDSA", "HS256"},
+		Skew:       time.Minute,
+	}
+}
+
+type header struct {
+	Alg  string   `json:"alg"`
+	Kid  string   `json:"kid"`
+	Crit []string `json:"crit"`
+}
+
+// Validate verifies the token's signature and claims and returns a Principal named after the
+// "sub" claim, carrying all claims. It implements auth.TokenValidator.
+func (v *Validator) Validate(ctx context.Context, token string) (*auth.Principal, error) {
+	claims, err := v.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	sub, _ := claims["sub"].(string)
+	return &auth.Principal{Name: sub, Scheme: "Bearer", Claims: claims}, nil
+}
+
+// Verify checks the token and returns its claims. Numeric claims are json.Number.
+func (v *Validator) Verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, ErrMalformed
+	}
+	// RFC 7515 Section 4.1.11: extensions we do not understand must not be ignored
+	if len(h.Crit) > 0 {
+		return nil, fmt.Errorf("%w: unsupported critical header", ErrMalformed)
+	}
+	if h.Alg == "none" || !slices.Contains(v.Algorithms, h.Alg) {
+		return nil, ErrAlgorithm
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	if err := v.verifySignature(h, signed, signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrMalformed
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// verifySignature tries the keys that could have made the signature: the one named by "kid",
+// or, without a kid, every key of the right type.
+func (v *Validator) verifySignature(h header, signed, signature []byte) error {
+	matched := false
+	for _, key := range v.keys.Keys() {
+		if h.Kid != "" && key.ID != h.Kid {
+			continue
+		}
+		if key.Algorithm != "" && key.Algorithm != h.Alg {
+			continue
+		}
+		ok, usable := verify(h.Alg, key, signed, signature)
+		if !usable {
+			continue
+		}
+		matched = true
+		if ok {
+			return nil
+		}
+	}
+	if !matched {
+		return ErrUnknownKey
+	}
+	return ErrInvalidSignature
+}
+
+// verify checks signature with key for alg (RFC 7518 Section 3). usable is false when the key
+// type does not fit the algorithm, which keeps e.g. an RSA public key from being used as an
+// HMAC secret.
+func verify(alg string, key Key, signed, signature []byte) (ok, usable bool) {
+	digest := sha256.Sum256(signed)
+	switch alg {
+	case "RS256":
+		public, isRSA := key.Public.(*rsa.PublicKey)
+		if !isRSA {
+			return false, false
+		}
+		return rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], signature) == nil, true
+
+	case "ES256":
+		public, isEC := key.Public.(*ecdsa.PublicKey)
+		if !isEC {
+			return false, false
+		}
+		// The signature is R || S, each 32 bytes (RFC 7518 Section 3.4)
+		if len(signature) != 64 {
+			return false, true
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(public, digest[:], r, s), true
+
+	case "EdDSA":
+		public, isEd := key.Public.(ed25519.PublicKey)
+		if !isEd {
+			return false, false
+		}
+		return ed25519.Verify(public, signed, signature), true
+
+	case "HS256":
+		secret, isSecret := key.Public.([]byte)
+		if !isSecret {
+			return false, false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature), true
+	}
+	return false, false
+}
+
+// checkClaims applies the registered claims (RFC 7519 Section 4.1).
+func (v *Validator) checkClaims(claims map[string]any) error {
+	now := v.now()
+
+	if exp, ok, err := numericDate(claims, "exp"); err != nil {
+		return err
+	} else if ok && !now.Before(exp.Add(v.Skew)) {
+		return ErrExpired
+	}
+	if nbf, ok, err := numericDate(claims, "nbf"); err != nil {
+		return err
+	} else if ok && now.Add(v.Skew).Before(nbf) {
+		return ErrNotYetValid
+	}
+
+	if v.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.Issuer {
+			return ErrIssuer
+		}
+	}
+	if v.Audience != "" && !hasAudience(claims["aud"], v.Audience) {
+		return ErrAudience
+	}
+	return nil
+}
+
+// numericDate reads a NumericDate claim: seconds since the epoch, possibly fractional.
+func numericDate(claims map[string]any, name string) (time.Time, bool, error) {
+	raw, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, isNumber := raw.(json.Number)
+	if !isNumber {
+		return time.Time{}, false, fmt.Errorf("%w: %s is not a number", ErrMalformed, name)
+	}
+	seconds, err := n.Float64()
+	if err != nil || math.IsInf(seconds, 0) {
+		return time.Time{}, false, fmt.Errorf("%w: %s is not a number", ErrMalformed, name)
+	}
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9)), true, nil
+}
+
+// hasAudience reports whether aud, a string or an array of strings, contains want.
+func hasAudience(aud any, want string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == want
+	case []any:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// decodeSegment decodes a base64url JSON segment, keeping numbers as json.Number.
+func decodeSegment(segment string, into any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(into)
+}
@@ -0,0 +1,268 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/auth"
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/kiefbc/http-server-1.1/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var b64 = base64.RawURLEncoding
+
+// signer makes tokens for one algorithm and describes its key as a JWK.
+type signer struct {
+	alg  string
+	kid  string
+	jwk  map[string]string
+	sign func(data []byte) []byte
+}
+
+func newSigners(t *testing.T) []signer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	secret := bytes.Repeat([]byte("k"), 32)
+
+	return []signer{
+		{"RS256", "rsa", map[string]string{
+			"kty": "RSA",
+			"n":   b64.EncodeToString(rsaKey.N.Bytes()),
+			"e":   b64.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		}, func(data []byte) []byte {
+			digest := sha256.Sum256(data)
+			sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+			require.NoError(t, err)
+			return sig
+		}},
+		{"ES256", "ec", map[string]string{
+			"kty": "EC", "crv": "P-256",
+			"x": b64.EncodeToString(ecKey.X.FillBytes(make([]byte, 32))),
+			"y": b64.EncodeToString(ecKey.Y.FillBytes(make([]byte, 32))),
+		}, func(data []byte) []byte {
+			digest := sha256.Sum256(data)
+			r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+			require.NoError(t, err)
+			return append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+		}},
+		{"EdDSA", "ed", map[string]string{
+			"kty": "OKP", "crv": "Ed25519", "x": b64.EncodeToString(edPublic),
+		}, func(data []byte) []byte {
+			return ed25519.Sign(edPrivate, data)
+		}},
+		{"HS256", "hmac", map[string]string{
+			"kty": "oct", "k": b64.EncodeToString(secret),
+		}, func(data []byte) []byte {
+			mac := hmac.New(sha256.New, secret)
+			mac.Write(data)
+			return mac.Sum(nil)
+		}},
+	}
+}
+
+// token signs claims with s; an empty kid leaves it out of the header.
+func (s signer) token(t *testing.T, kid string, claims map[string]any) string {
+	t.Helper()
+	h := map[string]string{"alg": s.alg, "typ": "JWT"}
+	if kid != "" {
+		h["kid"] = kid
+	}
+	headerJSON, err := json.Marshal(h)
+	require.NoError(t, err)
+	claimsJSON, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := b64.EncodeToString(headerJSON) + "." + b64.EncodeToString(claimsJSON)
+	return signed + "." + b64.EncodeToString(s.sign([]byte(signed)))
+}
+
+// writeJWKS writes the signers' keys to path.
+func writeJWKS(t *testing.T, path string, signers []signer) {
+	t.Helper()
+	var keys []map[string]string
+	for _, s := range signers {
+		k := map[string]string{"kid": s.kid, "use": "sig"}
+		for name, value := range s.jwk {
+			k[name] = value
+		}
+		keys = append(keys, k)
+	}
+	data, err := json.Marshal(map[string]any{"keys": keys})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+func TestValidator(t *testing.T) {
+	signers := newSigners(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, signers)
+	keys, err := LoadKeySet(path)
+	require.NoError(t, err)
+
+	clock := time.Unix(1_700_000_000, 0)
+	v := NewValidator(keys)
+	v.now = func() time.Time { return clock }
+	v.Issuer = "https://issuer.example"
+	v.Audience = "api"
+	claims := map[string]any{
+		"sub": "alice",
+		"iss": "https://issuer.example",
+		"aud": []string{"other", "api"},
+		"exp": clock.Add(time.Hour).Unix(),
+		"nbf": clock.Unix(),
+	}
+
+	// Test: Every supported algorithm verifies, with or without a kid
+	for _, s := range signers {
+		p, err := v.Validate(t.Context(), s.token(t, s.kid, claims))
+		require.NoError(t, err, s.alg)
+		assert.Equal(t, "alice", p.Name)
+		assert.Equal(t, "Bearer", p.Scheme)
+		_, err = v.Verify(s.token(t, "", claims))
+		assert.NoError(t, err, s.alg)
+	}
+	rs256, es256 := signers[0], signers[1]
+
+	// Test: Tampered payloads and foreign signatures are rejected
+	good := rs256.token(t, "rsa", claims)
+	parts := strings.Split(good, ".")
+	forged, _ := json.Marshal(map[string]any{"sub": "mallory"})
+	_, err = v.Verify(parts[0] + "." + b64.EncodeToString(forged) + "." + parts[2])
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+	_, err = v.Verify(es256.token(t, "rsa", claims))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	_, err = v.Verify(rs256.token(t, "missing", claims))
+	assert.ErrorIs(t, err, ErrUnknownKey)
+
+	// Test: alg=none and disallowed algorithms are refused
+	none := b64.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+	_, err = v.Verify(none)
+	assert.ErrorIs(t, err, ErrAlgorithm)
+	v.Algorithms = []string{"ES256"}
+	_, err = v.Verify(good)
+	assert.ErrorIs(t, err, ErrAlgorithm)
+	v.Algorithms = []string{"RS256", "ES256", "EdDSA", "HS256"}
+
+	// Test: Time claims are checked with skew
+	clock = clock.Add(time.Hour + 30*time.Second)
+	_, err = v.Verify(good)
+	assert.NoError(t, err)
+	clock = clock.Add(time.Minute)
+	_, err = v.Verify(good)
+	assert.ErrorIs(t, err, ErrExpired)
+	clock = time.Unix(1_700_000_000, 0).Add(-2 * time.Minute)
+	_, err = v.Verify(good)
+	assert.ErrorIs(t, err, ErrNotYetValid)
+	clock = time.Unix(1_700_000_000, 0)
+
+	// Test: Issuer and audience must match
+	v.Issuer = "https://elsewhere.example"
+	_, err = v.Verify(good)
+	assert.ErrorIs(t, err, ErrIssuer)
+	v.Issuer = ""
+	v.Audience = "billing"
+	_, err = v.Verify(good)
+	assert.ErrorIs(t, err, ErrAudience)
+	v.Audience = "api"
+
+	// Test: Garbage is malformed
+	for _, token := range []string{"", "a.b", "a.b.c", "!!.!!.!!"} {
+		_, err = v.Verify(token)
+		assert.ErrorIs(t, err, ErrMalformed, token)
+	}
+}
+
+func TestKeySetReload(t *testing.T) {
+	signers := newSigners(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, signers[:1])
+	keys, err := LoadKeySet(path)
+	require.NoError(t, err)
+	keys.CheckInterval = 0
+	var loadErr error
+	keys.OnError = func(err error) { loadErr = err }
+	v := NewValidator(keys)
+	ed := signers[2]
+	token := ed.token(t, ed.kid, map[string]any{"sub": "svc"})
+
+	// Test: A key added to the file is picked up without a restart
+	_, err = v.Verify(token)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	writeJWKS(t, path, signers)
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, future, future))
+	_, err = v.Verify(token)
+	assert.NoError(t, err)
+
+	// Test: A broken file keeps the previous keys
+	require.NoError(t, os.WriteFile(path, []byte("{not json"), 0o600))
+	_, err = v.Verify(token)
+	assert.NoError(t, err)
+	assert.Error(t, loadErr)
+
+	// Test: Weak keys are refused
+	_, err = ParseJWKS([]byte(`{"keys":[{"kty":"oct","k":"c2hvcnQ"}]}`))
+	assert.Error(t, err)
+	parsed, err := ParseJWKS([]byte(`{"keys":[{"kty":"EC","crv":"P-521","x":"AA","y":"AA"},{"kty":"oct","use":"enc","k":"c2hvcnQ"}]}`))
+	require.NoError(t, err)
+	assert.Empty(t, parsed)
+}
+
+func TestBearerIntegration(t *testing.T) {
+	signers := newSigners(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, signers)
+	keys, err := LoadKeySet(path)
+	require.NoError(t, err)
+	hs256 := signers[3]
+
+	handler := auth.NewBearer("api", NewValidator(keys)).Middleware(
+		func(w *response.Writer, req *request.Request) *server.HandlerError {
+			p, _ := auth.FromRequest(req)
+			body := []byte(p.Name + " " + p.Claims["role"].(string))
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+			w.WriteBody(body)
+			return nil
+		})
+	do := func(token string) *http.Response {
+		resp, _ := servertest.Do(t, handler, servertest.NewRequest(t, "GET", "/", map[string]string{"Authorization": "Bearer " + token}))
+		return resp
+	}
+
+	// Test: Verified claims reach the handler
+	resp := do(hs256.token(t, "hmac", map[string]any{"sub": "bob", "role": "admin"}))
+	require.Equal(t, 200, resp.StatusCode)
+	var body bytes.Buffer
+	body.ReadFrom(resp.Body)
+	assert.Equal(t, "bob admin", body.String())
+
+	// Test: Expired tokens get an invalid_token challenge
+	resp = do(hs256.token(t, "hmac", map[string]any{"sub": "bob", "exp": 1}))
+	assert.Equal(t, 401, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("WWW-Authenticate"), `error="invalid_token", error_description="token has expired"`)
+}
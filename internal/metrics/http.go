@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// OtherRoute is the route label of requests that match none of the configured routes.
+const OtherRoute = "other"
+
+// HTTP collects request and connection metrics. Its Middleware records requests and it is a
+// server.Observer for what happens before a request reaches the handler.
+type HTTP struct {
+	routes []string
+	now    func() time.Time
+
+	requests    *CounterVec
+	duration    *HistogramVec
+	bytesIn     *CounterVec
+	bytesOut    *CounterVec
+	connections *CounterVec
+	activeConns *GaugeVec
+	parseErrors *CounterVec
+	inFlight    *GaugeVec
+}
+
+// NewHTTP registers the HTTP metrics in reg. Requests are labelled with the longest of routes
+// that matches their path: a route ending in "/" matches everything below it, any other route
+// only itself. Paths matching none are labelled OtherRoute, so clients cannot create series
+// at will.
+func NewHTTP(reg *Registry, routes ...string) *HTTP {
+	return &HTTP{
+		routes: routes,
+		now:    time.Now,
+
+		requests: reg.NewCounter("http_requests_total",
+			"Requests handled, by method, route and status code.", "method", "route", "status"),
+		duration: reg.NewHistogram("http_request_duration_seconds",
+			"Time from the request being parsed to its response being complete.", nil, "method", "route"),
+		bytesIn: reg.NewCounter("http_request_body_bytes_total",
+			"Request body bytes received.", "method", "route"),
+		bytesOut: reg.NewCounter("http_response_body_bytes_total",
+			"Response body bytes sent, after compression.", "method", "route"),
+		inFlight: reg.NewGauge("http_requests_in_flight",
+			"Requests currently being handled."),
+		connections: reg.NewCounter("http_connections_total",
+			"Connections accepted."),
+		activeConns: reg.NewGauge("http_active_connections",
+			"Connections currently being served."),
+		parseErrors: reg.NewCounter("http_request_parse_errors_total",
+			"Requests rejected with 400 Bad Request because they could not be parsed, by the part that failed.", "kind"),
+	}
+}
+
+// Middleware records each request once its response is complete. It finishes the response
+// with server.Finish and goes right after access logging in the chain (see server.Chain).
+func (m *HTTP) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		start := m.now()
+		inFlight := m.inFlight.With()
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		handlerErr := next(w, req)
+
+		server.Finish(w, handlerErr)
+
+		method, route := methodLabel(req.RequestLine.Method), m.route(req.RequestLine.RequestTarget)
+		m.requests.With(method, route, strconv.Itoa(int(w.StatusCode()))).Inc()
+		m.duration.With(method, route).Observe(m.now().Sub(start).Seconds())
+		m.bytesIn.With(method, route).Add(float64(len(req.Body)))
+		m.bytesOut.With(method, route).Add(float64(w.BytesWritten()))
+		return handlerErr
+	}
+}
+
+// ConnOpened implements server.Observer.
+func (m *HTTP) ConnOpened() {
+	m.connections.With().Inc()
+	m.activeConns.With().Inc()
+}
+
+// ConnClosed implements server.Observer.
+func (m *HTTP) ConnClosed() {
+	m.activeConns.With().Dec()
+}
+
+// ParseError implements server.Observer.
+func (m *HTTP) ParseError(err error) {
+	kind := "other"
+	var parseErr *request.ParseError
+	if errors.As(err, &parseErr) {
+		kind = string(parseErr.Kind)
+	}
+	m.parseErrors.With(kind).Inc()
+}
+
+// route returns the label for target's path.
+func (m *HTTP) route(target string) string {
+	path, _, _ := strings.Cut(target, "?")
+	best := OtherRoute
+	for _, route := range m.routes {
+		matches := path == route || (strings.HasSuffix(route, "/") && strings.HasPrefix(path, route))
+		if matches && (best == OtherRoute || len(route) > len(best)) {
+			best = route
+		}
+	}
+	return best
+}
+
+// methodLabel keeps the method label to the registered methods (RFC 9110 Section 9).
+func methodLabel(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "DELETE", "CONNECT", "OPTIONS", "TRACE", "PATCH":
+		return method
+	}
+	return "OTHER"
+}
+
+// Handler serves reg in the text exposition format; mount it on /metrics.
+func Handler(reg *Registry) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		if req.RequestLine.Method != "GET" && req.RequestLine.Method != "HEAD" {
+			body := []byte("Method Not Allowed")
+			responseHeaders := response.GetDefaultHeaders(len(body))
+			responseHeaders.Replace("allow", "GET, HEAD")
+
+			w.WriteStatusLine(response.StatusMethodNotAllowed)
+			w.WriteHeaders(responseHeaders)
+			w.WriteBody(body)
+			return nil
+		}
+
+		var body bytes.Buffer
+		reg.WriteTo(&body)
+		responseHeaders := response.GetDefaultHeaders(body.Len())
+		responseHeaders.Replace("content-type", ContentType)
+		responseHeaders.Replace("cache-control", "no-store")
+
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(responseHeaders)
+		if req.RequestLine.Method != "HEAD" {
+			w.WriteBody(body.Bytes())
+		}
+		return nil
+	}
+}
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/kiefbc/http-server-1.1/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scrape(t *testing.T, reg *Registry) string {
+	t.Helper()
+	var out bytes.Buffer
+	_, err := reg.WriteTo(&out)
+	require.NoError(t, err)
+	return out.String()
+}
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounter("requests_total", "Requests.\nBy code.", "code")
+	temperature := reg.NewGauge("temperature", "Current temperature.")
+	latency := reg.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1}, "path")
+
+	// Test: Counters, gauges and histograms render in the text exposition format
+	requests.With("200").Inc()
+	requests.With("200").Add(2)
+	requests.With(`say "hi"\`).Inc()
+	temperature.With().Set(21.5)
+	temperature.With().Dec()
+	latency.With("/").Observe(0.05)
+	latency.With("/").Observe(0.1)
+	latency.With("/").Observe(3)
+	assert.Equal(t, `# HELP requests_total Requests.\nBy code.
+# TYPE requests_total counter
+requests_total{code="200"} 3
+requests_total{code="say \"hi\"\\"} 1
+# HELP temperature Current temperature.
+# TYPE temperature gauge
+temperature 20.5
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{path="/",le="0.1"} 2
+latency_seconds_bucket{path="/",le="1"} 2
+latency_seconds_bucket{path="/",le="+Inf"} 3
+latency_seconds_sum{path="/"} 3.15
+latency_seconds_count{path="/"} 3
+`, scrape(t, reg))
+
+	// Test: Misuse panics
+	assert.Panics(t, func() { reg.NewGauge("temperature", "Again.") })
+	assert.Panics(t, func() { reg.NewCounter("bad-name", "Bad.") })
+	assert.Panics(t, func() { reg.NewHistogram("h", "Bad.", nil, "le") })
+	assert.Panics(t, func() { requests.With() })
+	assert.Panics(t, func() { requests.With("200").Add(-1) })
+}
+
+func TestHTTP(t *testing.T) {
+	reg := NewRegistry()
+	m := NewHTTP(reg, "/hello", "/api/", "/api/admin/")
+	clock := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	m.now = func() time.Time {
+		now := clock
+		clock = clock.Add(30 * time.Millisecond)
+		return now
+	}
+
+	handler := func(w *response.Writer, req *request.Request) *server.HandlerError {
+		if strings.HasPrefix(req.RequestLine.RequestTarget, "/api/") {
+			return &server.HandlerError{StatusCode: response.StatusNotFound, Message: "404 Not Found"}
+		}
+		body := []byte("hello")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+		return nil
+	}
+	s, err := server.Serve(0, m.Middleware(handler), server.WithObserver(m))
+	require.NoError(t, err)
+	defer s.Close()
+	send := func(raw string) {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte(raw))
+		require.NoError(t, err)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		// Requests that fail to parse are answered without being read in full, which can reset
+		io.ReadAll(conn)
+	}
+
+	send("GET /hello?x=1 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	send("POST /api/admin/users HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4\r\n\r\nabcd")
+	send("BREW /elsewhere HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	send("GET /hello HTTP/2.0\r\nHost: localhost\r\n\r\n")
+	send("GET /hello HTTP/1.1\r\nHost localhost\r\n\r\n")
+	out := scrape(t, reg)
+
+	// Test: Requests are counted by method, bounded route and final status
+	assert.Contains(t, out, `http_requests_total{method="GET",route="/hello",status="200"} 1`)
+	assert.Contains(t, out, `http_requests_total{method="POST",route="/api/admin/",status="404"} 1`)
+	assert.Contains(t, out, `http_requests_total{method="OTHER",route="other",status="200"} 1`)
+
+	// Test: Latency is observed and body bytes are counted both ways
+	assert.Contains(t, out, `http_request_duration_seconds_bucket{method="GET",route="/hello",le="0.025"} 0`)
+	assert.Contains(t, out, `http_request_duration_seconds_bucket{method="GET",route="/hello",le="0.05"} 1`)
+	assert.Contains(t, out, `http_request_body_bytes_total{method="POST",route="/api/admin/"} 4`)
+	assert.Contains(t, out, `http_response_body_bytes_total{method="POST",route="/api/admin/"} 13`)
+	assert.Contains(t, out, `http_response_body_bytes_total{method="GET",route="/hello"} 5`)
+
+	// Test: Connections and parse errors come from the server
+	assert.Contains(t, out, "http_connections_total 5")
+	assert.Contains(t, out, `http_request_parse_errors_total{kind="request_line"} 1`)
+	assert.Contains(t, out, `http_request_parse_errors_total{kind="headers"} 1`)
+	require.Eventually(t, func() bool {
+		return strings.Contains(scrape(t, reg), "http_active_connections 0")
+	}, time.Second, 10*time.Millisecond)
+
+	// Test: The handler serves the exposition format and rejects other methods
+	resp, body := servertest.Do(t, Handler(reg), servertest.NewRequest(t, "GET", "/metrics", nil))
+	assert.Equal(t, ContentType, resp.Header.Get("Content-Type"))
+	assert.Contains(t, body, "# TYPE http_requests_total counter")
+	resp, _ = servertest.Do(t, Handler(reg), servertest.NewRequest(t, "DELETE", "/metrics", nil))
+	assert.Equal(t, 405, resp.StatusCode)
+}
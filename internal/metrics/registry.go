@@ -0,0 +1,326 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBuckets are latency histogram bounds in seconds, the same as Prometheus clients use.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	validName  = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	validLabel = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Registry holds metric families and renders them in the Prometheus text exposition format.
+// Families are written in the order they were created.
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+type kind string
+
+const (
+	kindCounter   kind = "counter"
+	kindGauge     kind = "gauge"
+	kindHistogram kind = "histogram"
+)
+
+// family is one metric name with its series, one per combination of label values.
+type family struct {
+	name    string
+	help    string
+	kind    kind
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is a single time series. Counters and gauges use value; histograms use the rest
+// under mu so a scrape sees bucket counts, sum and count that agree.
+type series struct {
+	labelValues []string
+	value       atomic.Uint64 // float64 bits
+
+	mu     sync.Mutex
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// register adds a family. Bad or duplicate names are programming errors, so they panic.
+func (r *Registry) register(name, help string, k kind, buckets []float64, labels []string) *family {
+	if !validName.MatchString(name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	for _, label := range labels {
+		if !validLabel.MatchString(label) || strings.HasPrefix(label, "__") || (k == kindHistogram && label == "le") {
+			panic(fmt.Sprintf("metrics: invalid label name %q", label))
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range r.families {
+		if f.name == name {
+			panic(fmt.Sprintf("metrics: duplicate metric %q", name))
+		}
+	}
+	f := &family{name: name, help: help, kind: k, labels: labels, buckets: buckets, series: make(map[string]*series)}
+	r.families = append(r.families, f)
+	return f
+}
+
+// with returns the series for values, creating it on first use.
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s has %d labels, got %d values", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: slices.Clone(values)}
+		if f.kind == kindHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (s *series) add(delta float64) {
+	for {
+		old := s.value.Load()
+		if s.value.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (s *series) load() float64 {
+	return math.Float64frombits(s.value.Load())
+}
+
+// CounterVec is a counter family partitioned by labels.
+type CounterVec struct{ f *family }
+
+// Counter is a value that only goes up.
+type Counter struct{ s *series }
+
+// NewCounter creates a counter family. By convention its name ends in _total.
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, kindCounter, nil, labels)}
+}
+
+// With returns the counter for the given label values, in label order.
+func (v *CounterVec) With(values ...string) *Counter {
+	return &Counter{v.f.with(values)}
+}
+
+// Inc adds one.
+func (c *Counter) Inc() {
+	c.s.add(1)
+}
+
+// Add adds delta, which must not be negative.
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.s.add(delta)
+}
+
+// GaugeVec is a gauge family partitioned by labels.
+type GaugeVec struct{ f *family }
+
+// Gauge is a value that can go up and down.
+type Gauge struct{ s *series }
+
+// NewGauge creates a gauge family.
+func (r *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, kindGauge, nil, labels)}
+}
+
+// With returns the gauge for the given label values, in label order.
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return &Gauge{v.f.with(values)}
+}
+
+// Set replaces the value.
+func (g *Gauge) Set(value float64) {
+	g.s.value.Store(math.Float64bits(value))
+}
+
+// Add adds delta, which may be negative.
+func (g *Gauge) Add(delta float64) {
+	g.s.add(delta)
+}
+
+// Inc adds one.
+func (g *Gauge) Inc() {
+	g.s.add(1)
+}
+
+// Dec subtracts one.
+func (g *Gauge) Dec() {
+	g.s.add(-1)
+}
+
+// HistogramVec is a histogram family partitioned by labels.
+type HistogramVec struct{ f *family }
+
+// Histogram counts observations into buckets.
+type Histogram struct {
+	s       *series
+	buckets []float64
+}
+
+// NewHistogram creates a histogram family with the given upper bucket bounds; nil means
+// DefaultBuckets. The +Inf bucket is implied.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	if !slices.IsSorted(buckets) {
+		panic(fmt.Sprintf("metrics: buckets of %s are not sorted", name))
+	}
+	return &HistogramVec{r.register(name, help, kindHistogram, slices.Clone(buckets), labels)}
+}
+
+// With returns the histogram for the given label values, in label order.
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return &Histogram{v.f.with(values), v.f.buckets}
+}
+
+// Observe records one value.
+func (h *Histogram) Observe(value float64) {
+	i, _ := slices.BinarySearch(h.buckets, value)
+
+	h.s.mu.Lock()
+	defer h.s.mu.Unlock()
+	if i < len(h.s.counts) {
+		h.s.counts[i]++
+	}
+	h.s.count++
+	h.s.sum += value
+}
+
+// WriteTo writes every family in the text exposition format, with series sorted by their
+// label values so output is stable between scrapes.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := slices.Clone(r.families)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range families {
+		f.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	all := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		all = append(all, s)
+	}
+	f.mu.Unlock()
+	slices.SortFunc(all, func(a, b *series) int {
+		return slices.Compare(a.labelValues, b.labelValues)
+	})
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+	for _, s := range all {
+		if f.kind != kindHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, f.labelSet(s, ""), formatFloat(s.load()))
+			continue
+		}
+
+		s.mu.Lock()
+		counts, count, sum := slices.Clone(s.counts), s.count, s.sum
+		s.mu.Unlock()
+		cumulative := uint64(0)
+		for i, bound := range f.buckets {
+			cumulative += counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelSet(s, formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelSet(s, "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, f.labelSet(s, ""), formatFloat(sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, f.labelSet(s, ""), count)
+	}
+}
+
+// labelSet renders {name="value",...} for s, with an le label for histogram buckets.
+func (f *family) labelSet(s *series, le string) string {
+	var pairs []string
+	for i, label := range f.labels {
+		pairs = append(pairs, label+`="`+escapeLabel(s.labelValues[i])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
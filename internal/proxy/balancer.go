@@ -0,0 +1,234 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+)
+
+// Upstream is a single backend in a Pool along with its live health and load state.
+type Upstream struct {
+	URL *url.URL
+
+	healthy      atomic.Bool  // result of the last active health check
+	ejectedUntil atomic.Int64 // unix nanos; passive ejection after consecutive failures
+	active       atomic.Int64 // in-flight proxied requests
+	failures     atomic.Int64 // consecutive failed requests
+	requests     atomic.Int64 // total proxied requests
+
+	mu        sync.Mutex
+	lastCheck time.Time
+	lastError string
+}
+
+// available reports whether the upstream may receive traffic at time now.
+func (u *Upstream) available(now time.Time) bool {
+	return u.healthy.Load() && now.UnixNano() >= u.ejectedUntil.Load()
+}
+
+// Strategy chooses which upstream serves a request.
+// candidates holds only the upstreams that are currently available and is never empty.
+type Strategy interface {
+	Pick(candidates []*Upstream, req *request.Request) *Upstream
+}
+
+// roundRobin cycles through the available upstreams in order.
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+// RoundRobin returns a Strategy that hands requests to each available upstream in turn.
+func RoundRobin() Strategy {
+	return &roundRobin{}
+}
+
+func (rr *roundRobin) Pick(candidates []*Upstream, req *request.Request) *Upstream {
+	n := rr.next.Add(1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+// leastConnections picks the upstream with the fewest in-flight requests.
+type leastConnections struct{}
+
+// LeastConnections returns a Strategy that prefers the upstream with the fewest in-flight requests.
+// Ties go to the upstream listed first.
+func LeastConnections() Strategy {
+	return leastConnections{}
+}
+
+func (leastConnections) Pick(candidates []*Upstream, req *request.Request) *Upstream {
+	best := candidates[0]
+	for _, u := range candidates[1:] {
+		if u.active.Load() < best.active.Load() {
+			best = u
+		}
+	}
+	return best
+}
+
+// ringReplicas is the number of virtual nodes each upstream gets on the hash ring.
+const ringReplicas = 100
+
+// consistentHash maps a request header value onto a hash ring of upstreams.
+type consistentHash struct {
+	header string
+
+	mu    sync.Mutex
+	ring  []uint32
+	nodes map[uint32]*Upstream
+	built []*Upstream
+}
+
+// ConsistentHash returns a Strategy that routes requests with the same value of header to
+// the same upstream, moving only that upstream's share of keys when one becomes unavailable.
+// Requests without the header are keyed by client IP.
+func ConsistentHash(header string) Strategy {
+	return &consistentHash{header: header}
+}
+
+func (ch *consistentHash) Pick(candidates []*Upstream, req *request.Request) *Upstream {
+	key, ok := req.Headers.Get(ch.header)
+	if !ok {
+		key, _, _ = net.SplitHostPort(req.RemoteAddr)
+	}
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if !sameUpstreams(ch.built, candidates) {
+		ch.build(candidates)
+	}
+
+	hash := hashKey(key)
+	i := sort.Search(len(ch.ring), func(i int) bool { return ch.ring[i] >= hash })
+	if i == len(ch.ring) {
+		i = 0
+	}
+	return ch.nodes[ch.ring[i]]
+}
+
+// build rebuilds the ring for the given set of upstreams.
+func (ch *consistentHash) build(candidates []*Upstream) {
+	ch.ring = ch.ring[:0]
+	ch.nodes = make(map[uint32]*Upstream, len(candidates)*ringReplicas)
+	for _, u := range candidates {
+		for i := 0; i < ringReplicas; i++ {
+			hash := hashKey(strconv.Itoa(i) + "#" + u.URL.String())
+			ch.ring = append(ch.ring, hash)
+			ch.nodes[hash] = u
+		}
+	}
+	sort.Slice(ch.ring, func(i, j int) bool { return ch.ring[i] < ch.ring[j] })
+	ch.built = append(ch.built[:0], candidates...)
+}
+
+// sameUpstreams reports whether a and b list the same upstreams in the same order.
+func sameUpstreams(a, b []*Upstream) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// hashKey hashes a ring key with 32-bit FNV-1a.
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// Pool is a set of upstreams that a ReverseProxy balances across.
+// Upstreams are taken out of rotation by failed active health checks or, passively,
+// after MaxFails consecutive failed requests.
+type Pool struct {
+	upstreams []*Upstream
+	strategy  Strategy
+
+	// MaxFails consecutive failures eject an upstream for EjectDuration. Zero disables ejection.
+	MaxFails      int
+	EjectDuration time.Duration
+
+	stopChecks chan struct{}
+	stopOnce   sync.Once
+}
+
+// NewPool creates a Pool over the given upstream base URLs using strategy.
+// All upstreams start healthy; passive ejection defaults to 3 failures for 30 seconds.
+func NewPool(targets []string, strategy Strategy) (*Pool, error) {
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("pool needs at least one upstream")
+	}
+
+	pool := &Pool{
+		strategy:      strategy,
+		MaxFails:      3,
+		EjectDuration: 30 * time.Second,
+		stopChecks:    make(chan struct{}),
+	}
+	for _, target := range targets {
+		targetURL, err := url.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upstream url %q: %v", target, err)
+		}
+		if targetURL.Scheme != "http" && targetURL.Scheme != "https" {
+			return nil, fmt.Errorf("invalid upstream url %q: unsupported scheme", target)
+		}
+
+		upstream := &Upstream{URL: targetURL}
+		upstream.healthy.Store(true)
+		pool.upstreams = append(pool.upstreams, upstream)
+	}
+
+	return pool, nil
+}
+
+// Upstreams returns the pool's upstreams in configuration order.
+func (p *Pool) Upstreams() []*Upstream {
+	return p.upstreams
+}
+
+// pick returns the upstream that should serve req, or nil when none are available.
+func (p *Pool) pick(req *request.Request) *Upstream {
+	now := time.Now()
+	candidates := make([]*Upstream, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		if u.available(now) {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	return p.strategy.Pick(candidates, req)
+}
+
+// report records the outcome of a proxied request for passive ejection.
+func (p *Pool) report(u *Upstream, err error) {
+	if err == nil {
+		u.failures.Store(0)
+		return
+	}
+
+	u.mu.Lock()
+	u.lastError = err.Error()
+	u.mu.Unlock()
+
+	failures := u.failures.Add(1)
+	if p.MaxFails > 0 && failures >= int64(p.MaxFails) {
+		u.ejectedUntil.Store(time.Now().Add(p.EjectDuration).UnixNano())
+		u.failures.Store(0)
+	}
+}
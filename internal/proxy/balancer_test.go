@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/kiefbc/http-server-1.1/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// backend starts a server.Server that answers with its name, and fails /health
+// and every other request while down is set.
+func backend(t *testing.T, name string, down *atomic.Bool) string {
+	t.Helper()
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		if down != nil && down.Load() {
+			return &server.HandlerError{StatusCode: response.StatusServiceUnavailable, Message: "down"}
+		}
+		body := []byte(name)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+		return nil
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return fmt.Sprintf("http://127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+}
+
+// get fetches url with the optional header and returns the body.
+func get(t *testing.T, url, header, value string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestPool(t *testing.T) {
+	targets := []string{backend(t, "a", nil), backend(t, "b", nil), backend(t, "c", nil)}
+
+	// Test: Round robin visits every upstream in turn
+	pool, err := NewPool(targets, RoundRobin())
+	require.NoError(t, err)
+	front := serveProxy(t, NewBalancedProxy(pool).Handle)
+	var seen []string
+	for range 6 {
+		_, body := get(t, front+"/", "", "")
+		seen = append(seen, body)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, seen)
+
+	// Test: Consistent hash keeps a key on one upstream
+	pool, err = NewPool(targets, ConsistentHash("X-User"))
+	require.NoError(t, err)
+	front = serveProxy(t, NewBalancedProxy(pool).Handle)
+	_, first := get(t, front+"/", "X-User", "alice")
+	for range 5 {
+		_, body := get(t, front+"/", "X-User", "alice")
+		assert.Equal(t, first, body)
+	}
+
+	// Test: Least connections prefers the idle upstream
+	pool, err = NewPool(targets, LeastConnections())
+	require.NoError(t, err)
+	pool.Upstreams()[0].active.Store(5)
+	pool.Upstreams()[1].active.Store(1)
+	front = serveProxy(t, NewBalancedProxy(pool).Handle)
+	_, body := get(t, front+"/", "", "")
+	assert.Equal(t, "c", body)
+
+	// Test: Consecutive failures eject an upstream
+	var flaky atomic.Bool
+	flaky.Store(true)
+	pool, err = NewPool([]string{backend(t, "flaky", &flaky), targets[1]}, RoundRobin())
+	require.NoError(t, err)
+	pool.MaxFails = 2
+	front = serveProxy(t, NewBalancedProxy(pool).Handle)
+	for range 4 {
+		get(t, front+"/", "", "")
+	}
+	assert.False(t, pool.Upstreams()[0].available(time.Now()))
+	for range 3 {
+		_, body := get(t, front+"/", "", "")
+		assert.Equal(t, "b", body)
+	}
+
+	// Test: Active health checks take an upstream out and bring it back
+	var down atomic.Bool
+	down.Store(true)
+	pool, err = NewPool([]string{backend(t, "sick", &down), targets[2]}, RoundRobin())
+	require.NoError(t, err)
+	pool.StartHealthChecks(HealthCheck{Path: "/health", Interval: 20 * time.Millisecond})
+	defer pool.Stop()
+	assert.False(t, pool.Upstreams()[0].healthy.Load())
+	down.Store(false)
+	assert.Eventually(t, func() bool { return pool.Upstreams()[0].healthy.Load() }, time.Second, 10*time.Millisecond)
+
+	// Test: A zero interval falls back to the default instead of panicking
+	pool, err = NewPool([]string{targets[0]}, RoundRobin())
+	require.NoError(t, err)
+	assert.NotPanics(t, func() { pool.StartHealthChecks(HealthCheck{Path: "/health"}) })
+	pool.Stop()
+
+	// Test: No available upstreams returns 503
+	pool, err = NewPool([]string{targets[0]}, RoundRobin())
+	require.NoError(t, err)
+	pool.Upstreams()[0].healthy.Store(false)
+	status, _ := get(t, serveProxy(t, NewBalancedProxy(pool).Handle)+"/", "", "")
+	assert.Equal(t, 503, status)
+
+	// Test: Status handler reports upstream state as JSON
+	status, body = get(t, serveProxy(t, pool.StatusHandler)+"/", "", "")
+	assert.Equal(t, 200, status)
+	var report struct {
+		Upstreams []upstreamStatus `json:"upstreams"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &report))
+	require.Len(t, report.Upstreams, 1)
+	assert.Equal(t, targets[0], report.Upstreams[0].URL)
+	assert.False(t, report.Upstreams[0].Available)
+}
+
+func TestConsistentHash(t *testing.T) {
+	pool, err := NewPool([]string{"http://a.internal", "http://b.internal", "http://c.internal"}, nil)
+	require.NoError(t, err)
+	upstreams := pool.Upstreams()
+	ch := ConsistentHash("X-User")
+	pick := func(candidates []*Upstream, key string) *Upstream {
+		return ch.Pick(candidates, servertest.NewRequest(t, "GET", "/", map[string]string{"X-User": key}))
+	}
+
+	// Test: Keys spread evenly enough over the upstreams
+	const keys = 3000
+	before := make(map[string]*Upstream, keys)
+	counts := make(map[*Upstream]int)
+	for i := range keys {
+		key := fmt.Sprintf("user-%d", i)
+		before[key] = pick(upstreams, key)
+		counts[before[key]]++
+	}
+	require.Len(t, counts, 3)
+	for _, u := range upstreams {
+		assert.InDelta(t, keys/3, counts[u], keys/10, u.URL.String())
+	}
+
+	// Test: Removing an upstream moves only its keys
+	remaining := []*Upstream{upstreams[0], upstreams[2]}
+	moved := 0
+	for key, was := range before {
+		now := pick(remaining, key)
+		if was == upstreams[1] {
+			assert.NotEqual(t, upstreams[1], now)
+			moved++
+			continue
+		}
+		assert.Equal(t, was, now, key)
+	}
+	assert.Equal(t, counts[upstreams[1]], moved)
+}
@@ -0,0 +1,239 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+)
+
+// ForwardProxy serves clients that use this server as their HTTP proxy.
+// CONNECT requests (authority-form, "host:port") are tunnelled over a raw TCP connection;
+// absolute-form requests ("http://host/path") are forwarded like a ReverseProxy would.
+type ForwardProxy struct {
+	// Allow lists permitted destinations as "host:port" patterns. The host may be "*" or
+	// start with "*." to match subdomains, and the port may be "*". An empty list denies
+	// every destination.
+	Allow []string
+	// Credentials maps usernames to passwords for Proxy-Authorization basic auth.
+	// When empty no authentication is required.
+	Credentials map[string]string
+	// Realm is sent in the Proxy-Authenticate challenge.
+	Realm string
+	// DialTimeout bounds connecting to a CONNECT destination.
+	DialTimeout time.Duration
+
+	forwarder *ReverseProxy
+}
+
+// NewForwardProxy creates a ForwardProxy that permits the given destination patterns.
+func NewForwardProxy(allow ...string) *ForwardProxy {
+	return &ForwardProxy{
+		Allow:       allow,
+		Realm:       "proxy",
+		DialTimeout: 10 * time.Second,
+		forwarder:   &ReverseProxy{Client: newUpstreamClient()},
+	}
+}
+
+// Handle is a server.Handler for CONNECT and absolute-form proxy requests.
+func (fp *ForwardProxy) Handle(w *response.Writer, req *request.Request) *server.HandlerError {
+	if !fp.authorized(req) {
+		fp.writeAuthRequired(w)
+		return nil
+	}
+
+	if req.RequestLine.Method == "CONNECT" {
+		return fp.tunnel(w, req)
+	}
+
+	targetURL, err := url.Parse(req.RequestLine.RequestTarget)
+	if err != nil || !targetURL.IsAbs() {
+		return &server.HandlerError{
+			StatusCode: response.StatusBadRequest,
+			Message:    "Bad Request: proxy requests must use an absolute-form target",
+		}
+	}
+	if targetURL.Scheme != "http" {
+		return &server.HandlerError{
+			StatusCode: response.StatusBadRequest,
+			Message:    fmt.Sprintf("Bad Request: unsupported scheme %q, use CONNECT for https", targetURL.Scheme),
+		}
+	}
+
+	port := targetURL.Port()
+	if port == "" {
+		port = "80"
+	}
+	if !fp.allowed(targetURL.Hostname(), port) {
+		return &server.HandlerError{
+			StatusCode: response.StatusForbidden,
+			Message:    fmt.Sprintf("Forbidden: destination %s is not allowed", targetURL.Host),
+		}
+	}
+
+	outReq, err := fp.forwarder.outgoingRequest(req, &url.URL{Scheme: targetURL.Scheme, Host: targetURL.Host})
+	if err != nil {
+		return &server.HandlerError{
+			StatusCode: response.StatusBadRequest,
+			Message:    fmt.Sprintf("Bad Request: %v", err),
+		}
+	}
+	// The client's Host header names the origin server, which is exactly what it should see
+	outReq.Host = targetURL.Host
+
+	resp, err := fp.forwarder.Client.Do(outReq)
+	if err != nil {
+		return upstreamError(err)
+	}
+	defer resp.Body.Close()
+
+	copyResponse(w, req, resp)
+	return nil
+}
+
+// tunnel handles CONNECT by dialing the destination and splicing the two connections together.
+func (fp *ForwardProxy) tunnel(w *response.Writer, req *request.Request) *server.HandlerError {
+	host, port, err := net.SplitHostPort(req.RequestLine.RequestTarget)
+	if err != nil || host == "" || port == "" {
+		return &server.HandlerError{
+			StatusCode: response.StatusBadRequest,
+			Message:    "Bad Request: CONNECT target must be host:port",
+		}
+	}
+	if !fp.allowed(host, port) {
+		return &server.HandlerError{
+			StatusCode: response.StatusForbidden,
+			Message:    fmt.Sprintf("Forbidden: destination %s is not allowed", req.RequestLine.RequestTarget),
+		}
+	}
+
+	dialer := net.Dialer{Timeout: fp.DialTimeout}
+	upstream, err := dialer.DialContext(req.Context(), "tcp", req.RequestLine.RequestTarget)
+	if err != nil {
+		return upstreamError(err)
+	}
+	defer upstream.Close()
+
+	// A 2xx reply to CONNECT carries no body and no framing headers (RFC 9110 Section 9.3.6)
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(nil)
+
+	client, err := w.Hijack()
+	if err != nil {
+		return &server.HandlerError{
+			StatusCode: response.StatusInternalServerError,
+			Message:    fmt.Sprintf("Failed to take over connection: %v", err),
+		}
+	}
+	defer client.Close()
+
+	// Tear the tunnel down if the server shuts down or the request deadline passes
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-req.Context().Done():
+			client.Close()
+			upstream.Close()
+		case <-stop:
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go splice(&wg, upstream, client)
+	go splice(&wg, client, upstream)
+	wg.Wait()
+
+	return nil
+}
+
+// splice copies src to dst and then half-closes dst so the other side sees EOF.
+func splice(wg *sync.WaitGroup, dst, src net.Conn) {
+	defer wg.Done()
+
+	io.Copy(dst, src)
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
+
+// allowed reports whether host:port matches an entry in the allow list.
+func (fp *ForwardProxy) allowed(host, port string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range fp.Allow {
+		patternHost, patternPort, err := net.SplitHostPort(pattern)
+		if err != nil {
+			continue
+		}
+		if patternPort != "*" && patternPort != port {
+			continue
+		}
+
+		patternHost = strings.ToLower(patternHost)
+		switch {
+		case patternHost == "*":
+			return true
+		case strings.HasPrefix(patternHost, "*."):
+			if strings.HasSuffix(host, patternHost[1:]) {
+				return true
+			}
+		case patternHost == host:
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorized checks Proxy-Authorization basic credentials when any are configured.
+func (fp *ForwardProxy) authorized(req *request.Request) bool {
+	if len(fp.Credentials) == 0 {
+		return true
+	}
+
+	header, ok := req.Headers.Get("Proxy-Authorization")
+	if !ok {
+		return false
+	}
+	scheme, encoded, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return false
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return false
+	}
+
+	expected, known := fp.Credentials[username]
+	// Compare even for unknown users so timing does not reveal which usernames exist
+	match := subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+	return known && match
+}
+
+// writeAuthRequired sends 407 with a Proxy-Authenticate challenge (RFC 9110 Section 11.7.1).
+func (fp *ForwardProxy) writeAuthRequired(w *response.Writer) {
+	body := []byte("Proxy Authentication Required")
+	responseHeaders := response.GetDefaultHeaders(len(body))
+	responseHeaders.Replace("proxy-authenticate", fmt.Sprintf("Basic realm=%q", fp.Realm))
+
+	w.WriteStatusLine(response.StatusProxyAuthRequired)
+	w.WriteHeaders(responseHeaders)
+	w.WriteBody(body)
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// echoServer accepts TCP connections and echoes everything back.
+func echoServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// connect sends a CONNECT request through the proxy and returns the connection and status line.
+func connect(t *testing.T, proxyURL, target, extraHeaders string) (net.Conn, *bufio.Reader, string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxyURL, "http://"))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n" + extraHeaders + "\r\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	statusLine, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+	return conn, reader, statusLine
+}
+
+func TestForwardProxy(t *testing.T) {
+	echo := echoServer(t)
+	_, echoPort, _ := net.SplitHostPort(echo)
+	fp := NewForwardProxy("127.0.0.1:" + echoPort)
+	base := serveProxy(t, fp.Handle)
+
+	// Test: CONNECT to an allowed destination tunnels bytes both ways
+	conn, reader, status := connect(t, base, echo, "")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+	_, err := conn.Write([]byte("ping through tunnel\n"))
+	require.NoError(t, err)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "ping through tunnel\n", line)
+	conn.Close()
+
+	// Test: CONNECT to a destination outside the allow list is refused
+	conn, _, status = connect(t, base, "127.0.0.1:1", "")
+	assert.Equal(t, "HTTP/1.1 403 Forbidden\r\n", status)
+	conn.Close()
+
+	// Test: CONNECT target without a port is rejected
+	conn, _, status = connect(t, base, "127.0.0.1", "")
+	assert.Equal(t, "HTTP/1.1 400 Bad Request\r\n", status)
+	conn.Close()
+
+	// Test: Missing Proxy-Authorization gets a 407 challenge
+	authed := NewForwardProxy("*:*")
+	authed.Credentials = map[string]string{"kiefer": "zig"}
+	authedBase := serveProxy(t, authed.Handle)
+	conn, _, status = connect(t, authedBase, echo, "")
+	assert.Equal(t, "HTTP/1.1 407 Proxy Authentication Required\r\n", status)
+	conn.Close()
+
+	// Test: Valid Proxy-Authorization opens the tunnel
+	conn, _, status = connect(t, authedBase, echo, "Proxy-Authorization: Basic a2llZmVyOnppZw==\r\n")
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", status)
+	conn.Close()
+
+	// Test: Absolute-form requests are forwarded to the origin
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("origin saw " + r.URL.RequestURI() + " for " + r.Host))
+	}))
+	defer origin.Close()
+	proxyURL, err := url.Parse(serveProxy(t, NewForwardProxy("*:*").Handle))
+	require.NoError(t, err)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get(origin.URL + "/path?q=1")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "origin saw /path?q=1 for "+strings.TrimPrefix(origin.URL, "http://"), string(body))
+
+	// Test: Origin-form requests are not proxy requests
+	resp, err = http.Get(base + "/not-a-proxy-request")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 400, resp.StatusCode)
+}
@@ -0,0 +1,165 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+)
+
+// HealthCheck configures active health checks for a Pool.
+type HealthCheck struct {
+	// Path is requested on every upstream, e.g. "/health". 2xx and 3xx count as healthy.
+	Path string
+	// Interval between rounds of checks; defaults to DefaultHealthCheckInterval.
+	Interval time.Duration
+	// Timeout for a single check; defaults to Interval.
+	Timeout time.Duration
+}
+
+// DefaultHealthCheckInterval is the interval used when HealthCheck.Interval is not positive.
+const DefaultHealthCheckInterval = 10 * time.Second
+
+// StartHealthChecks probes every upstream once immediately and then on each interval
+// until Stop is called.
+func (p *Pool) StartHealthChecks(hc HealthCheck) {
+	if hc.Interval <= 0 {
+		hc.Interval = DefaultHealthCheckInterval
+	}
+	timeout := hc.Timeout
+	if timeout <= 0 {
+		timeout = hc.Interval
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	p.checkAll(client, hc.Path)
+	go func() {
+		ticker := time.NewTicker(hc.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.checkAll(client, hc.Path)
+			case <-p.stopChecks:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends active health checking. It is safe to call more than once.
+func (p *Pool) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopChecks)
+	})
+}
+
+// checkAll probes every upstream concurrently and waits for the round to finish.
+func (p *Pool) checkAll(client *http.Client, path string) {
+	done := make(chan struct{}, len(p.upstreams))
+	for _, u := range p.upstreams {
+		go func() {
+			u.check(client, path)
+			done <- struct{}{}
+		}()
+	}
+	for range p.upstreams {
+		<-done
+	}
+}
+
+// check performs one active health check and records the result.
+func (u *Upstream) check(client *http.Client, path string) {
+	checkURL := *u.URL
+	checkURL.Path = joinPath(u.URL.Path, path)
+	checkURL.RawQuery = ""
+
+	var checkErr error
+	resp, err := client.Get(checkURL.String())
+	if err != nil {
+		checkErr = err
+	} else {
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			checkErr = fmt.Errorf("health check returned %d", resp.StatusCode)
+		}
+	}
+
+	u.healthy.Store(checkErr == nil)
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.lastCheck = time.Now()
+	if checkErr != nil {
+		u.lastError = checkErr.Error()
+	}
+}
+
+// upstreamStatus is the admin view of one upstream.
+type upstreamStatus struct {
+	URL                 string     `json:"url"`
+	Available           bool       `json:"available"`
+	Healthy             bool       `json:"healthy"`
+	EjectedUntil        *time.Time `json:"ejected_until,omitempty"`
+	ActiveRequests      int64      `json:"active_requests"`
+	TotalRequests       int64      `json:"total_requests"`
+	ConsecutiveFailures int64      `json:"consecutive_failures"`
+	LastCheck           *time.Time `json:"last_check,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+}
+
+// StatusHandler is a server.Handler that reports the state of every upstream as JSON.
+// Mount it on an admin-only route.
+func (p *Pool) StatusHandler(w *response.Writer, req *request.Request) *server.HandlerError {
+	now := time.Now()
+	statuses := make([]upstreamStatus, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		status := upstreamStatus{
+			URL:                 u.URL.String(),
+			Available:           u.available(now),
+			Healthy:             u.healthy.Load(),
+			ActiveRequests:      u.active.Load(),
+			TotalRequests:       u.requests.Load(),
+			ConsecutiveFailures: u.failures.Load(),
+		}
+		if ejectedUntil := time.Unix(0, u.ejectedUntil.Load()); ejectedUntil.After(now) {
+			status.EjectedUntil = &ejectedUntil
+		}
+
+		u.mu.Lock()
+		if !u.lastCheck.IsZero() {
+			lastCheck := u.lastCheck
+			status.LastCheck = &lastCheck
+		}
+		status.LastError = u.lastError
+		u.mu.Unlock()
+
+		statuses = append(statuses, status)
+	}
+
+	body, err := json.MarshalIndent(map[string]any{"upstreams": statuses}, "", "  ")
+	if err != nil {
+		return &server.HandlerError{
+			StatusCode: response.StatusInternalServerError,
+			Message:    fmt.Sprintf("Failed to encode upstream status: %v", err),
+		}
+	}
+
+	responseHeaders := response.GetDefaultHeaders(len(body))
+	responseHeaders.Replace("content-type", "application/json")
+
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(responseHeaders)
+	w.WriteBody(body)
+
+	return nil
+}
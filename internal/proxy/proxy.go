@@ -0,0 +1,398 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kiefbc/http-server-1.1/internal/headers"
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/kiefbc/http-server-1.1/internal/tracing"
+)
+
+// copyBufferSize is how much of the upstream body is read per chunk while streaming.
+const copyBufferSize = 32 * 1024
+
+// hopByHopHeaders apply to a single connection and must not be forwarded (RFC 9110 Section 7.6.1).
+var hopByHopHeaders = []string{
+	"connection",
+	"proxy-connection",
+	"keep-alive",
+	"proxy-authenticate",
+	"proxy-authorization",
+	"te",
+	"trailer",
+	"transfer-encoding",
+	"upgrade",
+}
+
+// ReverseProxy forwards requests to an upstream server and streams the response back.
+// Method, headers, body and query are passed through; hop-by-hop headers are stripped,
+// X-Forwarded-* / Forwarded headers describe the original client and trace context is
+// continued from the request's span.
+type ReverseProxy struct {
+	// Target is the upstream base URL. Its path is prepended to the request path.
+	Target *url.URL
+	// StripPrefix is removed from the request path before it is joined to Target.
+	StripPrefix string
+	// PreserveHost forwards the client's Host header instead of the upstream's host.
+	PreserveHost bool
+	// Client performs the upstream requests. NewReverseProxy sets one that does not
+	// follow redirects or transparently decompress, so the client sees what upstream sent.
+	Client *http.Client
+	// Pool, when set, balances requests across several upstreams and Target is ignored.
+	Pool *Pool
+}
+
+// NewReverseProxy creates a ReverseProxy that forwards to the given upstream base URL.
+func NewReverseProxy(target string) (*ReverseProxy, error) {
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream url: %v", err)
+	}
+	if targetURL.Scheme != "http" && targetURL.Scheme != "https" {
+		return nil, fmt.Errorf("invalid upstream url: unsupported scheme %q", targetURL.Scheme)
+	}
+
+	return &ReverseProxy{
+		Target: targetURL,
+		Client: newUpstreamClient(),
+	}, nil
+}
+
+// NewBalancedProxy creates a ReverseProxy that spreads requests across the upstreams in pool.
+func NewBalancedProxy(pool *Pool) *ReverseProxy {
+	return &ReverseProxy{
+		Pool:   pool,
+		Client: newUpstreamClient(),
+	}
+}
+
+// newUpstreamClient returns an http.Client suited to proxying: redirects are handed back
+// to the client and compressed bodies are passed through untouched.
+func newUpstreamClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableCompression = true
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Handle is a server.Handler that proxies req to the upstream and writes the upstream response to w.
+func (p *ReverseProxy) Handle(w *response.Writer, req *request.Request) *server.HandlerError {
+	target := p.Target
+	var upstream *Upstream
+	if p.Pool != nil {
+		upstream = p.Pool.pick(req)
+		if upstream == nil {
+			return &server.HandlerError{
+				StatusCode: response.StatusServiceUnavailable,
+				Message:    "Service Unavailable: no healthy upstreams",
+			}
+		}
+		target = upstream.URL
+
+		upstream.requests.Add(1)
+		upstream.active.Add(1)
+		defer upstream.active.Add(-1)
+	}
+
+	outReq, err := p.outgoingRequest(req, target)
+	if err != nil {
+		return &server.HandlerError{
+			StatusCode: response.StatusBadRequest,
+			Message:    fmt.Sprintf("Bad Request: %v", err),
+		}
+	}
+
+	resp, err := p.Client.Do(outReq)
+	if upstream != nil {
+		switch {
+		case err != nil && req.Context().Err() != nil:
+			// The client went away; that says nothing about the upstream
+		case err != nil:
+			p.Pool.report(upstream, err)
+		case resp.StatusCode >= 500:
+			p.Pool.report(upstream, fmt.Errorf("upstream returned %d", resp.StatusCode))
+		default:
+			p.Pool.report(upstream, nil)
+		}
+	}
+	if err != nil {
+		return upstreamError(err)
+	}
+	defer resp.Body.Close()
+
+	copyResponse(w, req, resp)
+	return nil
+}
+
+// upstreamError maps a failed upstream round trip to 504 for timeouts and 502 for everything else.
+func upstreamError(err error) *server.HandlerError {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &server.HandlerError{
+			StatusCode: response.StatusGatewayTimeout,
+			Message:    fmt.Sprintf("Gateway Timeout: %v", err),
+		}
+	}
+
+	return &server.HandlerError{
+		StatusCode: response.StatusBadGateway,
+		Message:    fmt.Sprintf("Bad Gateway: %v", err),
+	}
+}
+
+// outgoingRequest builds the upstream request for req against target.
+func (p *ReverseProxy) outgoingRequest(req *request.Request, target *url.URL) (*http.Request, error) {
+	inURL, err := url.ParseRequestURI(req.RequestLine.RequestTarget)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request target: %v", err)
+	}
+
+	outURL := *target
+	outURL.Path = joinPath(target.Path, strings.TrimPrefix(inURL.Path, p.StripPrefix))
+	outURL.RawPath = ""
+	switch {
+	case target.RawQuery == "":
+		outURL.RawQuery = inURL.RawQuery
+	case inURL.RawQuery != "":
+		outURL.RawQuery = target.RawQuery + "&" + inURL.RawQuery
+	}
+
+	var body io.Reader = http.NoBody
+	if len(req.Body) > 0 {
+		body = bytes.NewReader(req.Body)
+	}
+
+	outReq, err := http.NewRequestWithContext(req.Context(), req.RequestLine.Method, outURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	outReq.ContentLength = int64(len(req.Body))
+
+	inHeaders := copyHeaders(req.Headers)
+	removeHopByHop(inHeaders)
+	for key, value := range inHeaders {
+		if key == "host" || key == "content-length" {
+			continue
+		}
+		outReq.Header.Set(key, value)
+	}
+
+	// Upstream trailers are only relayed if we ask for them
+	outReq.Header.Set("Te", "trailers")
+
+	// Upstream's parent is this server's span rather than our caller's (W3C Trace Context Section 3)
+	if span, ok := tracing.FromRequest(req); ok {
+		outReq.Header.Set("Traceparent", span.Context.Traceparent())
+		if span.Context.TraceState != "" {
+			outReq.Header.Set("Tracestate", span.Context.TraceState)
+		} else {
+			outReq.Header.Del("Tracestate")
+		}
+	}
+
+	host, _ := req.Headers.Get("Host")
+	if p.PreserveHost && host != "" {
+		outReq.Host = host
+	}
+	addForwardedHeaders(outReq, req, host)
+
+	return outReq, nil
+}
+
+// addForwardedHeaders appends this hop to X-Forwarded-For and Forwarded and records
+// the original host and scheme (RFC 7239).
+func addForwardedHeaders(outReq *http.Request, req *request.Request, host string) {
+	clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		clientIP = req.RemoteAddr
+	}
+
+	if clientIP != "" {
+		if prior := outReq.Header.Get("X-Forwarded-For"); prior != "" {
+			outReq.Header.Set("X-Forwarded-For", prior+", "+clientIP)
+		} else {
+			outReq.Header.Set("X-Forwarded-For", clientIP)
+		}
+	}
+	if host != "" && outReq.Header.Get("X-Forwarded-Host") == "" {
+		outReq.Header.Set("X-Forwarded-Host", host)
+	}
+	if outReq.Header.Get("X-Forwarded-Proto") == "" {
+		outReq.Header.Set("X-Forwarded-Proto", "http")
+	}
+
+	var element []string
+	if clientIP != "" {
+		element = append(element, "for="+forwardedNode(clientIP))
+	}
+	if host != "" {
+		element = append(element, "host="+quoteIfNeeded(host))
+	}
+	element = append(element, "proto=http")
+
+	forwarded := strings.Join(element, ";")
+	if prior := outReq.Header.Get("Forwarded"); prior != "" {
+		forwarded = prior + ", " + forwarded
+	}
+	outReq.Header.Set("Forwarded", forwarded)
+}
+
+// forwardedNode formats an IP for the Forwarded "for" parameter; IPv6 must be bracketed and quoted.
+func forwardedNode(ip string) string {
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+	return ip
+}
+
+// quoteIfNeeded quotes a Forwarded parameter value that is not a plain token (e.g. host:port).
+func quoteIfNeeded(value string) string {
+	if strings.ContainsAny(value, ":[]\" ") {
+		return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+	}
+	return value
+}
+
+// copyResponse streams the upstream response to w. Responses of known length are sent
+// as-is; anything else, or anything with trailers, is re-framed with chunked encoding.
+// If the upstream fails mid-body the response is aborted so it is never completed.
+func copyResponse(w *response.Writer, req *request.Request, resp *http.Response) {
+	responseHeaders := headers.NewHeaders()
+	for key, values := range resp.Header {
+		// Set-Cookie lines must stay separate; commas inside Expires make joining them ambiguous
+		if key == "Set-Cookie" {
+			continue
+		}
+		for _, value := range values {
+			responseHeaders.Set(key, value)
+		}
+	}
+	// Relayed verbatim: re-serialising would rewrite attributes the origin chose
+	for _, line := range resp.Header.Values("Set-Cookie") {
+		w.AddHeaderLine("Set-Cookie", line)
+	}
+	removeHopByHop(responseHeaders)
+	responseHeaders.Replace("connection", "close")
+
+	noBody := req.RequestLine.Method == http.MethodHead ||
+		resp.StatusCode == http.StatusNoContent ||
+		resp.StatusCode == http.StatusNotModified ||
+		(resp.StatusCode >= 100 && resp.StatusCode < 200)
+
+	chunked := !noBody && (resp.ContentLength < 0 || len(resp.Trailer) > 0)
+	if chunked {
+		delete(responseHeaders, "content-length")
+		responseHeaders.Replace("transfer-encoding", "chunked")
+		if len(resp.Trailer) > 0 {
+			names := make([]string, 0, len(resp.Trailer))
+			for name := range resp.Trailer {
+				names = append(names, name)
+			}
+			responseHeaders.Replace("trailer", strings.Join(names, ", "))
+		}
+	}
+
+	w.WriteStatusLine(response.StatusCode(resp.StatusCode))
+	w.WriteHeaders(responseHeaders)
+	if noBody {
+		return
+	}
+
+	buffer := make([]byte, copyBufferSize)
+	for {
+		n, readErr := resp.Body.Read(buffer)
+		if n > 0 {
+			var writeErr error
+			if chunked {
+				_, writeErr = w.WriteChunkedBody(buffer[:n])
+			} else {
+				_, writeErr = w.WriteBody(buffer[:n])
+			}
+			if writeErr != nil {
+				// The client is gone; returning closes the upstream body
+				return
+			}
+		}
+		if readErr != nil {
+			if !errors.Is(readErr, io.EOF) {
+				// Upstream failed mid-body; cutting the stream short is the only honest signal left
+				w.Abort()
+				return
+			}
+			break
+		}
+	}
+
+	if !chunked {
+		return
+	}
+
+	w.WriteChunkedBodyDone()
+	// resp.Trailer is only populated once the body has been read to EOF
+	if len(resp.Trailer) > 0 {
+		trailerHeaders := headers.NewHeaders()
+		for key, values := range resp.Trailer {
+			for _, value := range values {
+				trailerHeaders.Set(key, value)
+			}
+		}
+		w.WriteTrailers(trailerHeaders)
+	}
+	w.WriteTrailersDone()
+}
+
+// copyHeaders returns a shallow copy of h so it can be edited without touching the request.
+func copyHeaders(h headers.Headers) headers.Headers {
+	copied := headers.NewHeaders()
+	for key, value := range h {
+		copied[key] = value
+	}
+	return copied
+}
+
+// removeHopByHop deletes the standard hop-by-hop headers plus any listed in Connection.
+func removeHopByHop(h headers.Headers) {
+	if connection, ok := h.Get("Connection"); ok {
+		for _, name := range strings.Split(connection, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				delete(h, strings.ToLower(name))
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		delete(h, name)
+	}
+}
+
+// joinPath joins the upstream base path and the request path with exactly one slash between them.
+func joinPath(base, path string) string {
+	switch {
+	case base == "":
+		if path == "" {
+			return "/"
+		}
+		if !strings.HasPrefix(path, "/") {
+			return "/" + path
+		}
+		return path
+	case path == "":
+		return base
+	}
+
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+}
@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kiefbc/http-server-1.1/internal/requestid"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/kiefbc/http-server-1.1/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveProxy starts a server.Server in front of the proxy and returns its base URL.
+func serveProxy(t *testing.T, handler server.Handler) string {
+	t.Helper()
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return fmt.Sprintf("http://127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port)
+}
+
+func TestReverseProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Seen-Method", r.Method)
+		w.Header().Set("X-Seen-Path", r.URL.RequestURI())
+		w.Header().Set("X-Seen-Custom", r.Header.Get("X-Custom"))
+		w.Header().Set("X-Seen-Connection-Token", r.Header.Get("X-Hop"))
+		w.Header().Set("X-Seen-Forwarded-For", r.Header.Get("X-Forwarded-For"))
+		w.Header().Set("X-Seen-Forwarded", r.Header.Get("Forwarded"))
+		w.Header().Set("X-Seen-Traceparent", r.Header.Get("Traceparent"))
+		w.Header().Set("X-Seen-Tracestate", r.Header.Get("Tracestate"))
+		w.Header().Set("X-Seen-Request-ID", r.Header.Get("X-Request-ID"))
+		if r.URL.Path == "/base/cookies" {
+			w.Header().Add("Set-Cookie", "a=1; Path=/; Expires=Wed, 02 Jan 2030 03:04:05 GMT")
+			w.Header().Add("Set-Cookie", "b=2; HttpOnly")
+			w.Header().Add("Set-Cookie", "c=3; secure; max-age=60; SameSite=Weird")
+			return
+		}
+		if r.URL.Path == "/base/broken" {
+			w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		if r.URL.Path == "/base/trailers" {
+			w.Header().Set("Trailer", "X-Checksum")
+			w.Write([]byte("streamed"))
+			w.Header().Set("X-Checksum", "abc123")
+			return
+		}
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	rp, err := NewReverseProxy(upstream.URL + "/base")
+	require.NoError(t, err)
+	rp.StripPrefix = "/api"
+	base := serveProxy(t, rp.Handle)
+
+	// Test: Method, path, query, headers and body are forwarded
+	req, err := http.NewRequest(http.MethodPost, base+"/api/echo?x=1", strings.NewReader("hello upstream"))
+	require.NoError(t, err)
+	req.Header.Set("X-Custom", "kept")
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "dropped")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "hello upstream", string(body))
+	assert.Equal(t, "POST", resp.Header.Get("X-Seen-Method"))
+	assert.Equal(t, "/base/echo?x=1", resp.Header.Get("X-Seen-Path"))
+	assert.Equal(t, "kept", resp.Header.Get("X-Seen-Custom"))
+	assert.Equal(t, "", resp.Header.Get("X-Seen-Connection-Token"))
+	assert.Equal(t, "127.0.0.1", resp.Header.Get("X-Seen-Forwarded-For"))
+	assert.Contains(t, resp.Header.Get("X-Seen-Forwarded"), "for=127.0.0.1")
+	assert.Contains(t, resp.Header.Get("X-Seen-Forwarded"), "proto=http")
+
+	// Test: Trace context continues from the proxy's span
+	traced := serveProxy(t, tracing.New(nil).Middleware(rp.Handle))
+	req, err = http.NewRequest(http.MethodGet, traced+"/api/echo", nil)
+	require.NoError(t, err)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("Tracestate", "congo=t61rcWkgMzE")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	traceparent := resp.Header.Get("X-Seen-Traceparent")
+	assert.Regexp(t, `^00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-01$`, traceparent)
+	assert.NotContains(t, traceparent, "00f067aa0ba902b7")
+	assert.Equal(t, "congo=t61rcWkgMzE", resp.Header.Get("X-Seen-Tracestate"))
+
+	// Test: The request ID is forwarded, generated when the client sent none
+	identified := serveProxy(t, requestid.Middleware(rp.Handle))
+	resp, err = http.Get(identified + "/api/echo")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Regexp(t, `^[0-9a-f]{32}$`, resp.Header.Get("X-Seen-Request-ID"))
+	assert.Equal(t, resp.Header.Get("X-Seen-Request-ID"), resp.Header.Get("X-Request-ID"))
+
+	// Test: Upstream trailers are propagated
+	resp, err = http.Get(base + "/api/trailers")
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "streamed", string(body))
+	assert.Equal(t, "abc123", resp.Trailer.Get("X-Checksum"))
+
+	// Test: An upstream that fails mid-body leaves the client with a truncated chunked body
+	resp, err = http.Get(base + "/api/broken")
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Equal(t, "partial", string(body))
+
+	// Test: Each upstream Set-Cookie stays on its own line, byte for byte
+	resp, err = http.Get(base + "/api/cookies")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, []string{"a=1; Path=/; Expires=Wed, 02 Jan 2030 03:04:05 GMT", "b=2; HttpOnly", "c=3; secure; max-age=60; SameSite=Weird"}, resp.Header.Values("Set-Cookie"))
+
+	// Test: Unreachable upstream returns 502
+	dead, err := NewReverseProxy("http://127.0.0.1:1")
+	require.NoError(t, err)
+	resp, err = http.Get(serveProxy(t, dead.Handle) + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 502, resp.StatusCode)
+
+	// Test: Unsupported upstream scheme is rejected
+	_, err = NewReverseProxy("ftp://example.com")
+	require.Error(t, err)
+}
@@ -0,0 +1,284 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Command says whether a header carries a proxied client (PROXY) or comes from the proxy
+// itself, e.g. a health check (LOCAL).
+type Command byte
+
+const (
+	Local Command = 0x0
+	Proxy Command = 0x1
+)
+
+// TLV types defined by the PROXY protocol specification, section 2.2.
+const (
+	TypeALPN      byte = 0x01
+	TypeAuthority byte = 0x02
+	TypeCRC32C    byte = 0x03
+	TypeNoop      byte = 0x04
+	TypeUniqueID  byte = 0x05
+	TypeSSL       byte = 0x20
+	TypeNetNS     byte = 0x30
+)
+
+// TLV is a type-length-value field from a version 2 header.
+type TLV struct {
+	Type  byte
+	Value []byte
+}
+
+// Header is a decoded PROXY protocol header.
+type Header struct {
+	Version int
+	Command Command
+	// Source and Destination are the addresses of the original connection. They are nil for
+	// LOCAL headers and for v1 "UNKNOWN" or v2 unspecified-family headers.
+	Source      net.Addr
+	Destination net.Addr
+	TLVs        []TLV
+}
+
+// TLV returns the value of the first TLV of type t.
+func (h *Header) TLV(t byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+var (
+	// ErrNoHeader means the connection did not start with a PROXY protocol header.
+	ErrNoHeader = errors.New("proxyproto: no PROXY protocol header")
+	// ErrInvalidHeader means the header was present but malformed.
+	ErrInvalidHeader = errors.New("proxyproto: invalid PROXY protocol header")
+)
+
+var (
+	v1Prefix    = []byte("PROXY ")
+	v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// v1MaxLength is the longest possible v1 header, CRLF included (section 2.1).
+const v1MaxLength = 107
+
+// ReadHeader reads a v1 or v2 header from r. It returns ErrNoHeader, having consumed
+// nothing, if r does not start with one.
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	start, err := r.Peek(len(v1Prefix))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(start, v1Prefix) {
+		return readV1(r)
+	}
+	if start[0] != v2Signature[0] {
+		return nil, ErrNoHeader
+	}
+	start, err = r.Peek(len(v2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(start, v2Signature) {
+		return nil, ErrNoHeader
+	}
+	return readV2(r)
+}
+
+// readV1 parses the text format: "PROXY TCP4 src dst sport dport\r\n" (section 2.1).
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: v1 header is not terminated by CRLF", ErrInvalidHeader)
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	h := &Header{Version: 1, Command: Proxy}
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		// The proxy could not tell; the rest of the line is to be ignored
+		return h, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: malformed v1 header", ErrInvalidHeader)
+	}
+
+	src, err := v1Addr(fields[2], fields[4], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	dst, err := v1Addr(fields[3], fields[5], fields[1] == "TCP4")
+	if err != nil {
+		return nil, err
+	}
+	h.Source, h.Destination = src, dst
+	return h, nil
+}
+
+func v1Addr(ip, port string, v4 bool) (*net.TCPAddr, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || (parsed.To4() != nil) != v4 {
+		return nil, fmt.Errorf("%w: bad address %q", ErrInvalidHeader, ip)
+	}
+	// Ports are decimal without leading zeros
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("%w: bad port %q", ErrInvalidHeader, port)
+	}
+	return &net.TCPAddr{IP: parsed, Port: int(p)}, nil
+}
+
+// readV2 parses the binary format (section 2.2).
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidHeader, fixed[12]>>4)
+	}
+	h := &Header{Version: 2, Command: Command(fixed[12] & 0x0f)}
+	if h.Command != Local && h.Command != Proxy {
+		return nil, fmt.Errorf("%w: unknown command %d", ErrInvalidHeader, h.Command)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(fixed[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	family, transport := fixed[13]>>4, fixed[13]&0x0f
+	var addrLen int
+	switch family {
+	case 0x1:
+		addrLen = 12
+	case 0x2:
+		addrLen = 36
+	case 0x3:
+		addrLen = 216
+	case 0x0:
+	default:
+		// A LOCAL header's address block is ignored whatever its family
+		if h.Command == Proxy {
+			return nil, fmt.Errorf("%w: unknown address family %d", ErrInvalidHeader, family)
+		}
+	}
+	if len(payload) < addrLen {
+		return nil, fmt.Errorf("%w: address block truncated", ErrInvalidHeader)
+	}
+	// LOCAL headers and unspecified families carry no usable addresses; the receiver keeps
+	// the real connection endpoints
+	if h.Command == Proxy {
+		h.Source, h.Destination = v2Addrs(family, transport, payload[:addrLen])
+	}
+
+	tlvs, err := parseTLVs(payload[addrLen:])
+	if err != nil {
+		return nil, err
+	}
+	h.TLVs = tlvs
+
+	if _, ok := h.TLV(TypeCRC32C); ok {
+		if err := verifyCRC(fixed, payload, addrLen); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// v2Addrs decodes the address block of the inet, inet6 and unix families.
+func v2Addrs(family, transport byte, block []byte) (net.Addr, net.Addr) {
+	switch family {
+	case 0x1, 0x2:
+		size := 4
+		if family == 0x2 {
+			size = 16
+		}
+		srcIP := net.IP(bytes.Clone(block[:size]))
+		dstIP := net.IP(bytes.Clone(block[size : 2*size]))
+		srcPort := int(binary.BigEndian.Uint16(block[2*size:]))
+		dstPort := int(binary.BigEndian.Uint16(block[2*size+2:]))
+		if transport == 0x2 {
+			return &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}
+		}
+		return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}
+	case 0x3:
+		network := "unix"
+		if transport == 0x2 {
+			network = "unixgram"
+		}
+		name := func(b []byte) string {
+			if i := bytes.IndexByte(b, 0); i >= 0 {
+				b = b[:i]
+			}
+			return string(b)
+		}
+		return &net.UnixAddr{Name: name(block[:108]), Net: network}, &net.UnixAddr{Name: name(block[108:]), Net: network}
+	}
+	return nil, nil
+}
+
+func parseTLVs(b []byte) ([]TLV, error) {
+	var tlvs []TLV
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, fmt.Errorf("%w: truncated TLV", ErrInvalidHeader)
+		}
+		length := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+length {
+			return nil, fmt.Errorf("%w: truncated TLV", ErrInvalidHeader)
+		}
+		tlvs = append(tlvs, TLV{Type: b[0], Value: bytes.Clone(b[3 : 3+length])})
+		b = b[3+length:]
+	}
+	return tlvs, nil
+}
+
+// verifyCRC checks the CRC32c TLV, computed over the whole header with the checksum
+// field zeroed (section 2.2.5). The TLVs start at offset in payload and are known to be well formed.
+func verifyCRC(fixed, payload []byte, offset int) error {
+	zeroed := bytes.Clone(payload)
+	var want []byte
+	for i := offset; i < len(zeroed); {
+		length := int(binary.BigEndian.Uint16(zeroed[i+1 : i+3]))
+		if zeroed[i] == TypeCRC32C {
+			if length != 4 {
+				return fmt.Errorf("%w: CRC32C TLV has length %d", ErrInvalidHeader, length)
+			}
+			want = bytes.Clone(zeroed[i+3 : i+7])
+			clear(zeroed[i+3 : i+7])
+			break
+		}
+		i += 3 + length
+	}
+
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	crc.Write(fixed)
+	crc.Write(zeroed)
+	if crc.Sum32() != binary.BigEndian.Uint32(want) {
+		return fmt.Errorf("%w: CRC32C mismatch", ErrInvalidHeader)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package proxyproto
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+)
+
+// DefaultHeaderTimeout bounds how long a trusted peer may take to send its header.
+const DefaultHeaderTimeout = 5 * time.Second
+
+// Listener decodes PROXY protocol headers on connections from trusted load balancers.
+// Connections from anyone else are passed through untouched, so a client cannot spoof its
+// address by sending a header itself; its header then fails to parse as an HTTP request.
+type Listener struct {
+	net.Listener
+	trusted []netip.Prefix
+
+	// Required rejects trusted connections that arrive without a header. When false they are
+	// served with their real addresses, which suits load balancer health checks.
+	Required bool
+	// HeaderTimeout bounds how long reading the header may take.
+	HeaderTimeout time.Duration
+}
+
+// NewListener wraps inner to accept PROXY protocol headers from peers in trusted.
+// Use it with server.WithListener and server.WithConnContext(ConnContext).
+func NewListener(inner net.Listener, trusted []netip.Prefix) *Listener {
+	return &Listener{Listener: inner, trusted: trusted, HeaderTimeout: DefaultHeaderTimeout}
+}
+
+// Accept returns the next connection. The header is read on first use of the connection
+// rather than here, so a slow peer cannot hold up the accept loop.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn), required: l.Required, timeout: l.HeaderTimeout}, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	addrPort, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := addrPort.Addr().Unmap()
+	for _, prefix := range l.trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection from a trusted peer. Its RemoteAddr and LocalAddr report the original
+// client and destination from the header.
+type Conn struct {
+	net.Conn
+	reader   *bufio.Reader
+	required bool
+	timeout  time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+
+	mu       sync.Mutex
+	deadline time.Time // read deadline set by the conn's user, restored after the header
+}
+
+// SetReadDeadline sets the read deadline and remembers it, so reading the header does not
+// lift a deadline the server set, such as its header read timeout.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetDeadline sets the read and write deadlines, remembering the read one as SetReadDeadline does.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+// readHeader reads the header once, before the first byte of the stream is used. The
+// header timeout applies unless the user's read deadline is sooner, and the user's deadline
+// is back in force afterwards.
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.mu.Lock()
+			deadline := c.deadline
+			c.mu.Unlock()
+			headerDeadline := time.Now().Add(c.timeout)
+			if !deadline.IsZero() && deadline.Before(headerDeadline) {
+				headerDeadline = deadline
+			}
+			c.Conn.SetReadDeadline(headerDeadline)
+			defer func() {
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				c.Conn.SetReadDeadline(c.deadline)
+			}()
+		}
+		c.header, c.err = ReadHeader(c.reader)
+		if errors.Is(c.err, ErrNoHeader) && !c.required {
+			c.err = nil
+		}
+	})
+}
+
+// Header returns the decoded header, or nil if the peer sent none.
+func (c *Conn) Header() (*Header, error) {
+	c.readHeader()
+	return c.header, c.err
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(p)
+}
+
+// RemoteAddr returns the original client address, or the peer's if the header has none.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.header != nil && c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client originally connected to, or the local one if the
+// header has none.
+func (c *Conn) LocalAddr() net.Addr {
+	c.readHeader()
+	if c.header != nil && c.header.Destination != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// CloseWrite half-closes the underlying connection when it supports it.
+func (c *Conn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return c.Conn.Close()
+}
+
+type contextKey struct{}
+
+// ConnContext stores the connection's header in ctx for FromRequest. Pass it to
+// server.WithConnContext.
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	c, ok := conn.(*Conn)
+	if !ok {
+		return ctx
+	}
+	if h, err := c.Header(); err == nil && h != nil {
+		return context.WithValue(ctx, contextKey{}, h)
+	}
+	return ctx
+}
+
+// FromRequest returns the PROXY protocol header of the connection req arrived on, with its
+// TLVs. The addresses are already reflected in req.RemoteAddr and req.LocalAddr.
+func FromRequest(req *request.Request) (*Header, bool) {
+	h, ok := req.Context().Value(contextKey{}).(*Header)
+	return h, ok
+}
@@ -0,0 +1,193 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// v2Header builds a PROXY command header for a TCP over IPv4 connection with the given TLVs.
+// withCRC appends a correct CRC32C TLV.
+func v2Header(tlvs []TLV, withCRC bool) []byte {
+	payload := []byte{192, 0, 2, 1, 198, 51, 100, 2, 0x30, 0x39, 0x01, 0xbb} // 192.0.2.1:12345 -> 198.51.100.2:443
+	for _, tlv := range tlvs {
+		payload = append(payload, tlv.Type, 0, 0)
+		binary.BigEndian.PutUint16(payload[len(payload)-2:], uint16(len(tlv.Value)))
+		payload = append(payload, tlv.Value...)
+	}
+	if withCRC {
+		payload = append(payload, TypeCRC32C, 0, 4, 0, 0, 0, 0)
+	}
+
+	header := append([]byte{}, v2Signature...)
+	header = append(header, 0x21, 0x11, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(payload)))
+	header = append(header, payload...)
+	if withCRC {
+		binary.BigEndian.PutUint32(header[len(header)-4:], crc32.Checksum(header, crc32.MakeTable(crc32.Castagnoli)))
+	}
+	return header
+}
+
+func TestReadHeader(t *testing.T) {
+	read := func(raw string) (*Header, string, error) {
+		r := bufio.NewReader(strings.NewReader(raw))
+		h, err := ReadHeader(r)
+		rest, _ := io.ReadAll(r)
+		return h, string(rest), err
+	}
+
+	// Test: v1 TCP4 and TCP6 headers are decoded and the stream continues after them
+	h, rest, err := read("PROXY TCP4 192.0.2.1 198.51.100.2 12345 443\r\nGET / HTTP/1.1\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, 1, h.Version)
+	assert.Equal(t, "192.0.2.1:12345", h.Source.String())
+	assert.Equal(t, "198.51.100.2:443", h.Destination.String())
+	assert.Equal(t, "GET / HTTP/1.1\r\n", rest)
+	h, _, err = read("PROXY TCP6 2001:db8::1 2001:db8::2 1 2\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:1", h.Source.String())
+
+	// Test: v1 UNKNOWN keeps no addresses
+	h, _, err = read("PROXY UNKNOWN whatever\r\n")
+	require.NoError(t, err)
+	assert.Nil(t, h.Source)
+
+	// Test: Malformed v1 headers are rejected
+	for _, raw := range []string{
+		"PROXY TCP4 192.0.2.1 198.51.100.2 12345\r\n",
+		"PROXY TCP4 2001:db8::1 198.51.100.2 1 2\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.2 012 443\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.2 12345 443\n",
+		"PROXY " + strings.Repeat("x", 200) + "\r\n",
+	} {
+		_, _, err = read(raw)
+		assert.ErrorIs(t, err, ErrInvalidHeader, raw)
+	}
+
+	// Test: v2 headers carry addresses and TLVs, and a CRC32C is verified
+	raw := v2Header([]TLV{{TypeAuthority, []byte("example.com")}, {TypeUniqueID, []byte{1, 2, 3}}}, true)
+	h, rest, err = read(string(raw) + "GET")
+	require.NoError(t, err)
+	assert.Equal(t, 2, h.Version)
+	assert.Equal(t, Proxy, h.Command)
+	assert.Equal(t, "192.0.2.1:12345", h.Source.String())
+	assert.Equal(t, "198.51.100.2:443", h.Destination.String())
+	authority, ok := h.TLV(TypeAuthority)
+	require.True(t, ok)
+	assert.Equal(t, "example.com", string(authority))
+	assert.Equal(t, "GET", rest)
+	raw[len(raw)-1] ^= 0xff
+	_, _, err = read(string(raw))
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+
+	// Test: v2 LOCAL headers carry no addresses
+	local := v2Header(nil, false)
+	local[12] = 0x20
+	h, _, err = read(string(local))
+	require.NoError(t, err)
+	assert.Equal(t, Local, h.Command)
+	assert.Nil(t, h.Source)
+
+	// Test: v2 PROXY headers with an unknown address family are rejected
+	unknown := v2Header(nil, false)[:16]
+	unknown[13] = 0x41
+	unknown[14], unknown[15] = 0, 0
+	_, _, err = read(string(unknown))
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+
+	// Test: Plain HTTP is not a header
+	_, rest, err = read("GET / HTTP/1.1\r\n")
+	assert.ErrorIs(t, err, ErrNoHeader)
+	assert.Equal(t, "GET / HTTP/1.1\r\n", rest)
+}
+
+// roundTrip sends prefix and a request to s and returns the response body.
+func roundTrip(t *testing.T, s *server.Server, prefix []byte) string {
+	t.Helper()
+	return send(t, s, append(prefix, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"...))
+}
+
+// send writes raw to s and returns the response body.
+func send(t *testing.T, s *server.Server, raw []byte) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write(raw)
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	_, body, _ := bytes.Cut(resp, []byte("\r\n\r\n"))
+	return string(body)
+}
+
+func TestListener(t *testing.T) {
+	echo := func(w *response.Writer, req *request.Request) *server.HandlerError {
+		body := req.RemoteAddr + " " + req.LocalAddr
+		if h, ok := FromRequest(req); ok {
+			if authority, ok := h.TLV(TypeAuthority); ok {
+				body += " " + string(authority)
+			}
+		}
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody([]byte(body))
+		return nil
+	}
+	loopback := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+	serve := func(trusted []netip.Prefix, required bool, opts ...server.Option) *server.Server {
+		opts = append(opts,
+			server.WithListener(func(l net.Listener) net.Listener {
+				pl := NewListener(l, trusted)
+				pl.Required = required
+				return pl
+			}),
+			server.WithConnContext(ConnContext))
+		s, err := server.Serve(0, echo, opts...)
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+
+	// Test: Trusted peers' headers set the request's addresses and expose TLVs
+	s := serve(loopback, false)
+	assert.Equal(t, "192.0.2.1:12345 198.51.100.2:443", roundTrip(t, s, []byte("PROXY TCP4 192.0.2.1 198.51.100.2 12345 443\r\n")))
+	assert.Equal(t, "192.0.2.1:12345 198.51.100.2:443 example.com", roundTrip(t, s, v2Header([]TLV{{TypeAuthority, []byte("example.com")}}, false)))
+
+	// Test: Without a header a trusted peer is served with its real address unless one is required
+	assert.NotContains(t, roundTrip(t, s, nil), "192.0.2.1")
+	assert.Contains(t, roundTrip(t, serve(loopback, true), nil), "Bad Request")
+
+	// Test: The server's header read timeout still applies after a PROXY header
+	s = serve(loopback, false, server.WithHeaderReadTimeout(100*time.Millisecond))
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	begin := time.Now()
+	_, err = conn.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.2 12345 443\r\nGET / HTTP/1.1\r\nHost: loc"))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(reply), "HTTP/1.1 408 Request Timeout\r\n"), string(reply))
+	assert.Less(t, time.Since(begin), time.Second)
+
+	// Test: Untrusted peers cannot send a header
+	s = serve(nil, false)
+	assert.Contains(t, send(t, s, []byte("PROXY TCP4 192.0.2.1 198.51.100.2 12345 443\r\n")), "Bad Request")
+}
@@ -0,0 +1,244 @@
+package ratelimit
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Decision is a Limiter's verdict on one request.
+type Decision struct {
+	Allowed bool
+	// Limit is the number of requests the key may make in a burst or window.
+	Limit int
+	// Remaining is how many more requests would be allowed right now.
+	Remaining int
+	// Reset is how long until the key is back to its full quota.
+	Reset time.Duration
+	// RetryAfter is how long a refused client should wait before the next request can succeed.
+	RetryAfter time.Duration
+}
+
+// Limiter tracks request rates per key. Implementations must be safe for concurrent use.
+type Limiter interface {
+	Allow(key string, now time.Time) Decision
+}
+
+// DefaultMaxKeys bounds how many keys a limiter tracks when no cap is given.
+const DefaultMaxKeys = 100_000
+
+// table holds per-key state, most recently used first. Keys idle for longer than idleAfter
+// are back at their full quota, so dropping them loses nothing; past maxKeys the least
+// recently used key is dropped even if it is not idle yet.
+type table[S any] struct {
+	maxKeys   int
+	idleAfter time.Duration
+
+	order *list.List // front is most recently used
+	items map[string]*list.Element
+}
+
+type tableEntry[S any] struct {
+	key      string
+	lastSeen time.Time
+	state    S
+}
+
+func newTable[S any](maxKeys int, idleAfter time.Duration) *table[S] {
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxKeys
+	}
+	return &table[S]{
+		maxKeys:   maxKeys,
+		idleAfter: idleAfter,
+		order:     list.New(),
+		items:     make(map[string]*list.Element),
+	}
+}
+
+// get returns the state for key, creating it with fresh if the key is new or was evicted.
+func (t *table[S]) get(key string, now time.Time, fresh func() S) *S {
+	t.evict(now)
+	if el, ok := t.items[key]; ok {
+		t.order.MoveToFront(el)
+		entry := el.Value.(*tableEntry[S])
+		entry.lastSeen = now
+		return &entry.state
+	}
+
+	entry := &tableEntry[S]{key: key, lastSeen: now, state: fresh()}
+	t.items[key] = t.order.PushFront(entry)
+	if t.order.Len() > t.maxKeys {
+		t.remove(t.order.Back())
+	}
+	return &entry.state
+}
+
+// evict drops idle keys from the back of the list, where the least recently used ones are.
+func (t *table[S]) evict(now time.Time) {
+	for el := t.order.Back(); el != nil; el = t.order.Back() {
+		if now.Sub(el.Value.(*tableEntry[S]).lastSeen) < t.idleAfter {
+			return
+		}
+		t.remove(el)
+	}
+}
+
+func (t *table[S]) remove(el *list.Element) {
+	t.order.Remove(el)
+	delete(t.items, el.Value.(*tableEntry[S]).key)
+}
+
+// checkQuota panics on a limit or period that would divide by zero, or refuse every request.
+func checkQuota(limit int, period time.Duration) {
+	if limit <= 0 {
+		panic(fmt.Sprintf("ratelimit: limit must be positive, got %d", limit))
+	}
+	if period <= 0 {
+		panic(fmt.Sprintf("ratelimit: period must be positive, got %v", period))
+	}
+}
+
+// TokenBucket lets each key make burst requests at once and then one more every 1/rate
+// seconds as its bucket refills.
+type TokenBucket struct {
+	rate  float64 // tokens per second
+	burst int
+
+	mu     sync.Mutex
+	bucket *table[bucketState]
+}
+
+type bucketState struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket allows limit requests per period, in bursts of up to limit. maxKeys caps how
+// many keys are tracked; 0 means DefaultMaxKeys. It panics unless limit and period are positive.
+func NewTokenBucket(limit int, period time.Duration, maxKeys int) *TokenBucket {
+	checkQuota(limit, period)
+	rate := float64(limit) / period.Seconds()
+	return &TokenBucket{
+		rate:  rate,
+		burst: limit,
+		// An untouched bucket is full again after burst/rate, which is one period
+		bucket: newTable[bucketState](maxKeys, period),
+	}
+}
+
+func (tb *TokenBucket) Allow(key string, now time.Time) Decision {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	s := tb.bucket.get(key, now, func() bucketState {
+		return bucketState{tokens: float64(tb.burst), last: now}
+	})
+	s.tokens = math.Min(float64(tb.burst), s.tokens+now.Sub(s.last).Seconds()*tb.rate)
+	s.last = now
+
+	d := Decision{Limit: tb.burst}
+	if s.tokens >= 1 {
+		s.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = tb.seconds(1 - s.tokens)
+	}
+	d.Remaining = int(s.tokens)
+	d.Reset = tb.seconds(float64(tb.burst) - s.tokens)
+	return d
+}
+
+// seconds is how long the bucket takes to gain tokens.
+func (tb *TokenBucket) seconds(tokens float64) time.Duration {
+	return time.Duration(tokens / tb.rate * float64(time.Second))
+}
+
+// SlidingWindow lets each key make limit requests in any window-long span. It keeps counts
+// for the current and previous fixed windows and weighs the previous one by how much of it
+// the sliding window still covers, which needs two counters per key instead of a timestamp
+// per request.
+type SlidingWindow struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	windows *table[windowState]
+}
+
+type windowState struct {
+	start    time.Time // start of the current fixed window
+	current  int
+	previous int
+}
+
+// NewSlidingWindow allows limit requests per window. maxKeys caps how many keys are tracked;
+// 0 means DefaultMaxKeys. It panics unless limit and window are positive.
+func NewSlidingWindow(limit int, window time.Duration, maxKeys int) *SlidingWindow {
+	checkQuota(limit, window)
+	return &SlidingWindow{
+		limit:  limit,
+		window: window,
+		// After two windows without requests both counters are zero
+		windows: newTable[windowState](maxKeys, 2*window),
+	}
+}
+
+func (sw *SlidingWindow) Allow(key string, now time.Time) Decision {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	s := sw.windows.get(key, now, func() windowState {
+		return windowState{start: now}
+	})
+	// Roll the fixed windows forward
+	if elapsed := now.Sub(s.start); elapsed >= sw.window {
+		windows := elapsed / sw.window
+		if windows == 1 {
+			s.previous = s.current
+		} else {
+			s.previous = 0
+		}
+		s.current = 0
+		s.start = s.start.Add(windows * sw.window)
+	}
+
+	elapsed := now.Sub(s.start)
+	weight := 1 - float64(elapsed)/float64(sw.window)
+	count := float64(s.previous)*weight + float64(s.current)
+
+	d := Decision{Limit: sw.limit, Reset: sw.window - elapsed}
+	if count+1 <= float64(sw.limit) {
+		s.current++
+		count++
+		d.Allowed = true
+	} else {
+		d.RetryAfter = sw.retryAfter(s, elapsed)
+	}
+	d.Remaining = max(0, int(float64(sw.limit)-count))
+	if s.current > 0 {
+		// The current window's requests only stop counting a full window after it ends
+		d.Reset += sw.window
+	}
+	return d
+}
+
+// retryAfter finds when the weighted count first leaves room for one request.
+func (sw *SlidingWindow) retryAfter(s *windowState, elapsed time.Duration) time.Duration {
+	room := float64(sw.limit - 1)
+	w := float64(sw.window)
+	if float64(s.current) <= room && s.previous > 0 {
+		// previous*(1-(elapsed+t)/w) + current <= room, still within this window
+		t := w*(1-(room-float64(s.current))/float64(s.previous)) - float64(elapsed)
+		return time.Duration(math.Ceil(t))
+	}
+	// Wait for the next window, where the current count becomes the previous one
+	untilNext := sw.window - elapsed
+	if s.current == 0 {
+		return untilNext
+	}
+	into := w * (1 - room/float64(s.current))
+	return untilNext + time.Duration(math.Ceil(math.Max(0, into)))
+}
@@ -0,0 +1,110 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/auth"
+	"github.com/kiefbc/http-server-1.1/internal/headers"
+	"github.com/kiefbc/http-server-1.1/internal/ipfilter"
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+)
+
+// KeyFunc picks the key a request is counted under. Returning false exempts the request.
+type KeyFunc func(req *request.Request) (string, bool)
+
+// ByRemoteIP counts requests per client IP address, ignoring the port.
+func ByRemoteIP(req *request.Request) (string, bool) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr, req.RemoteAddr != ""
+	}
+	return host, true
+}
+
+// ByClientIP counts requests per client IP as resolved by ipfilter.TrustedProxies, so clients
+// behind a trusted proxy are told apart. Without that middleware it matches ByRemoteIP.
+func ByClientIP(req *request.Request) (string, bool) {
+	client, ok := ipfilter.ClientIP(req)
+	if !ok {
+		return "", false
+	}
+	return client.String(), true
+}
+
+// ByHeader counts requests per value of the named header, such as an API key.
+// Requests without the header are not limited; chain another RateLimiter to cover them.
+func ByHeader(name string) KeyFunc {
+	return func(req *request.Request) (string, bool) {
+		value, ok := req.Headers.Get(name)
+		return value, ok && value != ""
+	}
+}
+
+// ByPrincipal counts requests per authenticated principal. It needs an auth middleware
+// earlier in the chain; unauthenticated requests are not limited.
+func ByPrincipal(req *request.Request) (string, bool) {
+	p, ok := auth.FromRequest(req)
+	if !ok {
+		return "", false
+	}
+	return p.Scheme + ":" + p.Name, true
+}
+
+// RateLimiter is middleware that refuses requests over a Limiter's quota with 429.
+type RateLimiter struct {
+	limiter Limiter
+	key     KeyFunc
+	now     func() time.Time
+}
+
+// New creates a RateLimiter that applies limiter to the keys key returns.
+func New(limiter Limiter, key KeyFunc) *RateLimiter {
+	return &RateLimiter{limiter: limiter, key: key, now: time.Now}
+}
+
+// Middleware answers 429 Too Many Requests with Retry-After (RFC 6585 Section 4) once a key is
+// over its quota. Every limited response carries RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset (draft-ietf-httpapi-ratelimit-headers) so clients can pace themselves.
+func (rl *RateLimiter) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		key, ok := rl.key(req)
+		if !ok {
+			return next(w, req)
+		}
+
+		d := rl.limiter.Allow(key, rl.now())
+		if !d.Allowed {
+			body := []byte("429 Too Many Requests")
+			responseHeaders := response.GetDefaultHeaders(len(body))
+			setRateLimitHeaders(responseHeaders, d)
+			responseHeaders.Replace("retry-after", fmt.Sprintf("%d", ceilSeconds(d.RetryAfter)))
+
+			w.WriteStatusLine(response.StatusTooManyRequests)
+			w.WriteHeaders(responseHeaders)
+			w.WriteBody(body)
+			return nil
+		}
+
+		w.OnWriteHeaders(func(status response.StatusCode, h headers.Headers) response.StatusCode {
+			setRateLimitHeaders(h, d)
+			return status
+		})
+		return next(w, req)
+	}
+}
+
+func setRateLimitHeaders(h headers.Headers, d Decision) {
+	h.Replace("ratelimit-limit", fmt.Sprintf("%d", d.Limit))
+	h.Replace("ratelimit-remaining", fmt.Sprintf("%d", d.Remaining))
+	h.Replace("ratelimit-reset", fmt.Sprintf("%d", ceilSeconds(d.Reset)))
+}
+
+// ceilSeconds rounds up so clients that wait the advertised time are not refused again.
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,166 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/auth"
+	"github.com/kiefbc/http-server-1.1/internal/ipfilter"
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/kiefbc/http-server-1.1/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// do runs handler for a GET from remoteAddr with the extra headers.
+func do(t *testing.T, handler server.Handler, remoteAddr string, extra map[string]string) *http.Response {
+	t.Helper()
+	req := servertest.NewRequest(t, "GET", "/", extra)
+	req.RemoteAddr = remoteAddr
+	resp, _ := servertest.Do(t, handler, req)
+	return resp
+}
+
+func ok(w *response.Writer, req *request.Request) *server.HandlerError {
+	w.WriteStatusLine(response.StatusNoContent)
+	w.WriteHeaders(nil)
+	return nil
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	tb := NewTokenBucket(3, 3*time.Second, 0)
+
+	// Test: A full bucket allows a burst and then refuses
+	for i := 2; i >= 0; i-- {
+		d := tb.Allow("a", now)
+		assert.True(t, d.Allowed)
+		assert.Equal(t, i, d.Remaining)
+	}
+	d := tb.Allow("a", now)
+	assert.False(t, d.Allowed)
+	assert.Equal(t, time.Second, d.RetryAfter)
+	assert.Equal(t, 3*time.Second, d.Reset)
+
+	// Test: Tokens refill at the configured rate
+	now = now.Add(time.Second)
+	assert.True(t, tb.Allow("a", now).Allowed)
+	assert.False(t, tb.Allow("a", now).Allowed)
+
+	// Test: Keys are independent
+	assert.True(t, tb.Allow("b", now).Allowed)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	sw := NewSlidingWindow(4, 10*time.Second, 0)
+
+	// Test: The limit applies within a window
+	for i := 0; i < 4; i++ {
+		assert.True(t, sw.Allow("a", now).Allowed)
+	}
+	d := sw.Allow("a", now)
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 0, d.Remaining)
+
+	// Test: The previous window still counts, weighted by its overlap
+	now = now.Add(12 * time.Second)
+	// 4 * 0.8 = 3.2 of the previous window's requests remain in view
+	d = sw.Allow("a", now)
+	assert.False(t, d.Allowed)
+	// 4 * (1 - (2+t)/10) <= 3 once t >= 0.5s
+	assert.Equal(t, 500*time.Millisecond, d.RetryAfter)
+	now = now.Add(d.RetryAfter)
+	assert.True(t, sw.Allow("a", now).Allowed)
+
+	// Test: After two quiet windows the full limit is back
+	now = now.Add(20 * time.Second)
+	d = sw.Allow("a", now)
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 3, d.Remaining)
+}
+
+func TestInvalidQuota(t *testing.T) {
+	// Test: Limits and periods that would divide by zero are refused up front
+	assert.Panics(t, func() { NewTokenBucket(0, time.Minute, 0) })
+	assert.Panics(t, func() { NewTokenBucket(1, 0, 0) })
+	assert.Panics(t, func() { NewSlidingWindow(0, time.Minute, 0) })
+	assert.Panics(t, func() { NewSlidingWindow(1, 0, 0) })
+}
+
+func TestEviction(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	tb := NewTokenBucket(1, time.Minute, 2)
+
+	// Test: Idle keys are dropped once their bucket would be full again
+	tb.Allow("a", now)
+	tb.Allow("b", now)
+	assert.Equal(t, 2, len(tb.bucket.items))
+	now = now.Add(time.Minute)
+	tb.Allow("c", now)
+	assert.Equal(t, 1, len(tb.bucket.items))
+
+	// Test: Past the cap the least recently used key goes
+	tb.Allow("d", now)
+	tb.Allow("c", now)
+	tb.Allow("e", now)
+	assert.Equal(t, 2, len(tb.bucket.items))
+	assert.Contains(t, tb.bucket.items, "c")
+	assert.NotContains(t, tb.bucket.items, "d")
+}
+
+func TestMiddleware(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	rl := New(NewTokenBucket(2, time.Minute, 0), ByRemoteIP)
+	rl.now = func() time.Time { return now }
+	handler := rl.Middleware(ok)
+
+	// Test: Allowed responses carry the quota
+	resp := do(t, handler, "192.0.2.1:5000", nil)
+	assert.Equal(t, 204, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "1", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", resp.Header.Get("RateLimit-Reset"))
+
+	// Test: The port does not separate clients, and over the limit is 429 with Retry-After
+	do(t, handler, "192.0.2.1:5001", nil)
+	resp = do(t, handler, "192.0.2.1:5002", nil)
+	assert.Equal(t, 429, resp.StatusCode)
+	assert.Equal(t, "30", resp.Header.Get("Retry-After"))
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+	assert.Equal(t, 204, do(t, handler, "192.0.2.2:5000", nil).StatusCode)
+
+	// Test: Behind a trusted proxy clients are told apart by their forwarded address
+	tp, err := ipfilter.NewTrustedProxies("10.0.0.0/8")
+	require.NoError(t, err)
+	byClient := New(NewTokenBucket(1, time.Minute, 0), ByClientIP)
+	byClient.now = rl.now
+	handler = server.Chain(ok, tp.Middleware, byClient.Middleware)
+	assert.Equal(t, 204, do(t, handler, "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "203.0.113.1"}).StatusCode)
+	assert.Equal(t, 204, do(t, handler, "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "203.0.113.2"}).StatusCode)
+	assert.Equal(t, 429, do(t, handler, "10.0.0.1:5000", map[string]string{"X-Forwarded-For": "203.0.113.1"}).StatusCode)
+
+	// Test: Header keys exempt requests without the header
+	byKey := New(NewTokenBucket(1, time.Minute, 0), ByHeader("X-API-Key"))
+	byKey.now = rl.now
+	handler = byKey.Middleware(ok)
+	assert.Equal(t, 204, do(t, handler, "", map[string]string{"X-API-Key": "k1"}).StatusCode)
+	assert.Equal(t, 429, do(t, handler, "", map[string]string{"X-API-Key": "k1"}).StatusCode)
+	assert.Equal(t, 204, do(t, handler, "", nil).StatusCode)
+	assert.Equal(t, 204, do(t, handler, "", nil).StatusCode)
+
+	// Test: Principals are limited after authentication
+	byUser := New(NewTokenBucket(1, time.Minute, 0), ByPrincipal)
+	byUser.now = rl.now
+	bearer := auth.NewBearer("api", auth.TokenValidatorFunc(func(ctx context.Context, token string) (*auth.Principal, error) {
+		return &auth.Principal{Name: token}, nil
+	}))
+	handler = server.Chain(ok, bearer.Middleware, byUser.Middleware)
+	assert.Equal(t, 204, do(t, handler, "", map[string]string{"Authorization": "Bearer alice"}).StatusCode)
+	assert.Equal(t, 429, do(t, handler, "", map[string]string{"Authorization": "Bearer alice"}).StatusCode)
+	assert.Equal(t, 204, do(t, handler, "", map[string]string{"Authorization": "Bearer bob"}).StatusCode)
+}
@@ -0,0 +1,211 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"net/url"
+)
+
+var (
+	// ErrNotForm means the request body is not a form this package can parse.
+	ErrNotForm = errors.New("request body is not a form")
+	// ErrFormTooLarge means the form exceeds FormLimits.MaxBytes.
+	ErrFormTooLarge = errors.New("form too large")
+	// ErrTooManyParts means the form has more fields than FormLimits.MaxParts.
+	ErrTooManyParts = errors.New("form has too many parts")
+)
+
+// FormLimits bounds the resources a single form may consume. The request body is read into
+// memory before the handler runs, and part contents are copied out of it, so a form costs up
+// to twice MaxBytes of memory and no disk.
+type FormLimits struct {
+	// MaxBytes is the largest form body accepted, counting the encoded body as sent.
+	MaxBytes int64
+	// MaxParts is the most fields, or multipart parts, a form may contain.
+	MaxParts int
+}
+
+// DefaultFormLimits are the limits ParseForm uses.
+var DefaultFormLimits = FormLimits{
+	MaxBytes: 32 << 20,
+	MaxParts: 1000,
+}
+
+// Form holds the fields of a parsed form body.
+type Form struct {
+	// Value holds every non-file field, keyed by name, in the order sent.
+	Value url.Values
+	// File holds the file parts of a multipart form, keyed by field name.
+	File map[string][]*FormPart
+	// Parts lists every part of a multipart form in order, including plain fields,
+	// so their headers can be inspected. It is empty for urlencoded forms.
+	Parts []*FormPart
+}
+
+// Get returns the first value for the named field, or "" if there is none.
+func (f *Form) Get(name string) string {
+	return f.Value.Get(name)
+}
+
+// FirstFile returns the first file sent for the named field.
+func (f *Form) FirstFile(name string) (*FormPart, bool) {
+	files := f.File[name]
+	if len(files) == 0 {
+		return nil, false
+	}
+	return files[0], true
+}
+
+// FormPart is one part of a multipart/form-data body (RFC 7578).
+type FormPart struct {
+	// Name is the field name from Content-Disposition.
+	Name string
+	// Filename is the file name the client sent, or "" for a plain field.
+	// It is not sanitised and must not be used as a path as is.
+	Filename string
+	// Header holds the part's own header fields, e.g. Content-Type.
+	Header textproto.MIMEHeader
+	// Size is the length of the part's content in bytes.
+	Size int64
+
+	content []byte
+}
+
+// IsFile reports whether the part was sent as a file upload.
+func (p *FormPart) IsFile() bool {
+	return p.Filename != ""
+}
+
+// formPartFile is an in-memory part content that satisfies the same interface as *os.File.
+type formPartFile struct {
+	*bytes.Reader
+}
+
+func (formPartFile) Close() error {
+	return nil
+}
+
+// Open returns a reader for the part's content.
+func (p *FormPart) Open() (io.ReadSeekCloser, error) {
+	return formPartFile{bytes.NewReader(p.content)}, nil
+}
+
+// parsedForm is a successfully parsed form, kept so later ParseForm calls need not parse again.
+type parsedForm struct {
+	form  *Form
+	parts int // fields counted against FormLimits.MaxParts
+}
+
+// ParseForm parses an application/x-www-form-urlencoded or multipart/form-data body with
+// DefaultFormLimits. Query parameters in the request target are not included.
+func (r *Request) ParseForm() (*Form, error) {
+	return r.ParseFormWithLimits(DefaultFormLimits)
+}
+
+// ParseFormWithLimits is ParseForm with explicit limits. It returns ErrNotForm for other
+// content types, ErrFormTooLarge and ErrTooManyParts when a limit is hit, and a wrapped
+// parse error for malformed bodies.
+//
+// A form that parses is kept on the request, and on copies made from it afterwards with
+// WithContext, and later calls return the same Form. They still check it against their own
+// limits, so a handler asking for stricter limits than a middleware that parsed first gets
+// the error it asked for.
+func (r *Request) ParseFormWithLimits(limits FormLimits) (*Form, error) {
+	if r.form == nil {
+		form, parts, err := r.parseForm(limits)
+		if err != nil {
+			return nil, err
+		}
+		r.form = &parsedForm{form: form, parts: parts}
+	}
+	if limits.MaxBytes > 0 && int64(len(r.Body)) > limits.MaxBytes {
+		return nil, ErrFormTooLarge
+	}
+	if limits.MaxParts > 0 && r.form.parts > limits.MaxParts {
+		return nil, ErrTooManyParts
+	}
+	return r.form.form, nil
+}
+
+// parseForm parses the body as a form within limits and reports how many fields it counted.
+func (r *Request) parseForm(limits FormLimits) (*Form, int, error) {
+	contentType, _ := r.Headers.Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, 0, ErrNotForm
+	}
+	if limits.MaxBytes > 0 && int64(len(r.Body)) > limits.MaxBytes {
+		return nil, 0, ErrFormTooLarge
+	}
+
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		return parseURLEncoded(r.Body, limits)
+	case "multipart/form-data":
+		boundary := params["boundary"]
+		if boundary == "" {
+			return nil, 0, fmt.Errorf("invalid multipart form: missing boundary")
+		}
+		form, err := parseMultipart(r.Body, boundary, limits)
+		if err != nil {
+			return nil, 0, err
+		}
+		return form, len(form.Parts), nil
+	}
+	return nil, 0, ErrNotForm
+}
+
+// parseURLEncoded parses a urlencoded body (WHATWG URL Standard, application/x-www-form-urlencoded).
+func parseURLEncoded(body []byte, limits FormLimits) (*Form, int, error) {
+	fields := bytes.Count(body, []byte("&")) + 1
+	if limits.MaxParts > 0 && fields > limits.MaxParts {
+		return nil, 0, ErrTooManyParts
+	}
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid urlencoded form: %w", err)
+	}
+	return &Form{Value: values, File: map[string][]*FormPart{}}, fields, nil
+}
+
+// parseMultipart reads every part of a multipart/form-data body into memory.
+func parseMultipart(body []byte, boundary string, limits FormLimits) (*Form, error) {
+	form := &Form{Value: url.Values{}, File: map[string][]*FormPart{}}
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return form, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid multipart form: %w", err)
+		}
+		if limits.MaxParts > 0 && len(form.Parts) >= limits.MaxParts {
+			return nil, ErrTooManyParts
+		}
+
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid multipart form: %w", err)
+		}
+		formPart := &FormPart{
+			Name:     part.FormName(),
+			Filename: part.FileName(),
+			Header:   part.Header,
+			Size:     int64(len(content)),
+			content:  content,
+		}
+		form.Parts = append(form.Parts, formPart)
+		if formPart.IsFile() {
+			form.File[formPart.Name] = append(form.File[formPart.Name], formPart)
+		} else {
+			form.Value.Add(formPart.Name, string(content))
+		}
+	}
+}
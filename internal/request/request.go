@@ -1,12 +1,14 @@
 package request
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
 
+	"github.com/kiefbc/http-server-1.1/internal/cookie"
 	"github.com/kiefbc/http-server-1.1/internal/headers"
 )
 
@@ -26,6 +28,40 @@ type Request struct {
 	state       stateStatus
 	Body        []byte
 	bodyLength  int
+	buffered    []byte
+	ctx         context.Context
+	form        *parsedForm // set by the first ParseForm call
+	// RemoteAddr is the network address of the peer that sent the request,
+	// set by the server from the accepted connection ("host:port").
+	RemoteAddr string
+	// LocalAddr is the server address the request was received on, or with a PROXY
+	// protocol listener the address the client originally connected to.
+	LocalAddr string
+}
+
+// ParseErrorKind names the part of a request that could not be read.
+type ParseErrorKind string
+
+const (
+	KindRead        ParseErrorKind = "read"
+	KindRequestLine ParseErrorKind = "request_line"
+	KindHeaders     ParseErrorKind = "headers"
+	KindBody        ParseErrorKind = "body"
+)
+
+// ParseError is returned by RequestFromReader when a request is malformed or the
+// connection fails, so callers can tell kinds of bad requests apart.
+type ParseError struct {
+	Kind ParseErrorKind
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
 }
 
 type RequestLine struct {
@@ -62,20 +98,24 @@ func RequestFromReader(r io.Reader) (*Request, error) {
 					if contentLengthStr, exists := request.Headers.Get("Content-Length"); exists {
 						contentLength, parseErr := strconv.ParseInt(contentLengthStr, 10, 64)
 						if parseErr == nil && int64(len(request.Body)) < contentLength {
-							return &Request{}, fmt.Errorf("incomplete body: expected %d bytes, got %d", contentLength, len(request.Body))
+							return &Request{}, &ParseError{Kind: KindBody, Err: fmt.Errorf("incomplete body: expected %d bytes, got %d", contentLength, len(request.Body))}
 						}
 					}
 				}
 				request.state = done
 				break
 			}
-			return &Request{}, fmt.Errorf("error reading data: %w", err)
+			return &Request{}, &ParseError{Kind: KindRead, Err: fmt.Errorf("error reading data: %w", err)}
 		}
 
 		readToIndex += bytesRead
 
 		parsedBytes, err := request.parse(buffer[:readToIndex])
 		if err != nil {
+			var parseErr *ParseError
+			if errors.As(err, &parseErr) {
+				return &Request{}, &ParseError{Kind: parseErr.Kind, Err: fmt.Errorf("error parsing request: %v", err)}
+			}
 			return &Request{}, fmt.Errorf("error parsing request: %v", err)
 		}
 
@@ -83,9 +123,60 @@ func RequestFromReader(r io.Reader) (*Request, error) {
 		readToIndex -= parsedBytes
 	}
 
+	if readToIndex > 0 {
+		request.buffered = append([]byte(nil), buffer[:readToIndex]...)
+	}
 	return &request, nil
 }
 
+// Buffered returns bytes read from the connection after the end of the request, such as
+// tunnel data a CONNECT client sent in the same packet as its headers. A handler that
+// hijacks the connection must read them before anything else.
+func (r *Request) Buffered() []byte {
+	return r.buffered
+}
+
+// Context returns the request's context. It is never nil: requests start with
+// context.Background until the server attaches a connection-scoped context.
+// The server cancels it when the client disconnects, the server shuts down,
+// or the per-request deadline passes.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of r with its context changed to ctx.
+// Middleware uses this to attach values or deadlines for the handlers it wraps.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("request: nil context")
+	}
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// Cookies parses the Cookie header (RFC 6265 Section 5.4). Malformed pairs are skipped.
+func (r *Request) Cookies() []*cookie.Cookie {
+	header, ok := r.Headers.Get("Cookie")
+	if !ok {
+		return nil
+	}
+	return cookie.Parse(header)
+}
+
+// Cookie returns the first cookie with the given name.
+func (r *Request) Cookie(name string) (*cookie.Cookie, bool) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
 // parseRequestLine parses the HTTP request line from the given data bytes.
 // Returns the parsed RequestLine, number of bytes consumed, and any error encountered.
 func parseRequestLine(data []byte) (RequestLine, int, error) {
@@ -157,7 +248,7 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	case initialized:
 		requestLine, bytesRead, err := parseRequestLine(data)
 		if err != nil {
-			return 0, fmt.Errorf("error parsing request line: %v", err)
+			return 0, &ParseError{Kind: KindRequestLine, Err: fmt.Errorf("error parsing request line: %v", err)}
 		}
 		if bytesRead == 0 {
 			return 0, nil // need more data
@@ -169,7 +260,7 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	case parsingHeaders:
 		bytesRead, headersDone, err := r.Headers.Parse(data)
 		if err != nil {
-			return 0, fmt.Errorf("error parsing headers: %v", err)
+			return 0, &ParseError{Kind: KindHeaders, Err: fmt.Errorf("error parsing headers: %v", err)}
 		}
 		if bytesRead == 0 {
 			return 0, nil // need more data
@@ -182,7 +273,7 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		if contentLengthStr, exists := r.Headers.Get("Content-Length"); exists {
 			contentLength, err := strconv.ParseInt(contentLengthStr, 10, 64)
 			if err != nil {
-				return 0, fmt.Errorf("invalid Content-Length: %v", err)
+				return 0, &ParseError{Kind: KindBody, Err: fmt.Errorf("invalid Content-Length: %v", err)}
 			}
 
 			// r.Body = append(r.Body, data...)
@@ -204,8 +295,10 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			// return len(data), nil
 			return int(bytesToTake), nil // Return only what we consumed
 		} else {
+			// Without Content-Length there is no body (RFC 9112 Section 6.3); what follows
+			// the headers belongs to whoever reads the connection next
 			r.state = done
-			return len(data), nil
+			return 0, nil
 		}
 
 	case done:
@@ -1,6 +1,8 @@
 package request
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"strings"
 	"testing"
@@ -72,6 +74,9 @@ func TestRequestLineParse(t *testing.T) {
 	// Test: Invalid HTTP Version
 	_, err = RequestFromReader(strings.NewReader("/coffee HTTP/2.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\nAccept: */*\r\n\r\n"))
 	require.Error(t, err)
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, KindRequestLine, parseErr.Kind)
 }
 
 func TestHeaderParse(t *testing.T) {
@@ -136,6 +141,9 @@ func TestHeaderParse(t *testing.T) {
 	}
 	r, err = RequestFromReader(reader)
 	require.Error(t, err)
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, KindHeaders, parseErr.Kind)
 }
 
 func TestBodyParse(t *testing.T) {
@@ -164,6 +172,9 @@ func TestBodyParse(t *testing.T) {
 	}
 	r, err = RequestFromReader(reader)
 	require.Error(t, err)
+	var parseErr *ParseError
+	require.ErrorAs(t, err, &parseErr)
+	assert.Equal(t, KindBody, parseErr.Kind)
 
 	// Test: Empty body with Content-Length 0
 	reader = &chunkReader{
@@ -202,6 +213,17 @@ func TestBodyParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
+	assert.Equal(t, "", string(r.Body))
+
+	// Test: Bytes after a bodiless request are kept for a hijacker, not consumed
+	tunnel := strings.NewReader("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\nTLS client hello")
+	r, err = RequestFromReader(tunnel)
+	require.NoError(t, err)
+	rest, err := io.ReadAll(tunnel)
+	require.NoError(t, err)
+	assert.Equal(t, "", string(r.Body))
+	assert.NotEmpty(t, r.Buffered())
+	assert.Equal(t, "TLS client hello", string(r.Buffered())+string(rest))
 
 	// Test: Body with extra data beyond Content-Length
 	reader = &chunkReader{
@@ -216,4 +238,111 @@ func TestBodyParse(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, r)
 	assert.Equal(t, "hello", string(r.Body)) // Should only be "hello", not "helloEXTRA_DATA_THAT_SHOULD_NOT_BE_IN_BODY"
+	assert.True(t, strings.HasPrefix("EXTRA_DATA_THAT_SHOULD_NOT_BE_IN_BODY", string(r.Buffered())))
+}
+
+func TestCookies(t *testing.T) {
+	// Test: Cookie header is parsed into name/value pairs
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\nCookie: session=abc; theme=dark\r\n\r\n"))
+	require.NoError(t, err)
+	require.Len(t, r.Cookies(), 2)
+	c, ok := r.Cookie("theme")
+	require.True(t, ok)
+	assert.Equal(t, "dark", c.Value)
+	_, ok = r.Cookie("missing")
+	assert.False(t, ok)
+
+	// Test: No Cookie header means no cookies
+	r, err = RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Empty(t, r.Cookies())
+}
+
+// formRequest builds a POST request with the given Content-Type and body.
+func formRequest(t *testing.T, contentType, body string) *Request {
+	t.Helper()
+	raw := fmt.Sprintf("POST /submit HTTP/1.1\r\nHost: localhost:42069\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s", contentType, len(body), body)
+	r, err := RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return r
+}
+
+func TestFormParse(t *testing.T) {
+	// Test: urlencoded forms keep every value of repeated fields
+	r := formRequest(t, "application/x-www-form-urlencoded", "sugar=1&milk=2&milk=oat+milk&note=a%26b")
+	form, err := r.ParseForm()
+	require.NoError(t, err)
+	assert.Equal(t, "1", form.Get("sugar"))
+	assert.Equal(t, []string{"2", "oat milk"}, form.Value["milk"])
+	assert.Equal(t, "a&b", form.Get("note"))
+
+	// Test: The form is parsed once and shared with later copies of the request
+	again, err := r.WithContext(context.Background()).ParseForm()
+	require.NoError(t, err)
+	assert.Same(t, form, again)
+
+	// Test: Stricter limits on a later call are still enforced
+	_, err = r.ParseFormWithLimits(FormLimits{MaxBytes: 10})
+	assert.ErrorIs(t, err, ErrFormTooLarge)
+	_, err = r.ParseFormWithLimits(FormLimits{MaxParts: 2})
+	assert.ErrorIs(t, err, ErrTooManyParts)
+	again, err = r.ParseFormWithLimits(FormLimits{MaxParts: 4})
+	require.NoError(t, err)
+	assert.Same(t, form, again)
+
+	// Test: Other content types are not forms
+	_, err = formRequest(t, "application/json", "{}").ParseForm()
+	assert.ErrorIs(t, err, ErrNotForm)
+
+	// Test: Limits on size and field count
+	urlencoded := "sugar=1&milk=2&milk=oat+milk&note=a%26b"
+	_, err = formRequest(t, "application/x-www-form-urlencoded", urlencoded).ParseFormWithLimits(FormLimits{MaxBytes: 10})
+	assert.ErrorIs(t, err, ErrFormTooLarge)
+	_, err = formRequest(t, "application/x-www-form-urlencoded", urlencoded).ParseFormWithLimits(FormLimits{MaxParts: 2})
+	assert.ErrorIs(t, err, ErrTooManyParts)
+
+	// Test: multipart forms expose fields, files and per-part headers
+	body := "--XYZ\r\n" +
+		"Content-Disposition: form-data; name=\"title\"\r\n\r\n" +
+		"Holiday\r\n" +
+		"--XYZ\r\n" +
+		"Content-Disposition: form-data; name=\"photo\"; filename=\"small.txt\"\r\n" +
+		"Content-Type: text/plain\r\n\r\n" +
+		"tiny\r\n" +
+		"--XYZ\r\n" +
+		"Content-Disposition: form-data; name=\"photo\"; filename=\"big.bin\"\r\n" +
+		"Content-Type: application/octet-stream\r\n\r\n" +
+		strings.Repeat("x", 64) + "\r\n" +
+		"--XYZ--\r\n"
+	r = formRequest(t, "multipart/form-data; boundary=XYZ", body)
+	form, err = r.ParseFormWithLimits(FormLimits{MaxParts: 10})
+	require.NoError(t, err)
+	assert.Equal(t, "Holiday", form.Get("title"))
+	require.Len(t, form.Parts, 3)
+	assert.False(t, form.Parts[0].IsFile())
+	require.Len(t, form.File["photo"], 2)
+
+	small, ok := form.FirstFile("photo")
+	require.True(t, ok)
+	assert.Equal(t, "small.txt", small.Filename)
+	assert.Equal(t, "text/plain", small.Header.Get("Content-Type"))
+	assert.Equal(t, int64(4), small.Size)
+
+	// Test: File content can be read back
+	big := form.File["photo"][1]
+	assert.Equal(t, int64(64), big.Size)
+	file, err := big.Open()
+	require.NoError(t, err)
+	content, err := io.ReadAll(file)
+	file.Close()
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("x", 64), string(content))
+
+	// Test: Too many parts and a missing boundary are errors
+	_, err = formRequest(t, "multipart/form-data; boundary=XYZ", body).ParseFormWithLimits(FormLimits{MaxParts: 2})
+	assert.ErrorIs(t, err, ErrTooManyParts)
+	_, err = r.ParseFormWithLimits(FormLimits{MaxParts: 2})
+	assert.ErrorIs(t, err, ErrTooManyParts)
+	_, err = formRequest(t, "multipart/form-data", body).ParseForm()
+	assert.Error(t, err)
 }
@@ -0,0 +1,101 @@
+package requestid
+
+import (
+	"context"
+
+	"github.com/kiefbc/http-server-1.1/internal/headers"
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+)
+
+// Header carries the request ID in both directions.
+const Header = "X-Request-ID"
+
+// maxLength bounds accepted IDs so clients cannot bloat logs and upstream requests.
+const maxLength = 128
+
+type contextKey struct{}
+
+// slot holds the ID Middleware assigned. It is shared with requests further out in the
+// chain that were prepared with Track.
+type slot struct {
+	id string
+}
+
+// FromRequest returns the ID Middleware assigned to req, or to a request derived from it
+// when req was prepared with Track.
+func FromRequest(req *request.Request) (string, bool) {
+	s, ok := req.Context().Value(contextKey{}).(*slot)
+	if !ok || s.id == "" {
+		return "", false
+	}
+	return s.id, true
+}
+
+// Track returns a copy of req in which Middleware further in the chain records the ID it
+// assigns, so middleware that wraps it, such as access logging, can read the ID with
+// FromRequest once the handler returns.
+func Track(req *request.Request) *request.Request {
+	if _, ok := req.Context().Value(contextKey{}).(*slot); ok {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), contextKey{}, &slot{}))
+}
+
+// Middleware gives every request an ID: the caller's X-Request-ID when it is valid, otherwise
+// a new random one. The ID is stored on the request, replaces the request's X-Request-ID
+// header so access logs and the reverse proxy see the same value, is echoed in the response
+// and is added to handler errors.
+//
+// Middleware that writes handler errors itself, such as access logging, must wrap this one
+// for those errors to carry the ID.
+func Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		id, ok := req.Headers.Get(Header)
+		if !ok || !Valid(id) {
+			id = New()
+		}
+		req.Headers.Replace(Header, id)
+		if s, ok := req.Context().Value(contextKey{}).(*slot); ok && s.id == "" {
+			s.id = id
+		} else {
+			req = req.WithContext(context.WithValue(req.Context(), contextKey{}, &slot{id: id}))
+		}
+
+		w.OnWriteHeaders(func(status response.StatusCode, h headers.Headers) response.StatusCode {
+			h.Replace(Header, id)
+			return status
+		})
+
+		handlerErr := next(w, req)
+		if handlerErr != nil && handlerErr.RequestID == "" {
+			handlerErr.RequestID = id
+		}
+		return handlerErr
+	}
+}
+
+// Valid reports whether id is safe to accept from a client: 1 to 128 characters of letters,
+// digits and "-_.:+/=@", which covers UUIDs, hex and base64 IDs.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':' || c == '+' || c == '/' || c == '=' || c == '@':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// New returns a random 128-bit ID in hex, the same kind of ID the server gives responses it
+// writes before any handler runs.
+func New() string {
+	return server.NewRequestID()
+}
@@ -0,0 +1,66 @@
+package requestid
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/kiefbc/http-server-1.1/internal/servertest"
+	"github.com/stretchr/testify/assert"
+)
+
+// do runs handler behind Middleware, finishing the response as the server would, and returns
+// the response, the request the handler saw and the ID stored on it.
+func do(t *testing.T, handler server.Handler, extra map[string]string) (*http.Response, string, *request.Request, string) {
+	t.Helper()
+	var seen *request.Request
+	var id string
+	wrapped := Middleware(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		seen = req
+		id, _ = FromRequest(req)
+		return handler(w, req)
+	})
+	resp, body := servertest.Do(t, wrapped, servertest.NewRequest(t, "GET", "/", extra))
+	return resp, body, seen, id
+}
+
+func ok(w *response.Writer, req *request.Request) *server.HandlerError {
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(response.GetDefaultHeaders(0))
+	return nil
+}
+
+func TestMiddleware(t *testing.T) {
+	// Test: A valid incoming ID is kept, stored and echoed
+	resp, _, req, id := do(t, ok, map[string]string{"X-Request-ID": "9b2c6f1e-4d3a-4c1b-8f2e-7a6d5c4b3a21"})
+	assert.Equal(t, "9b2c6f1e-4d3a-4c1b-8f2e-7a6d5c4b3a21", id)
+	assert.Equal(t, id, resp.Header.Get(Header))
+	header, _ := req.Headers.Get(Header)
+	assert.Equal(t, id, header)
+
+	// Test: Missing or invalid IDs are replaced, including in the request header
+	_, _, _, id = do(t, ok, nil)
+	assert.Regexp(t, `^[0-9a-f]{32}$`, id)
+	resp, _, req, id = do(t, ok, map[string]string{"X-Request-ID": "bad id"})
+	assert.Regexp(t, `^[0-9a-f]{32}$`, id)
+	assert.Equal(t, id, resp.Header.Get(Header))
+	header, _ = req.Headers.Get(Header)
+	assert.Equal(t, id, header)
+	assert.NotEqual(t, New(), New())
+
+	// Test: Handler errors carry the ID in their body
+	resp, body, _, _ := do(t, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		return &server.HandlerError{StatusCode: response.StatusInternalServerError, Message: "500 Internal Server Error"}
+	}, map[string]string{"X-Request-ID": "abc-123"})
+	assert.Equal(t, "abc-123", resp.Header.Get(Header))
+	assert.Equal(t, "500 Internal Server Error\nRequest ID: abc-123", body)
+
+	// Test: Validation
+	assert.True(t, Valid("Zm9vYmFy+/=_.:@"))
+	assert.False(t, Valid(strings.Repeat("a", 129)))
+	assert.False(t, Valid("a\r\nb"))
+	assert.False(t, Valid(`"quoted"`))
+}
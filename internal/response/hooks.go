@@ -0,0 +1,96 @@
+package response
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/kiefbc/http-server-1.1/internal/headers"
+)
+
+// HeaderHook runs just before the status line and headers are sent.
+// It may modify h in place and returns the status code to send.
+type HeaderHook func(status StatusCode, h headers.Headers) StatusCode
+
+// OnWriteHeaders registers a hook to run when WriteHeaders is called.
+// Hooks registered later run first, so a middleware sees the headers after every
+// middleware and handler it wraps has had its say.
+func (w *Writer) OnWriteHeaders(hook HeaderHook) {
+	w.hooks = append(w.hooks, hook)
+}
+
+// TrailerHook runs just before the trailer section of a chunked response is sent and may add
+// fields to h. Announce them in the Trailer header from an OnWriteHeaders hook.
+type TrailerHook func(h headers.Headers)
+
+// OnWriteTrailers registers a hook to run when the trailers of a chunked response are written,
+// whether by WriteTrailers or, for a response without trailers of its own, by WriteTrailersDone
+// or Close. It does not run for responses sent with Content-Length or cut short by Abort.
+func (w *Writer) OnWriteTrailers(hook TrailerHook) {
+	w.trailerHooks = append(w.trailerHooks, hook)
+}
+
+// WrapBody installs a body filter. wrap receives the writer body bytes currently flow into
+// and returns the writer the handler's bytes should flow into instead, e.g. a compressor or
+// a tee. The filter sees payload only; chunk framing is applied after every filter.
+// If the returned writer is an io.Closer it is closed at the end of the body, before the last chunk.
+// Filters installed later sit closer to the handler, matching OnWriteHeaders.
+func (w *Writer) WrapBody(wrap func(next io.Writer) io.Writer) {
+	filter := wrap(w.body)
+	w.filters = append(w.filters, filter)
+	w.body = filter
+}
+
+// closeFilters closes body filters from the handler side inwards so each one can flush
+// into the next, and then resets the chain so filters are only closed once.
+func (w *Writer) closeFilters() error {
+	for i := len(w.filters) - 1; i >= 0; i-- {
+		if closer, ok := w.filters[i].(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				return fmt.Errorf("error closing body filter: %v", err)
+			}
+		}
+	}
+
+	w.filters = nil
+	w.body = framer{w}
+	return nil
+}
+
+// framer is the end of the body filter chain. It applies the message framing chosen by
+// the final headers: chunked encoding, raw bytes, or nothing for bodiless responses.
+type framer struct {
+	w *Writer
+}
+
+func (f framer) Write(p []byte) (int, error) {
+	if f.w.noBody {
+		return len(p), nil
+	}
+	if !f.w.chunked {
+		n, err := f.w.writer.Write(p)
+		f.w.bodyBytes += int64(n)
+		return n, err
+	}
+	if len(p) == 0 {
+		// A zero-size chunk would end the body (RFC 9112 Section 7.1)
+		return 0, nil
+	}
+
+	// Write chunk size in hexadecimal + CRLF
+	if _, err := fmt.Fprintf(f.w.writer, "%x\r\n", len(p)); err != nil {
+		return 0, err
+	}
+
+	// Write chunk data
+	n, err := f.w.writer.Write(p)
+	f.w.bodyBytes += int64(n)
+	if err != nil {
+		return n, err
+	}
+
+	// Write trailing CRLF after chunk data
+	if _, err := fmt.Fprintf(f.w.writer, "\r\n"); err != nil {
+		return n, err
+	}
+	return n, nil
+}
@@ -1,9 +1,13 @@
 package response
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"strings"
 
+	"github.com/kiefbc/http-server-1.1/internal/cookie"
 	"github.com/kiefbc/http-server-1.1/internal/headers"
 )
 
@@ -18,76 +22,182 @@ const (
 	stateChunkedWriting
 	stateChunkedDone
 	stateTrailersWritten
+	stateDone
+	stateHijacked
 )
 
 type StatusCode int
 
 const (
-	StatusOK                  StatusCode = 200
-	StatusBadRequest          StatusCode = 400
-	StatusNotFound            StatusCode = 404
-	StatusForbidden           StatusCode = 403
-	StatusMethodNotAllowed    StatusCode = 405
-	StatusInternalServerError StatusCode = 500
+	StatusOK                   StatusCode = 200
+	StatusNoContent            StatusCode = 204
+	StatusPartialContent       StatusCode = 206
+	StatusMovedPermanently     StatusCode = 301
+	StatusNotModified          StatusCode = 304
+	StatusBadRequest           StatusCode = 400
+	StatusUnauthorized         StatusCode = 401
+	StatusNotFound             StatusCode = 404
+	StatusForbidden            StatusCode = 403
+	StatusMethodNotAllowed     StatusCode = 405
+	StatusProxyAuthRequired    StatusCode = 407
+	StatusRequestTimeout       StatusCode = 408
+	StatusPreconditionFailed   StatusCode = 412
+	StatusContentTooLarge      StatusCode = 413
+	StatusUnsupportedMediaType StatusCode = 415
+	StatusRangeNotSatisfiable  StatusCode = 416
+	StatusTooManyRequests      StatusCode = 429
+	StatusInternalServerError  StatusCode = 500
+	StatusBadGateway           StatusCode = 502
+	StatusServiceUnavailable   StatusCode = 503
+	StatusGatewayTimeout       StatusCode = 504
 )
 
+// statusText maps status codes to their reason phrases (RFC 9110 Section 15).
+var statusText = map[StatusCode]string{
+	200: "OK",
+	201: "Created",
+	202: "Accepted",
+	204: "No Content",
+	206: "Partial Content",
+	301: "Moved Permanently",
+	302: "Found",
+	303: "See Other",
+	304: "Not Modified",
+	307: "Temporary Redirect",
+	308: "Permanent Redirect",
+	400: "Bad Request",
+	401: "Unauthorized",
+	403: "Forbidden",
+	404: "Not Found",
+	405: "Method Not Allowed",
+	407: "Proxy Authentication Required",
+	408: "Request Timeout",
+	409: "Conflict",
+	410: "Gone",
+	411: "Length Required",
+	412: "Precondition Failed",
+	413: "Content Too Large",
+	415: "Unsupported Media Type",
+	416: "Range Not Satisfiable",
+	429: "Too Many Requests",
+	500: "Internal Server Error",
+	501: "Not Implemented",
+	502: "Bad Gateway",
+	503: "Service Unavailable",
+	504: "Gateway Timeout",
+}
+
+// StatusText returns the reason phrase for the status code, or "" if it is unknown.
+func StatusText(code StatusCode) string {
+	return statusText[code]
+}
+
 // Writer encapsulates HTTP response writing functionality.
 // Provides control over status line, headers, and body content with state validation.
+// Middleware can observe and rewrite the response through OnWriteHeaders and WrapBody.
 type Writer struct {
-	writer io.Writer
-	state  writerState
+	writer   io.Writer
+	state    writerState
+	hijacker func() (net.Conn, error)
+
+	statusCode   StatusCode
+	cookies      []*cookie.Cookie
+	lines        []HeaderLine
+	hooks        []HeaderHook
+	trailerHooks []TrailerHook
+	filters      []io.Writer // body filters, most recently installed last
+	body         io.Writer   // where body bytes enter: the last filter, or the framer
+	chunked      bool        // final headers selected chunked transfer coding
+	noBody       bool        // final status or a HEAD request forbids a body (RFC 9110 Section 6.4.1)
+	head         bool        // the response answers a HEAD request
+	aborted      bool        // the response was cut short and must not be completed
+	bodyBytes    int64       // body bytes sent, after filters and without chunk framing
 }
 
 // NewWriter creates a new response Writer that writes to the provided io.Writer.
 func NewWriter(w io.Writer) *Writer {
-	return &Writer{
+	rw := &Writer{
 		writer: w,
 		state:  stateInit,
 	}
+	rw.body = framer{rw}
+	return rw
+}
+
+// SetRequestMethod tells the Writer which method the response answers. A response to HEAD
+// never carries a body (RFC 9110 Section 9.3.2), so body bytes and chunk framing are dropped
+// while headers such as Content-Length still describe what a GET would have sent.
+// The server calls it before running the handler.
+func (w *Writer) SetRequestMethod(method string) {
+	w.head = method == "HEAD"
 }
 
-// WriteStatusLine writes an HTTP status line using the Writer's internal writer.
+// WriteStatusLine sets the HTTP status code of the response.
 // Must be called first before WriteHeaders or WriteBody.
-// The status line format follows RFC 9112 Section 3.1.2: HTTP-version SP status-code SP [reason-phrase] CRLF
+// The status line itself is sent together with the headers so that header hooks can still
+// change the status; its format follows RFC 9112 Section 3.1.2: HTTP-version SP status-code SP [reason-phrase] CRLF
 func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 	if w.state != stateInit {
 		return fmt.Errorf("WriteStatusLine called out of order - must be called first")
 	}
 
-	var err error
-	switch statusCode {
-	case 200:
-		_, err = fmt.Fprintf(w.writer, "HTTP/1.1 200 OK\r\n")
-	case 400:
-		_, err = fmt.Fprintf(w.writer, "HTTP/1.1 400 Bad Request\r\n")
-	case 500:
-		_, err = fmt.Fprintf(w.writer, "HTTP/1.1 500 Internal Server Error\r\n")
-	default:
-		_, err = fmt.Fprintf(w.writer, "HTTP/1.1 %d\r\n", statusCode)
-	}
-
-	if err == nil {
-		w.state = stateStatusWritten
-	}
-	return err
+	w.statusCode = statusCode
+	w.state = stateStatusWritten
+	return nil
 }
 
-// WriteHeaders writes HTTP header fields using the Writer's internal writer.
+// WriteHeaders writes the status line and HTTP header fields using the Writer's internal writer.
 // Must be called after WriteStatusLine and before WriteBody.
+// Header hooks run first and may modify a copy of the headers or the status code.
 // Each header follows RFC 9112 Section 3.2 format: field-name ":" field-value CRLF
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
+func (w *Writer) WriteHeaders(h headers.Headers) error {
 	if w.state != stateStatusWritten {
 		return fmt.Errorf("WriteHeaders called out of order - must be called after WriteStatusLine")
 	}
 
-	for key, value := range headers {
+	final := headers.NewHeaders()
+	for key, value := range h {
+		final[key] = value
+	}
+	// Hooks installed last belong to the innermost middleware and run first
+	for i := len(w.hooks) - 1; i >= 0; i-- {
+		w.statusCode = w.hooks[i](w.statusCode, final)
+	}
+
+	transferEncoding, _ := final.Get("Transfer-Encoding")
+	w.chunked = strings.Contains(strings.ToLower(transferEncoding), "chunked")
+	w.noBody = w.head ||
+		(w.statusCode >= 100 && w.statusCode < 200) ||
+		w.statusCode == StatusNoContent ||
+		w.statusCode == StatusNotModified
+
+	// The reason phrase is optional but the SP before it is not
+	_, err := fmt.Fprintf(w.writer, "HTTP/1.1 %d %s\r\n", w.statusCode, StatusText(w.statusCode))
+	if err != nil {
+		return err
+	}
+
+	for key, value := range final {
 		_, err := fmt.Fprintf(w.writer, "%s: %s\r\n", key, value)
 		if err != nil {
 			return err
 		}
 	}
+	for _, line := range w.lines {
+		_, err := fmt.Fprintf(w.writer, "%s: %s\r\n", strings.ToLower(line.Name), line.Value)
+		if err != nil {
+			return err
+		}
+	}
+	// Set-Cookie cannot be combined into one field, so each cookie gets its own line (RFC 6265 Section 3)
+	for _, c := range w.cookies {
+		_, err := fmt.Fprintf(w.writer, "set-cookie: %s\r\n", c)
+		if err != nil {
+			return err
+		}
+	}
 	// Empty line marks end of headers section (RFC 9112 Section 3)
-	_, err := fmt.Fprintf(w.writer, "\r\n")
+	_, err = fmt.Fprintf(w.writer, "\r\n")
 
 	if err == nil {
 		w.state = stateHeadersWritten
@@ -95,15 +205,65 @@ func (w *Writer) WriteHeaders(headers headers.Headers) error {
 	return err
 }
 
+// SetCookie adds a Set-Cookie header line to the response. It must be called before
+// WriteHeaders and returns an error for cookies that cannot be serialised.
+func (w *Writer) SetCookie(c *cookie.Cookie) error {
+	if w.state != stateInit && w.state != stateStatusWritten {
+		return fmt.Errorf("SetCookie called out of order - must be called before WriteHeaders")
+	}
+	if err := c.Valid(); err != nil {
+		return err
+	}
+
+	w.cookies = append(w.cookies, c)
+	return nil
+}
+
+// Cookies returns the cookies set on the response so far.
+func (w *Writer) Cookies() []*cookie.Cookie {
+	return w.cookies
+}
+
+// HeaderLine is a header field written on its own line, exactly as given.
+type HeaderLine struct {
+	Name  string
+	Value string
+}
+
+// AddHeaderLine adds a header field that is written on its own line after the headers passed
+// to WriteHeaders, for fields that must not be combined, such as Set-Cookie lines relayed
+// from an upstream byte for byte. It must be called before WriteHeaders; header hooks do not
+// see these lines. Names must be tokens and values must not contain CR, LF or NUL.
+func (w *Writer) AddHeaderLine(name, value string) error {
+	if w.state != stateInit && w.state != stateStatusWritten {
+		return fmt.Errorf("AddHeaderLine called out of order - must be called before WriteHeaders")
+	}
+	if name == "" || strings.ContainsAny(name, " \t:\r\n\x00") {
+		return fmt.Errorf("invalid header name %q", name)
+	}
+	if strings.ContainsAny(value, "\r\n\x00") {
+		return fmt.Errorf("invalid value for header %q", name)
+	}
+
+	w.lines = append(w.lines, HeaderLine{Name: name, Value: value})
+	return nil
+}
+
+// HeaderLines returns the lines added with AddHeaderLine so far.
+func (w *Writer) HeaderLines() []HeaderLine {
+	return w.lines
+}
+
 // WriteBody writes raw []byte data to the response body using the Writer's internal writer.
 // Must be called after WriteHeaders. Can be called multiple times.
-// Returns the number of bytes written and any error encountered.
+// If the final headers selected chunked encoding (e.g. a middleware compresses the body),
+// the data is framed as chunks. Returns the number of bytes written and any error encountered.
 func (w *Writer) WriteBody(p []byte) (int, error) {
 	if w.state != stateHeadersWritten && w.state != stateBodyWritten {
 		return 0, fmt.Errorf("WriteBody called out of order - must be called after WriteHeaders")
 	}
 
-	n, err := w.writer.Write(p)
+	n, err := w.body.Write(p)
 	if err == nil {
 		w.state = stateBodyWritten
 	}
@@ -112,27 +272,13 @@ func (w *Writer) WriteBody(p []byte) (int, error) {
 
 // WriteChunkedBody writes data as a chunked transfer encoding chunk.
 // Must be called after WriteHeaders. Format: [hex-size]\r\n[data]\r\n
-// Each call writes one complete chunk. Use WriteChunkedBodyDone() to finish.
+// Each call writes one complete chunk (empty data writes nothing). Use WriteChunkedBodyDone() to finish.
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 	if w.state != stateHeadersWritten && w.state != stateChunkedWriting {
 		return 0, fmt.Errorf("WriteChunkedBody called out of order - must be called after WriteHeaders")
 	}
 
-	// Write chunk size in hexadecimal + CRLF
-	chunkSize := len(p)
-	_, err := fmt.Fprintf(w.writer, "%x\r\n", chunkSize)
-	if err != nil {
-		return 0, err
-	}
-
-	// Write chunk data
-	n, err := w.writer.Write(p)
-	if err != nil {
-		return n, err
-	}
-
-	// Write trailing CRLF after chunk data
-	_, err = fmt.Fprintf(w.writer, "\r\n")
+	n, err := w.body.Write(p)
 	if err != nil {
 		return n, err
 	}
@@ -143,14 +289,24 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 
 // WriteChunkedBodyDone writes the final chunk terminator for chunked encoding.
 // Writes "0\r\n" to signal end of chunked response per RFC 9112 Section 7.1.3.
-// Must be called after WriteChunkedBody. Use WriteTrailers() for trailer headers before final CRLF.
+// Must be called after WriteChunkedBody, or directly after WriteHeaders for an empty body.
+// Use WriteTrailers() for trailer headers before final CRLF.
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
-	if w.state != stateChunkedWriting {
+	if w.state != stateChunkedWriting && w.state != stateHeadersWritten {
 		return 0, fmt.Errorf("WriteChunkedBodyDone called out of order - must be called after WriteChunkedBody")
 	}
 
-	// Write final chunk: size 0 + CRLF (trailers can follow before final CRLF)
-	n, err := fmt.Fprintf(w.writer, "0\r\n")
+	// Body filters may still hold buffered data that has to go out before the last chunk
+	if err := w.closeFilters(); err != nil {
+		return 0, err
+	}
+
+	var n int
+	var err error
+	if w.chunked && !w.noBody {
+		// Write final chunk: size 0 + CRLF (trailers can follow before final CRLF)
+		n, err = fmt.Fprintf(w.writer, "0\r\n")
+	}
 	if err == nil {
 		w.state = stateChunkedDone
 	}
@@ -160,15 +316,26 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 // WriteTrailers writes HTTP trailer headers after chunked body completion.
 // Must be called after WriteChunkedBodyDone and before WriteTrailersDone.
 // Trailers are optional metadata headers that follow the final chunk per RFC 9112 Section 7.1.2.
+// Trailer hooks run first and may add fields to a copy of h.
 func (w *Writer) WriteTrailers(h headers.Headers) error {
 	if w.state != stateChunkedDone {
 		return fmt.Errorf("WriteTrailers called out of order - must be called after WriteChunkedBodyDone")
 	}
 
+	final := headers.NewHeaders()
 	for key, value := range h {
-		_, err := fmt.Fprintf(w.writer, "%s: %s\r\n", key, value)
-		if err != nil {
-			return fmt.Errorf("error writing trailers: %v", err)
+		final[key] = value
+	}
+	for i := len(w.trailerHooks) - 1; i >= 0; i-- {
+		w.trailerHooks[i](final)
+	}
+
+	if w.chunked && !w.noBody {
+		for key, value := range final {
+			_, err := fmt.Fprintf(w.writer, "%s: %s\r\n", key, value)
+			if err != nil {
+				return fmt.Errorf("error writing trailers: %v", err)
+			}
 		}
 	}
 
@@ -182,11 +349,112 @@ func (w *Writer) WriteTrailersDone() error {
 	if w.state != stateTrailersWritten && w.state != stateChunkedDone {
 		return fmt.Errorf("WriteTrailersDone called out of order - must be called after WriteTrailers or WriteChunkedBodyDone")
 	}
+	// Trailer hooks still get their turn when the handler sent no trailers
+	if w.state == stateChunkedDone && len(w.trailerHooks) > 0 {
+		if err := w.WriteTrailers(nil); err != nil {
+			return err
+		}
+	}
 
-	_, err := fmt.Fprintf(w.writer, "\r\n")
-	if err != nil {
-		return fmt.Errorf("error writing trailers ending: %v", err)
+	if w.chunked && !w.noBody {
+		_, err := fmt.Fprintf(w.writer, "\r\n")
+		if err != nil {
+			return fmt.Errorf("error writing trailers ending: %v", err)
+		}
 	}
 
+	w.state = stateDone
 	return nil
 }
+
+// Close completes the response by finishing whatever the handler left open: headers that were
+// never sent, buffered data in body filters, and the last chunk and final CRLF of a chunked body.
+// The server calls it after every handler; calling it again, or after WriteTrailersDone, does nothing.
+func (w *Writer) Close() error {
+	switch w.state {
+	case stateInit, stateDone, stateHijacked:
+		return nil
+	case stateStatusWritten:
+		if err := w.WriteHeaders(nil); err != nil {
+			return err
+		}
+	}
+
+	if w.state == stateHeadersWritten || w.state == stateBodyWritten || w.state == stateChunkedWriting {
+		if err := w.closeFilters(); err != nil {
+			return err
+		}
+		if w.chunked && !w.noBody {
+			if _, err := fmt.Fprintf(w.writer, "0\r\n"); err != nil {
+				return err
+			}
+		}
+		w.state = stateChunkedDone
+	}
+
+	return w.WriteTrailersDone()
+}
+
+// Abort marks the response as cut short, e.g. because its source failed mid-body. Nothing more
+// is written: Close leaves a chunked body without its last chunk and a fixed-length body short,
+// so the client can tell the response is incomplete when the server closes the connection.
+func (w *Writer) Abort() {
+	if w.state == stateHijacked {
+		return
+	}
+	w.aborted = true
+	w.state = stateDone
+}
+
+// Aborted reports whether Abort was called, so middleware can avoid keeping a partial response.
+func (w *Writer) Aborted() bool {
+	return w.aborted
+}
+
+// StatusCode returns the status code of the response, after any header hooks have run.
+// It is zero until WriteStatusLine is called.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// BytesWritten returns how many body bytes have been sent so far. It counts what went out on
+// the wire after body filters, so a compressed response reports its compressed size, and it
+// leaves out chunk framing and trailers.
+func (w *Writer) BytesWritten() int64 {
+	return w.bodyBytes
+}
+
+// ErrHijackUnsupported is returned by Hijack when the Writer is not backed by a connection.
+var ErrHijackUnsupported = errors.New("response writer does not support hijacking")
+
+// SetHijacker installs the function Hijack uses to hand over the underlying connection.
+// The server sets this so it can stop its own use of the connection first.
+func (w *Writer) SetHijacker(hijacker func() (net.Conn, error)) {
+	w.hijacker = hijacker
+}
+
+// Hijack lets a handler take over the underlying connection, e.g. to tunnel a CONNECT request.
+// Anything already written (such as a status line and headers) has been sent. Afterwards the
+// Writer can no longer be used and the server neither writes to nor closes the connection;
+// the caller owns it and must close it.
+func (w *Writer) Hijack() (net.Conn, error) {
+	if w.state == stateHijacked {
+		return nil, fmt.Errorf("connection already hijacked")
+	}
+	if w.hijacker == nil {
+		return nil, ErrHijackUnsupported
+	}
+
+	conn, err := w.hijacker()
+	if err != nil {
+		return nil, err
+	}
+
+	w.state = stateHijacked
+	return conn, nil
+}
+
+// Hijacked reports whether Hijack has handed the connection to the handler.
+func (w *Writer) Hijacked() bool {
+	return w.state == stateHijacked
+}
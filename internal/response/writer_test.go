@@ -0,0 +1,184 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/kiefbc/http-server-1.1/internal/cookie"
+	"github.com/kiefbc/http-server-1.1/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// upperFilter upper-cases body bytes and records when it is closed.
+type upperFilter struct {
+	next   io.Writer
+	closed bool
+}
+
+func (f *upperFilter) Write(p []byte) (int, error) {
+	return f.next.Write(bytes.ToUpper(p))
+}
+
+func (f *upperFilter) Close() error {
+	f.closed = true
+	_, err := f.next.Write([]byte("!"))
+	return err
+}
+
+func TestWriter(t *testing.T) {
+	// Test: Identity response is written in order
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(headers.Headers{"content-length": "5"}))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ncontent-length: 5\r\n\r\nhello", out.String())
+	assert.Equal(t, int64(5), w.BytesWritten())
+
+	// Test: Calls out of order are refused
+	w = NewWriter(&bytes.Buffer{})
+	assert.Error(t, w.WriteHeaders(nil))
+	_, err = w.WriteBody([]byte("x"))
+	assert.Error(t, err)
+
+	// Test: Close sends headers that were never written and is idempotent
+	out.Reset()
+	w = NewWriter(&out)
+	w.WriteStatusLine(StatusNoContent)
+	require.NoError(t, w.Close())
+	require.NoError(t, w.Close())
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\n\r\n", out.String())
+
+	// Test: Chunked bodies are framed and terminated by Close
+	out.Reset()
+	w = NewWriter(&out)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(headers.Headers{"transfer-encoding": "chunked"})
+	w.WriteChunkedBody([]byte("abc"))
+	w.WriteChunkedBody(nil)
+	require.NoError(t, w.Close())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ntransfer-encoding: chunked\r\n\r\n3\r\nabc\r\n0\r\n\r\n", out.String())
+	// Chunk framing is not counted
+	assert.Equal(t, int64(3), w.BytesWritten())
+
+	// Test: An aborted chunked body is never terminated
+	out.Reset()
+	w = NewWriter(&out)
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(headers.Headers{"transfer-encoding": "chunked"})
+	w.WriteChunkedBody([]byte("abc"))
+	w.Abort()
+	require.NoError(t, w.Close())
+	assert.True(t, w.Aborted())
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ntransfer-encoding: chunked\r\n\r\n3\r\nabc\r\n", out.String())
+	_, err = w.WriteChunkedBody([]byte("more"))
+	assert.Error(t, err)
+}
+
+func TestWriterCookies(t *testing.T) {
+	// Test: Each cookie is written on its own Set-Cookie line
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "a", Value: "1", Path: "/"}))
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "b", Value: "2", HttpOnly: true}))
+	w.WriteStatusLine(StatusNoContent)
+	w.WriteHeaders(nil)
+	w.Close()
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\nset-cookie: a=1; Path=/\r\nset-cookie: b=2; HttpOnly\r\n\r\n", out.String())
+	assert.Len(t, w.Cookies(), 2)
+
+	// Test: Invalid cookies and cookies after the headers are refused
+	assert.Error(t, NewWriter(&bytes.Buffer{}).SetCookie(&cookie.Cookie{Name: "bad name"}))
+	assert.Error(t, w.SetCookie(&cookie.Cookie{Name: "late", Value: "x"}))
+
+	// Test: Header lines are written verbatim, each on its own line
+	out.Reset()
+	w = NewWriter(&out)
+	require.NoError(t, w.AddHeaderLine("Set-Cookie", "a=1; expires=Wed, 02 Jan 2030 03:04:05 GMT; secure"))
+	require.NoError(t, w.AddHeaderLine("Set-Cookie", "odd value"))
+	w.WriteStatusLine(StatusNoContent)
+	w.WriteHeaders(nil)
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\nset-cookie: a=1; expires=Wed, 02 Jan 2030 03:04:05 GMT; secure\r\nset-cookie: odd value\r\n\r\n", out.String())
+	assert.Len(t, w.HeaderLines(), 2)
+	assert.Error(t, w.AddHeaderLine("Set-Cookie", "late"))
+	assert.Error(t, NewWriter(&bytes.Buffer{}).AddHeaderLine("Set-Cookie", "a=1\r\nInjected: yes"))
+	assert.Error(t, NewWriter(&bytes.Buffer{}).AddHeaderLine("Bad Name", "x"))
+}
+
+func TestWriterHooks(t *testing.T) {
+	// Test: Hooks can rewrite status and headers, innermost first
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	var order []string
+	w.OnWriteHeaders(func(status StatusCode, h headers.Headers) StatusCode {
+		order = append(order, "outer")
+		assert.Equal(t, "inner", h["x-seen"])
+		return status
+	})
+	w.OnWriteHeaders(func(status StatusCode, h headers.Headers) StatusCode {
+		order = append(order, "inner")
+		h.Replace("x-seen", "inner")
+		return StatusNotModified
+	})
+	original := headers.Headers{"content-length": "5"}
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(original)
+	w.WriteBody([]byte("hello"))
+	w.Close()
+	assert.Equal(t, []string{"inner", "outer"}, order)
+	assert.Equal(t, StatusNotModified, w.StatusCode())
+	assert.NotContains(t, original, "x-seen")
+	// 304 has no body even though the handler wrote one
+	assert.True(t, strings.HasPrefix(out.String(), "HTTP/1.1 304 Not Modified\r\n"))
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\n"))
+	assert.NotContains(t, out.String(), "hello")
+	assert.Equal(t, int64(0), w.BytesWritten())
+
+	// Test: A hook that switches to chunked makes WriteBody produce chunks through filters
+	out.Reset()
+	w = NewWriter(&out)
+	filter := &upperFilter{}
+	w.WrapBody(func(next io.Writer) io.Writer {
+		filter.next = next
+		return filter
+	})
+	w.OnWriteHeaders(func(status StatusCode, h headers.Headers) StatusCode {
+		delete(h, "content-length")
+		h.Replace("transfer-encoding", "chunked")
+		return status
+	})
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(headers.Headers{"content-length": "5"})
+	w.WriteBody([]byte("hello"))
+	require.NoError(t, w.Close())
+	assert.True(t, filter.closed)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\ntransfer-encoding: chunked\r\n\r\n5\r\nHELLO\r\n1\r\n!\r\n0\r\n\r\n", out.String())
+	// Filtered output is what counts
+	assert.Equal(t, int64(6), w.BytesWritten())
+
+	// Test: Trailer hooks add to the handler's trailers, or send their own when it has none
+	out.Reset()
+	w = NewWriter(&out)
+	w.OnWriteTrailers(func(h headers.Headers) { h.Replace("x-sum", "abc") })
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(headers.Headers{"transfer-encoding": "chunked"})
+	w.WriteChunkedBody([]byte("hi"))
+	require.NoError(t, w.Close())
+	assert.True(t, strings.HasSuffix(out.String(), "2\r\nhi\r\n0\r\nx-sum: abc\r\n\r\n"), out.String())
+	out.Reset()
+	w = NewWriter(&out)
+	w.OnWriteTrailers(func(h headers.Headers) { h.Replace("x-sum", "abc") })
+	w.WriteStatusLine(StatusOK)
+	w.WriteHeaders(headers.Headers{"transfer-encoding": "chunked"})
+	w.WriteChunkedBodyDone()
+	require.NoError(t, w.WriteTrailers(headers.Headers{"x-own": "1"}))
+	require.NoError(t, w.WriteTrailersDone())
+	assert.Contains(t, out.String(), "x-sum: abc\r\n")
+	assert.Contains(t, out.String(), "x-own: 1\r\n")
+	assert.Equal(t, 1, strings.Count(out.String(), "x-sum"))
+}
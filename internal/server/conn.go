@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxWatcherBuffer caps how much early client data the watcher reads ahead for a hijacker.
+const maxWatcherBuffer = 64 * 1024
+
+// disconnectWatcher reads from a connection after its request has been parsed so that a
+// client hanging up cancels the request context. Anything the client sends meanwhile is
+// kept, so a handler that hijacks the connection does not lose it.
+type disconnectWatcher struct {
+	conn   net.Conn
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu       sync.Mutex
+	stopping bool
+	buffered []byte
+}
+
+func newDisconnectWatcher(conn net.Conn, cancel context.CancelFunc) *disconnectWatcher {
+	return &disconnectWatcher{
+		conn:   conn,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+}
+
+// run reads until the connection fails. A read error means the peer went away (EOF or
+// reset) or handle closed the connection on its way out, unless stop caused it.
+// Once maxWatcherBuffer bytes are held it stops reading, leaving the rest in the socket, so
+// nothing is dropped; disconnects are then only noticed when the response is written.
+func (dw *disconnectWatcher) run() {
+	defer close(dw.done)
+
+	buf := make([]byte, 512)
+	for {
+		n, err := dw.conn.Read(buf)
+
+		dw.mu.Lock()
+		dw.buffered = append(dw.buffered, buf[:n]...)
+		full := len(dw.buffered) >= maxWatcherBuffer
+		stopping := dw.stopping
+		dw.mu.Unlock()
+
+		if err != nil {
+			if !stopping {
+				dw.cancel()
+			}
+			return
+		}
+		if full {
+			return
+		}
+	}
+}
+
+// stop interrupts the pending read and waits for run to exit. It returns conn, the
+// connection being watched or the one it wraps, so that early, the bytes the request parser
+// read past the request, and then the bytes the watcher already consumed are read first.
+func (dw *disconnectWatcher) stop(conn net.Conn, early []byte) net.Conn {
+	dw.mu.Lock()
+	dw.stopping = true
+	dw.mu.Unlock()
+
+	// A deadline in the past unblocks the Read without closing the connection
+	dw.conn.SetReadDeadline(time.Unix(1, 0))
+	<-dw.done
+	dw.conn.SetReadDeadline(time.Time{})
+
+	pending := append(append([]byte(nil), early...), dw.buffered...)
+	if len(pending) == 0 {
+		return conn
+	}
+	return &bufferedConn{Conn: conn, pending: pending}
+}
+
+// bufferedConn replays pending before reading from the wrapped connection.
+type bufferedConn struct {
+	net.Conn
+	pending []byte
+}
+
+func (bc *bufferedConn) Read(p []byte) (int, error) {
+	if len(bc.pending) > 0 {
+		n := copy(p, bc.pending)
+		bc.pending = bc.pending[n:]
+		return n, nil
+	}
+	return bc.Conn.Read(p)
+}
+
+// CloseWrite half-closes the wrapped connection when it supports it.
+func (bc *bufferedConn) CloseWrite() error {
+	if cw, ok := bc.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return bc.Conn.Close()
+}
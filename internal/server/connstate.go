@@ -0,0 +1,132 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnState is a stage in the life of a client connection, reported to the WithConnState hook.
+// The server answers one request per connection, so a connection moves
+// New -> Active -> Idle -> Closed, or ends in Hijacked when a handler takes it over.
+type ConnState int
+
+const (
+	// StateNew is a connection that has been accepted but has not sent anything yet.
+	StateNew ConnState = iota
+	// StateActive is a connection that has sent at least one byte of a request. It stays
+	// active while the request is read and handled.
+	StateActive
+	// StateIdle is a connection whose response is complete. It is about to be closed once
+	// the client has had a chance to read the response.
+	StateIdle
+	// StateHijacked is a connection a handler took over with Hijack. It is terminal: the
+	// server no longer tracks the connection and will not report StateClosed.
+	StateHijacked
+	// StateClosed is a connection the server has closed. It is terminal.
+	StateClosed
+)
+
+var connStateNames = map[ConnState]string{
+	StateNew:      "new",
+	StateActive:   "active",
+	StateIdle:     "idle",
+	StateHijacked: "hijacked",
+	StateClosed:   "closed",
+}
+
+func (s ConnState) String() string {
+	return connStateNames[s]
+}
+
+// ConnStats describes a connection so far. The stats passed with StateClosed or
+// StateHijacked are final.
+type ConnStats struct {
+	// Requests is how many requests were answered on the connection, not counting ones
+	// rejected because they could not be parsed.
+	Requests int
+	// BytesRead and BytesWritten count raw bytes on the connection, headers included.
+	BytesRead    int64
+	BytesWritten int64
+	// Duration is the time since the connection was accepted.
+	Duration time.Duration
+}
+
+// WithConnState calls hook each time a connection changes state, with the connection as
+// accepted and its stats. The hook runs on the connection's goroutine, so it should be quick.
+func WithConnState(hook func(conn net.Conn, state ConnState, stats ConnStats)) Option {
+	return func(s *Server) {
+		s.connState = hook
+	}
+}
+
+// trackedConn counts the bytes crossing a connection and reports its state changes. The
+// server does all connection I/O through it, but hands the accepted connection to
+// WithConnContext and to hijackers so their type assertions still work.
+type trackedConn struct {
+	net.Conn
+	hook     func(conn net.Conn, state ConnState, stats ConnStats)
+	accepted time.Time
+
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+	requests     atomic.Int64
+	firstRead    sync.Once
+
+	mu    sync.Mutex
+	state ConnState
+}
+
+func newTrackedConn(conn net.Conn, hook func(conn net.Conn, state ConnState, stats ConnStats)) *trackedConn {
+	tc := &trackedConn{Conn: conn, hook: hook, accepted: time.Now()}
+	tc.setState(StateNew)
+	return tc
+}
+
+func (tc *trackedConn) Read(p []byte) (int, error) {
+	n, err := tc.Conn.Read(p)
+	if n > 0 {
+		tc.bytesRead.Add(int64(n))
+		tc.firstRead.Do(func() { tc.setState(StateActive) })
+	}
+	return n, err
+}
+
+func (tc *trackedConn) Write(p []byte) (int, error) {
+	n, err := tc.Conn.Write(p)
+	tc.bytesWritten.Add(int64(n))
+	return n, err
+}
+
+// CloseWrite half-closes the wrapped connection when it supports it.
+func (tc *trackedConn) CloseWrite() error {
+	if cw, ok := tc.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// setState moves the connection to state and reports it. Nothing follows a terminal state.
+func (tc *trackedConn) setState(state ConnState) {
+	tc.mu.Lock()
+	if tc.state == StateHijacked || tc.state == StateClosed {
+		tc.mu.Unlock()
+		return
+	}
+	tc.state = state
+	tc.mu.Unlock()
+
+	if tc.hook != nil {
+		tc.hook(tc.Conn, state, tc.stats())
+	}
+}
+
+func (tc *trackedConn) stats() ConnStats {
+	return ConnStats{
+		Requests:     int(tc.requests.Load()),
+		BytesRead:    tc.bytesRead.Load(),
+		BytesWritten: tc.bytesWritten.Load(),
+		Duration:     time.Since(tc.accepted),
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/headers"
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+)
+
+// WithMaxConnections caps how many connections are served at once. At the cap the server
+// stops accepting, so further clients wait in the kernel's listen backlog instead of costing
+// a goroutine each. n <= 0 means no limit.
+func WithMaxConnections(n int) Option {
+	return func(s *Server) {
+		if n <= 0 {
+			s.connSlots = nil
+			return
+		}
+		s.connSlots = make(chan struct{}, n)
+	}
+}
+
+// WithMaxConnectionsPerIP caps the concurrent connections from one client IP. Connections over
+// the cap get 503 Service Unavailable without reaching the handler. n <= 0 means no limit.
+func WithMaxConnectionsPerIP(n int) Option {
+	return func(s *Server) {
+		if n <= 0 {
+			s.perIP = nil
+			return
+		}
+		s.perIP = &ipCounter{max: n, counts: make(map[string]int)}
+	}
+}
+
+// WithLoadShedding answers 503 Service Unavailable with Retry-After instead of calling the
+// handler while more than maxInFlight requests are already being handled.
+func WithLoadShedding(maxInFlight int, retryAfter time.Duration) Option {
+	return func(s *Server) {
+		s.maxInFlight = int64(maxInFlight)
+		s.shedRetryAfter = retryAfter
+	}
+}
+
+// shed counts a request as in flight, or reports true without counting it when the server
+// is already at the load-shedding threshold.
+func (s *Server) shed() bool {
+	if s.inFlight.Add(1) > s.maxInFlight && s.maxInFlight > 0 {
+		s.inFlight.Add(-1)
+		return true
+	}
+	return false
+}
+
+// Accept backoff after errors such as running out of file descriptors, like net/http.
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+)
+
+// ipCounter counts open connections per client IP.
+type ipCounter struct {
+	max int
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// acquire reserves a connection for ip, reporting false if ip is at the cap.
+func (c *ipCounter) acquire(ip string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[ip] >= c.max {
+		return false
+	}
+	c.counts[ip]++
+	return true
+}
+
+func (c *ipCounter) release(ip string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[ip]--; c.counts[ip] <= 0 {
+		delete(c.counts, ip)
+	}
+}
+
+// remoteIP returns the IP part of the connection's remote address.
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// refuseReadTimeout bounds how long a refused client may take to send its request, unless
+// WithHeaderReadTimeout sets a bound for every client.
+const refuseReadTimeout = time.Second
+
+// refuse answers conn with 503 and closes it. The request is read first: closing a socket
+// with unread data makes the kernel reset the connection, and the client would never see
+// the response.
+func (s *Server) refuse(conn net.Conn) {
+	defer conn.Close()
+	timeout := refuseReadTimeout
+	if s.headerTimeout > 0 {
+		timeout = s.headerTimeout
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	request.RequestFromReader(conn)
+
+	w := response.NewWriter(conn)
+	writeUnavailable(w, 0, NewRequestID())
+	w.Close()
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+}
+
+// writeUnavailable sends 503 Service Unavailable carrying requestID, with Retry-After when
+// retryAfter is set (RFC 9110 Section 10.2.3).
+func writeUnavailable(w *response.Writer, retryAfter time.Duration, requestID string) {
+	if retryAfter > 0 {
+		w.OnWriteHeaders(func(status response.StatusCode, h headers.Headers) response.StatusCode {
+			h.Replace("retry-after", fmt.Sprintf("%d", int64(math.Ceil(retryAfter.Seconds()))))
+			return status
+		})
+	}
+	(&HandlerError{StatusCode: response.StatusServiceUnavailable, Message: "503 Service Unavailable", RequestID: requestID}).Write(w)
+}
@@ -1,32 +1,150 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
+	"os"
 	"sync/atomic"
+	"time"
 
 	"github.com/kiefbc/http-server-1.1/internal/request"
 	"github.com/kiefbc/http-server-1.1/internal/response"
 )
 
 type Server struct {
-	listener net.Listener
-	isClosed atomic.Bool
-	handler  Handler
+	listener       net.Listener
+	isClosed       atomic.Bool
+	handler        Handler
+	ctx            context.Context
+	cancel         context.CancelFunc
+	requestTimeout time.Duration
+	headerTimeout  time.Duration
+	connContext    func(ctx context.Context, conn net.Conn) context.Context
+	observer       Observer
+	connState      func(conn net.Conn, state ConnState, stats ConnStats)
+
+	connSlots      chan struct{} // nil means no connection limit
+	perIP          *ipCounter    // nil means no per-IP limit
+	maxInFlight    int64         // 0 means no load shedding
+	shedRetryAfter time.Duration
+	inFlight       atomic.Int64
+}
+
+// Option configures optional Server behaviour in Serve.
+type Option func(*Server)
+
+// WithListener wraps the server's TCP listener, e.g. to decode a PROXY protocol header.
+// Connections the wrapped listener returns are served like any other.
+func WithListener(wrap func(net.Listener) net.Listener) Option {
+	return func(s *Server) {
+		s.listener = wrap(s.listener)
+	}
+}
+
+// WithConnContext derives the context of every request on a connection, so listener
+// wrappers can pass per-connection data to handlers.
+func WithConnContext(f func(ctx context.Context, conn net.Conn) context.Context) Option {
+	return func(s *Server) {
+		s.connContext = f
+	}
+}
+
+// Observer is told about events that happen outside any handler, such as connections
+// opening and requests that cannot be parsed. It must be safe for concurrent use.
+type Observer interface {
+	ConnOpened()
+	ConnClosed()
+	// ParseError receives the error of a request answered with 400 Bad Request before
+	// reaching the handler; see request.ParseError.
+	ParseError(err error)
+}
+
+// WithObserver reports server events to o, e.g. for metrics.
+func WithObserver(o Observer) Option {
+	return func(s *Server) {
+		s.observer = o
+	}
+}
+
+// WithRequestTimeout bounds how long a single request may run. When the deadline
+// passes the request's context is cancelled; handlers are expected to notice and stop.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.requestTimeout = d
+	}
+}
+
+// WithHeaderReadTimeout bounds how long a client may take to send its request once it has
+// connected. The parser reads the request line, headers and body together, so the bound
+// covers all three; clients that miss it get 408 Request Timeout. Refused connections use it
+// too, in place of their default.
+func WithHeaderReadTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.headerTimeout = d
+	}
 }
 
 type HandlerError struct {
 	StatusCode response.StatusCode
 	Message    string
+	// RequestID, when set, is sent as X-Request-ID and appended to the body so clients can
+	// quote it when reporting errors.
+	RequestID string
+}
+
+// NewRequestID returns a random 128-bit request ID in hex. The server gives one to the
+// responses it writes without calling the handler, such as 400 for unparseable requests.
+func NewRequestID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
 }
 
 type Handler func(w *response.Writer, req *request.Request) *HandlerError
 
+// Middleware wraps a Handler with extra behaviour such as caching or compression.
+type Middleware func(next Handler) Handler
+
+// Chain wraps h with the given middleware. The first middleware is the outermost,
+// so Chain(h, a, b) runs a, then b, then h.
+//
+// Middleware that reports on the finished response calls Finish, so it must come first, in
+// this order: access logging, metrics, tracing. Middleware that shapes the response or its
+// errors, such as request IDs, compression and caching, comes after them.
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// Finish completes a response the way the server does after the handler returns: it writes
+// handlerErr unless the connection was hijacked, then closes w. Middleware that needs the
+// final status and size calls it and still returns handlerErr, so middleware outside it sees
+// the error; calling Finish again does nothing.
+func Finish(w *response.Writer, handlerErr *HandlerError) {
+	if handlerErr != nil && !w.Hijacked() {
+		handlerErr.Write(w)
+	}
+	w.Close()
+}
+
 // Write writes a complete HTTP error response using the response.Writer.
 // This includes the status line, headers, and message body formatted per RFC 9112.
 func (he *HandlerError) Write(w *response.Writer) {
-	messageBytes := []byte(he.Message)
+	message := he.Message
+	if he.RequestID != "" {
+		message += "\nRequest ID: " + he.RequestID
+	}
+	messageBytes := []byte(message)
 	headers := response.GetDefaultHeaders(len(messageBytes))
+	if he.RequestID != "" {
+		headers.Replace("x-request-id", he.RequestID)
+	}
 
 	w.WriteStatusLine(he.StatusCode)
 	w.WriteHeaders(headers)
@@ -35,15 +153,21 @@ func (he *HandlerError) Write(w *response.Writer) {
 
 // Serve creates a new HTTP server listening on the specified port and starts accepting connections.
 // The server runs in a separate goroutine and handles each connection concurrently.
-func Serve(port int, handler Handler) (*Server, error) {
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
 	listening, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, fmt.Errorf("failed to start server: %v", err)
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
 	server := &Server{
 		listener: listening,
 		handler:  handler,
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	for _, opt := range opts {
+		opt(server)
 	}
 
 	go server.listen()
@@ -51,58 +175,175 @@ func Serve(port int, handler Handler) (*Server, error) {
 	return server, nil
 }
 
+// Addr returns the address the server is listening on.
+// Useful when Serve was called with port 0 and the OS picked a free port.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
 // Close gracefully shuts down the server by closing the listener and setting the closed flag.
+// The contexts of all in-flight requests are cancelled.
 func (s *Server) Close() error {
+	s.isClosed.Store(true)
+	s.cancel()
+
 	closeErr := s.listener.Close()
 	if closeErr != nil {
 		return fmt.Errorf("failed to close server: %v", closeErr)
 	}
 
-	s.isClosed.Store(true)
 	return nil
 }
 
 // listen continuously accepts new connections until the server is closed.
 // Each accepted connection is handled concurrently in its own goroutine.
+// Accept errors other than the listener closing, e.g. running out of file descriptors,
+// are retried with exponential backoff rather than ending the server.
 func (s *Server) listen() {
+	backoff := time.Duration(0)
 	for !s.isClosed.Load() {
+		if s.connSlots != nil {
+			select {
+			case s.connSlots <- struct{}{}:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+
 		conn, err := s.listener.Accept()
 		if err != nil {
-			return
+			s.releaseSlot()
+			if s.isClosed.Load() || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			backoff = min(max(2*backoff, minAcceptBackoff), maxAcceptBackoff)
+			select {
+			case <-time.After(backoff):
+			case <-s.ctx.Done():
+				return
+			}
+			continue
 		}
+		backoff = 0
+
+		go s.serveConn(conn)
+	}
+}
+
+// releaseSlot frees the connection slot taken in listen. serveConn calls it exactly once per
+// connection.
+func (s *Server) releaseSlot() {
+	if s.connSlots != nil {
+		<-s.connSlots
+	}
+}
+
+// serveConn reports conn to the observer and applies the per-IP connection cap before
+// handling it.
+func (s *Server) serveConn(conn net.Conn) {
+	if s.observer != nil {
+		s.observer.ConnOpened()
+		defer s.observer.ConnClosed()
+	}
+	tc := newTrackedConn(conn, s.connState)
+	defer tc.setState(StateClosed)
 
-		go s.handle(conn)
+	if s.perIP != nil {
+		ip := remoteIP(conn)
+		if !s.perIP.acquire(ip) {
+			// A refused client must not hold up the clients waiting for a slot
+			s.releaseSlot()
+			s.refuse(tc)
+			return
+		}
+		defer s.perIP.release(ip)
 	}
+	defer s.releaseSlot()
+	s.handle(tc)
 }
 
 // handle processes a single HTTP connection by parsing the request and calling the provided handler.
 // The handler now has full control over the HTTP response via the response.Writer.
 // The response includes a status line with headers per RFC 9112 Section 3.
-func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
+func (s *Server) handle(conn *trackedConn) {
+	hijacked := false
+	defer func() {
+		if !hijacked {
+			conn.Close()
+		}
+	}()
 
+	if s.headerTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(s.headerTimeout))
+	}
 	req, err := request.RequestFromReader(conn)
+	conn.SetReadDeadline(time.Time{})
 	if err != nil {
+		if s.observer != nil {
+			s.observer.ParseError(err)
+		}
 		responseWriter := response.NewWriter(conn)
 		handlerErr := &HandlerError{
 			StatusCode: 400,
 			Message:    fmt.Sprintf("Bad Request: %v", err),
+			RequestID:  NewRequestID(),
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			handlerErr.StatusCode = response.StatusRequestTimeout
+			handlerErr.Message = "408 Request Timeout"
 		}
 
 		handlerErr.Write(responseWriter)
+		conn.setState(StateIdle)
 		return
 	}
 
+	ctx := s.ctx
+	if s.connContext != nil {
+		ctx = s.connContext(ctx, conn.Conn)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if s.requestTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, s.requestTimeout)
+		defer cancelTimeout()
+	}
+
+	// The request has been read in full, so anything the client does to the
+	// connection from here on is either a disconnect or data meant for a hijacker.
+	watcher := newDisconnectWatcher(conn, cancel)
+	go watcher.run()
+
+	req = req.WithContext(ctx)
+	req.RemoteAddr = conn.RemoteAddr().String()
+	req.LocalAddr = conn.LocalAddr().String()
 	responseWriter := response.NewWriter(conn)
-	handlerErr := s.handler(responseWriter, req)
-	if handlerErr != nil {
-		handlerErr.Write(responseWriter)
+	responseWriter.SetRequestMethod(req.RequestLine.Method)
+	responseWriter.SetHijacker(func() (net.Conn, error) {
+		hijacked = true
+		hijackedConn := watcher.stop(conn.Conn, req.Buffered())
+		conn.requests.Add(1)
+		conn.setState(StateHijacked)
+		return hijackedConn, nil
+	})
+
+	var handlerErr *HandlerError
+	if s.shed() {
+		writeUnavailable(responseWriter, s.shedRetryAfter, NewRequestID())
+	} else {
+		handlerErr = s.handler(responseWriter, req)
+		s.inFlight.Add(-1)
+	}
+	if responseWriter.Hijacked() {
 		return
 	}
+	// Finish anything the handler left open, e.g. the last chunk of a chunked body
+	Finish(responseWriter, handlerErr)
+	conn.requests.Add(1)
+	conn.setState(StateIdle)
 
 	// Give the client time to read the full response before closing
 	// This prevents "connection reset by peer" errors
-	if tcpConn, ok := conn.(*net.TCPConn); ok {
-		tcpConn.CloseWrite()
-	}
+	conn.CloseWrite()
 }
@@ -0,0 +1,394 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitForCancel is a handler that blocks until the request context is done
+// and reports the context error on the returned channel.
+func waitForCancel(started chan<- struct{}, result chan<- error) Handler {
+	return func(w *response.Writer, req *request.Request) *HandlerError {
+		close(started)
+		select {
+		case <-req.Context().Done():
+			result <- req.Context().Err()
+		case <-time.After(5 * time.Second):
+			result <- errors.New("context was never cancelled")
+		}
+		return nil
+	}
+}
+
+func dial(t *testing.T, s *Server) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	return conn
+}
+
+func TestRequestContext(t *testing.T) {
+	// Test: Client disconnect cancels the request context
+	started := make(chan struct{})
+	result := make(chan error, 1)
+	s, err := Serve(0, waitForCancel(started, result))
+	require.NoError(t, err)
+	conn := dial(t, s)
+	<-started
+	conn.Close()
+	assert.ErrorIs(t, <-result, context.Canceled)
+	s.Close()
+
+	// Test: Server shutdown cancels the request context
+	started = make(chan struct{})
+	result = make(chan error, 1)
+	s, err = Serve(0, waitForCancel(started, result))
+	require.NoError(t, err)
+	conn = dial(t, s)
+	defer conn.Close()
+	<-started
+	require.NoError(t, s.Close())
+	assert.ErrorIs(t, <-result, context.Canceled)
+
+	// Test: Request timeout expires the request context
+	started = make(chan struct{})
+	result = make(chan error, 1)
+	s, err = Serve(0, waitForCancel(started, result), WithRequestTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	defer s.Close()
+	conn = dial(t, s)
+	defer conn.Close()
+	<-started
+	assert.ErrorIs(t, <-result, context.DeadlineExceeded)
+}
+
+func TestHijackEarlyData(t *testing.T) {
+	// early is sent in the same write as the headers, then more than the watcher buffers
+	early := "early tunnel bytes"
+	late := strings.Repeat("x", maxWatcherBuffer*2)
+	release := make(chan struct{})
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) *HandlerError {
+		<-release
+		conn, err := w.Hijack()
+		require.NoError(t, err)
+		defer conn.Close()
+		data := make([]byte, len(early)+len(late))
+		_, err = io.ReadFull(conn, data)
+		require.NoError(t, err)
+		if string(data) == early+late {
+			conn.Write([]byte("intact"))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Bytes after the headers and past the watcher's cap all reach the hijacker in order
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n" + early))
+	require.NoError(t, err)
+	go conn.Write([]byte(late))
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, _ := io.ReadAll(conn)
+	assert.Equal(t, "intact", string(reply))
+}
+
+func TestFinish(t *testing.T) {
+	// Test: The error is written once however many layers finish the response
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	handlerErr := &HandlerError{StatusCode: response.StatusNotFound, Message: "Not Found"}
+	Finish(w, handlerErr)
+	Finish(w, handlerErr)
+	assert.Equal(t, 1, strings.Count(out.String(), "HTTP/1.1 404 Not Found\r\n"))
+	assert.True(t, strings.HasSuffix(out.String(), "\r\n\r\nNot Found"), out.String())
+
+	// Test: An error's request ID is sent as a header and in the body
+	out.Reset()
+	w = response.NewWriter(&out)
+	Finish(w, &HandlerError{StatusCode: response.StatusNotFound, Message: "Not Found", RequestID: "abc"})
+	assert.Contains(t, out.String(), "x-request-id: abc\r\n")
+	assert.True(t, strings.HasSuffix(out.String(), "Not Found\nRequest ID: abc"), out.String())
+}
+
+// blocking returns a handler that signals entry on started and answers 204 once release closes.
+func blocking(started chan<- struct{}, release <-chan struct{}) Handler {
+	return func(w *response.Writer, req *request.Request) *HandlerError {
+		started <- struct{}{}
+		<-release
+		w.WriteStatusLine(response.StatusNoContent)
+		w.WriteHeaders(nil)
+		return nil
+	}
+}
+
+// readStatus reads the status line of the response on conn.
+func readStatus(t *testing.T, conn net.Conn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	line, _, _ := strings.Cut(string(resp), "\r\n")
+	return line
+}
+
+func TestLimits(t *testing.T) {
+	// Test: Past the connection limit, clients wait until a slot frees up
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	s, err := Serve(0, blocking(started, release), WithMaxConnections(1))
+	require.NoError(t, err)
+	first := dial(t, s)
+	defer first.Close()
+	<-started
+	second := dial(t, s)
+	defer second.Close()
+	select {
+	case <-started:
+		t.Fatal("second connection served over the limit")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	assert.Equal(t, "HTTP/1.1 204 No Content", readStatus(t, first))
+	assert.Equal(t, "HTTP/1.1 204 No Content", readStatus(t, second))
+	s.Close()
+
+	// Test: Connections over the per-IP cap are refused with 503
+	started = make(chan struct{}, 2)
+	release = make(chan struct{})
+	s, err = Serve(0, blocking(started, release), WithMaxConnectionsPerIP(1))
+	require.NoError(t, err)
+	first = dial(t, s)
+	defer first.Close()
+	<-started
+	second = dial(t, s)
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := io.ReadAll(second)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(resp), "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.Regexp(t, "x-request-id: [0-9a-f]{32}\r\n", string(resp))
+	close(release)
+	assert.Equal(t, "HTTP/1.1 204 No Content", readStatus(t, first))
+	s.Close()
+
+	// Test: Requests over the in-flight threshold are shed with Retry-After
+	started = make(chan struct{}, 2)
+	release = make(chan struct{})
+	s, err = Serve(0, blocking(started, release), WithLoadShedding(1, 1500*time.Millisecond))
+	require.NoError(t, err)
+	defer s.Close()
+	first = dial(t, s)
+	defer first.Close()
+	<-started
+	second = dial(t, s)
+	defer second.Close()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err = io.ReadAll(second)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(resp), "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.Contains(t, string(resp), "retry-after: 2\r\n")
+	assert.Regexp(t, "x-request-id: ([0-9a-f]{32})\r\n(.|\n)*Request ID: [0-9a-f]{32}$", string(resp))
+	close(release)
+	assert.Equal(t, "HTTP/1.1 204 No Content", readStatus(t, first))
+}
+
+func TestHeaderReadTimeout(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+	s, err := Serve(0, blocking(started, release), WithHeaderReadTimeout(100*time.Millisecond))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: A client that stalls mid-request gets 408 and is disconnected
+	conn, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: loc"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 408 Request Timeout", readStatus(t, conn))
+
+	// Test: The timeout does not cut off a handler that runs longer than it
+	conn = dial(t, s)
+	defer conn.Close()
+	<-started
+	time.Sleep(200 * time.Millisecond)
+	release <- struct{}{}
+	assert.Equal(t, "HTTP/1.1 204 No Content", readStatus(t, conn))
+}
+
+func TestRefusalReleasesSlot(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	s, err := Serve(0, blocking(started, release), WithMaxConnections(2), WithMaxConnectionsPerIP(1), WithHeaderReadTimeout(3*time.Second))
+	require.NoError(t, err)
+	defer s.Close()
+	first := dial(t, s)
+	defer first.Close()
+	<-started
+
+	// Test: A refused client that stalls does not keep the next client waiting for a slot
+	stalled, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer stalled.Close()
+	time.Sleep(50 * time.Millisecond)
+	third := dial(t, s)
+	defer third.Close()
+	begin := time.Now()
+	assert.Equal(t, "HTTP/1.1 503 Service Unavailable", readStatus(t, third))
+	assert.Less(t, time.Since(begin), time.Second)
+	close(release)
+	assert.Equal(t, "HTTP/1.1 204 No Content", readStatus(t, first))
+}
+
+func TestNoLimits(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+
+	// Test: Zero and negative limits mean no limit rather than refusing or hanging
+	for _, n := range []int{0, -1} {
+		s, err := Serve(0, blocking(make(chan struct{}, 1), release), WithMaxConnections(n), WithMaxConnectionsPerIP(n))
+		require.NoError(t, err)
+		conn := dial(t, s)
+		assert.Equal(t, "HTTP/1.1 204 No Content", readStatus(t, conn), n)
+		conn.Close()
+		s.Close()
+	}
+}
+
+// flakyListener fails Accept a few times before handing out conns.
+type flakyListener struct {
+	net.Listener
+	failures atomic.Int32
+}
+
+func (fl *flakyListener) Accept() (net.Conn, error) {
+	if fl.failures.Add(-1) >= 0 {
+		return nil, errors.New("accept: too many open files")
+	}
+	return fl.Listener.Accept()
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	// Test: Accept errors are retried instead of stopping the server
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	fl := &flakyListener{Listener: inner}
+	fl.failures.Store(3)
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Server{listener: fl, ctx: ctx, cancel: cancel, handler: func(w *response.Writer, req *request.Request) *HandlerError {
+		w.WriteStatusLine(response.StatusNoContent)
+		w.WriteHeaders(nil)
+		return nil
+	}}
+	go s.listen()
+	defer s.Close()
+
+	conn := dial(t, s)
+	defer conn.Close()
+	assert.Equal(t, "HTTP/1.1 204 No Content", readStatus(t, conn))
+}
+
+// stateRecorder collects what a WithConnState hook reports and signals terminal states.
+type stateRecorder struct {
+	events chan connEvent
+}
+
+type connEvent struct {
+	state ConnState
+	stats ConnStats
+}
+
+// next returns the states reported up to and including the next terminal one.
+func (r *stateRecorder) next(t *testing.T) ([]ConnState, ConnStats) {
+	t.Helper()
+	var states []ConnState
+	for {
+		select {
+		case e := <-r.events:
+			states = append(states, e.state)
+			if e.state == StateClosed || e.state == StateHijacked {
+				return states, e.stats
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no terminal state after %v", states)
+		}
+	}
+}
+
+func TestConnState(t *testing.T) {
+	rec := &stateRecorder{events: make(chan connEvent, 16)}
+	hijack := func(w *response.Writer, req *request.Request) *HandlerError {
+		if req.RequestLine.RequestTarget == "/hijack" {
+			conn, err := w.Hijack()
+			require.NoError(t, err)
+			conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n\r\n"))
+			conn.Close()
+			return nil
+		}
+		w.WriteStatusLine(response.StatusNoContent)
+		w.WriteHeaders(nil)
+		return nil
+	}
+	s, err := Serve(0, hijack, WithConnState(func(conn net.Conn, state ConnState, stats ConnStats) {
+		assert.NotNil(t, conn.RemoteAddr())
+		rec.events <- connEvent{state, stats}
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+	send := func(raw string) string {
+		conn, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte(raw))
+		require.NoError(t, err)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		reply, _ := io.ReadAll(conn)
+		return string(reply)
+	}
+
+	// Test: A served connection goes new, active, idle, closed with its stats
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"
+	send(raw)
+	states, stats := rec.next(t)
+	assert.Equal(t, []ConnState{StateNew, StateActive, StateIdle, StateClosed}, states)
+	assert.Equal(t, 1, stats.Requests)
+	assert.Equal(t, int64(len(raw)), stats.BytesRead)
+	assert.Equal(t, int64(len("HTTP/1.1 204 No Content\r\n\r\n")), stats.BytesWritten)
+	assert.Positive(t, stats.Duration)
+
+	// Test: A hijacked connection ends in hijacked, with no closed state
+	send("GET /hijack HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	states, stats = rec.next(t)
+	assert.Equal(t, []ConnState{StateNew, StateActive, StateHijacked}, states)
+	assert.Equal(t, 1, stats.Requests)
+	assert.Zero(t, stats.BytesWritten)
+
+	// Test: Unparseable requests are not counted as served, and their 400 carries a request ID
+	reply := send("GET / HTTP/2.0\r\nHost: localhost\r\n\r\n")
+	assert.Regexp(t, "x-request-id: [0-9a-f]{32}\r\n", reply)
+	states, stats = rec.next(t)
+	assert.Equal(t, []ConnState{StateNew, StateActive, StateIdle, StateClosed}, states)
+	assert.Zero(t, stats.Requests)
+	assert.Positive(t, stats.BytesWritten)
+	assert.Equal(t, "hijacked", StateHijacked.String())
+}
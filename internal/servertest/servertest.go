@@ -0,0 +1,74 @@
+package servertest
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+)
+
+// NewRequest parses a request for target with the extra headers, sent in name order, for
+// testing handlers and middleware without a connection. Host is example.com unless extra sets it.
+func NewRequest(t testing.TB, method, target string, extra map[string]string) *request.Request {
+	t.Helper()
+	raw := method + " " + target + " HTTP/1.1\r\n"
+	if _, ok := headerValue(extra, "Host"); !ok {
+		raw += "Host: example.com\r\n"
+	}
+	names := make([]string, 0, len(extra))
+	for name := range extra {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		raw += name + ": " + extra[name] + "\r\n"
+	}
+
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	if err != nil {
+		t.Fatalf("parsing request: %v", err)
+	}
+	return req
+}
+
+// Do runs handler for req and finishes the response as the server does, then parses what was
+// written. The body is returned as sent, without undoing any content coding, and is also left
+// readable in resp.Body. Anything written after the end of the response fails the test.
+func Do(t testing.TB, handler server.Handler, req *request.Request) (*http.Response, string) {
+	t.Helper()
+	var out bytes.Buffer
+	w := response.NewWriter(&out)
+	w.SetRequestMethod(req.RequestLine.Method)
+	server.Finish(w, handler(w, req))
+
+	reader := bufio.NewReader(&out)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: req.RequestLine.Method})
+	if err != nil {
+		t.Fatalf("parsing response: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading response body: %v", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if rest, _ := io.ReadAll(reader); len(rest) > 0 {
+		t.Errorf("%d bytes written after the response: %q", len(rest), rest)
+	}
+	return resp, string(body)
+}
+
+func headerValue(h map[string]string, name string) (string, bool) {
+	for key, value := range h {
+		if strings.EqualFold(key, name) {
+			return value, true
+		}
+	}
+	return "", false
+}
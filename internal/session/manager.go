@@ -0,0 +1,156 @@
+package session
+
+import (
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/cookie"
+	"github.com/kiefbc/http-server-1.1/internal/headers"
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+)
+
+// Manager loads a session for every request and saves it with the response.
+type Manager struct {
+	store Store
+	now   func() time.Time
+
+	// CookieName names the session cookie.
+	CookieName string
+	// IdleTimeout ends a session that has not been used for this long.
+	IdleTimeout time.Duration
+	// AbsoluteTimeout ends a session this long after it was created, however active it is.
+	AbsoluteTimeout time.Duration
+
+	// Path, Domain, Secure and SameSite are the session cookie's attributes.
+	// The cookie is always HttpOnly so scripts cannot read it.
+	Path     string
+	Domain   string
+	Secure   bool
+	SameSite cookie.SameSite
+
+	// OnError, if set, is told when a session cannot be saved, e.g. because it outgrew a cookie.
+	// The response then goes out without updating the session.
+	OnError func(err error)
+}
+
+// New creates a Manager backed by store with a 30 minute idle timeout and a 24 hour
+// absolute timeout.
+func New(store Store) *Manager {
+	return &Manager{
+		store:           store,
+		now:             time.Now,
+		CookieName:      "session",
+		IdleTimeout:     30 * time.Minute,
+		AbsoluteTimeout: 24 * time.Hour,
+		Path:            "/",
+		SameSite:        cookie.SameSiteLax,
+	}
+}
+
+// Middleware attaches a session to each request, available through FromRequest. A session
+// that has expired or cannot be verified is replaced by a new, empty one. The session is
+// saved, and its cookie refreshed, when the response headers are written.
+func (m *Manager) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		sess := m.load(req)
+		w.OnWriteHeaders(func(status response.StatusCode, h headers.Headers) response.StatusCode {
+			m.commit(w, sess)
+			return status
+		})
+		return next(w, withSession(req, sess))
+	}
+}
+
+// load restores the session named by the request cookie, or starts a new one.
+func (m *Manager) load(req *request.Request) *Session {
+	now := m.now()
+	c, ok := req.Cookie(m.CookieName)
+	if !ok {
+		return newSession(now)
+	}
+
+	d, err := m.store.Load(c.Value)
+	if err != nil {
+		return newSession(now)
+	}
+	if m.expired(d, now) {
+		m.store.Delete(d.ID)
+		return newSession(now)
+	}
+
+	sess := fromData(d)
+	sess.lastSeen = now
+	return sess
+}
+
+// expired applies the idle and absolute timeouts.
+func (m *Manager) expired(d *Data, now time.Time) bool {
+	if m.IdleTimeout > 0 && now.Sub(d.LastSeen) >= m.IdleTimeout {
+		return true
+	}
+	return m.AbsoluteTimeout > 0 && now.Sub(d.CreatedAt) >= m.AbsoluteTimeout
+}
+
+// expiry is when a session saved now stops being valid: the sooner of the two timeouts.
+func (m *Manager) expiry(d *Data) time.Time {
+	var expires time.Time
+	if m.IdleTimeout > 0 {
+		expires = d.LastSeen.Add(m.IdleTimeout)
+	}
+	if m.AbsoluteTimeout > 0 {
+		absolute := d.CreatedAt.Add(m.AbsoluteTimeout)
+		if expires.IsZero() || absolute.Before(expires) {
+			expires = absolute
+		}
+	}
+	return expires
+}
+
+// commit saves the session and sets its cookie. New sessions nobody wrote to are not
+// saved, so clients that never log in do not fill the store.
+func (m *Manager) commit(w *response.Writer, sess *Session) {
+	sess.mu.Lock()
+	destroyed, isNew, modified, oldID := sess.destroyed, sess.isNew, sess.modified, sess.oldID
+	sess.mu.Unlock()
+
+	if oldID != "" {
+		m.store.Delete(oldID)
+	}
+	if destroyed {
+		m.store.Delete(sess.ID())
+		if !isNew {
+			w.SetCookie(m.cookie("", -1, time.Time{}))
+		}
+		return
+	}
+	if isNew && !modified {
+		return
+	}
+
+	d := sess.data()
+	expires := m.expiry(d)
+	value, err := m.store.Save(d, expires)
+	if err != nil {
+		if m.OnError != nil {
+			m.OnError(err)
+		}
+		return
+	}
+	w.SetCookie(m.cookie(value, 0, expires))
+}
+
+// cookie builds the session cookie with the configured attributes.
+func (m *Manager) cookie(value string, maxAge int, expires time.Time) *cookie.Cookie {
+	return &cookie.Cookie{
+		Name:     m.CookieName,
+		Value:    value,
+		Path:     m.Path,
+		Domain:   m.Domain,
+		Expires:  expires,
+		MaxAge:   maxAge,
+		Secure:   m.Secure,
+		HttpOnly: true,
+		SameSite: m.SameSite,
+	}
+}
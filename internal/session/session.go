@@ -0,0 +1,166 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+)
+
+// Session is the per-client state attached to a request by Manager.Middleware.
+// It is safe for use by multiple goroutines.
+type Session struct {
+	mu sync.Mutex
+
+	id        string
+	values    map[string]string
+	createdAt time.Time
+	lastSeen  time.Time
+
+	isNew     bool
+	modified  bool
+	destroyed bool
+	oldID     string // the ID before Regenerate, still held by the store
+}
+
+// newSession starts an empty session with a fresh ID.
+func newSession(now time.Time) *Session {
+	return &Session{
+		id:        newID(),
+		values:    make(map[string]string),
+		createdAt: now,
+		lastSeen:  now,
+		isNew:     true,
+	}
+}
+
+// newID returns 256 random bits, URL-safe encoded.
+func newID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ID returns the session identifier.
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// IsNew reports whether the session was created by this request.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+// CreatedAt returns when the session was first created.
+func (s *Session) CreatedAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.createdAt
+}
+
+// Get returns the value stored under key.
+func (s *Session) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.values[key]
+	return value, ok
+}
+
+// Set stores value under key.
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.modified = true
+}
+
+// Delete removes key from the session.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values, key)
+	s.modified = true
+}
+
+// Values returns a copy of everything stored in the session.
+func (s *Session) Values() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return maps.Clone(s.values)
+}
+
+// Regenerate gives the session a new ID while keeping its values. Call it whenever the
+// privilege level changes, such as on login, so an ID planted before login by an attacker
+// (session fixation) is worthless afterwards.
+func (s *Session) Regenerate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.oldID == "" && !s.isNew {
+		s.oldID = s.id
+	}
+	s.id = newID()
+	s.modified = true
+}
+
+// Destroy ends the session: it is removed from the store and the client's cookie is expired.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = make(map[string]string)
+	s.destroyed = true
+}
+
+// Data is the stored form of a session, as handed to a Store.
+type Data struct {
+	ID        string            `json:"id"`
+	Values    map[string]string `json:"values"`
+	CreatedAt time.Time         `json:"created"`
+	LastSeen  time.Time         `json:"seen"`
+}
+
+// data snapshots the session for a Store.
+func (s *Session) data() *Data {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &Data{
+		ID:        s.id,
+		Values:    maps.Clone(s.values),
+		CreatedAt: s.createdAt,
+		LastSeen:  s.lastSeen,
+	}
+}
+
+// fromData restores a session loaded from a Store.
+func fromData(d *Data) *Session {
+	values := d.Values
+	if values == nil {
+		values = make(map[string]string)
+	}
+	return &Session{
+		id:        d.ID,
+		values:    values,
+		createdAt: d.CreatedAt,
+		lastSeen:  d.LastSeen,
+	}
+}
+
+type contextKey struct{}
+
+// FromRequest returns the session Manager.Middleware attached to req, or nil if there is none.
+func FromRequest(req *request.Request) *Session {
+	s, _ := req.Context().Value(contextKey{}).(*Session)
+	return s
+}
+
+// withSession returns a copy of req carrying s.
+func withSession(req *request.Request, s *Session) *request.Request {
+	return req.WithContext(context.WithValue(req.Context(), contextKey{}, s))
+}
@@ -0,0 +1,212 @@
+package session
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/kiefbc/http-server-1.1/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// do runs handler with the session cookie value, if any, and returns the session cookie it
+// sets: its value, "-" when it is deleted, or "" when none is set.
+func do(t *testing.T, handler server.Handler, value string) string {
+	t.Helper()
+	extra := map[string]string{}
+	if value != "" {
+		extra["Cookie"] = "session=" + value
+	}
+	resp, _ := servertest.Do(t, handler, servertest.NewRequest(t, "GET", "/", extra))
+	for _, c := range resp.Cookies() {
+		if c.Name == "session" {
+			assert.True(t, c.HttpOnly)
+			if c.MaxAge < 0 {
+				return "-"
+			}
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// counter increments a visit count stored in the session.
+func counter(seen *string) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		sess := FromRequest(req)
+		count, _ := sess.Get("count")
+		*seen = count
+		sess.Set("count", count+"I")
+		w.WriteStatusLine(response.StatusNoContent)
+		w.WriteHeaders(nil)
+		return nil
+	}
+}
+
+func key(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestStores(t *testing.T) {
+	signed, err := NewCookieStore(key(1))
+	require.NoError(t, err)
+	encrypted, err := NewEncryptedCookieStore(key(1))
+	require.NoError(t, err)
+
+	for name, store := range map[string]Store{"signed": signed, "encrypted": encrypted, "memory": NewMemoryStore()} {
+		// Test: Sessions survive a round trip through the cookie
+		var seen string
+		handler := New(store).Middleware(counter(&seen))
+		value := do(t, handler, "")
+		require.NotEmpty(t, value, name)
+		value = do(t, handler, value)
+		assert.Equal(t, "I", seen, name)
+		do(t, handler, value)
+		assert.Equal(t, "II", seen, name)
+
+		// Test: Unknown or tampered cookies start a new session
+		do(t, handler, value+"x")
+		assert.Equal(t, "", seen, name)
+	}
+
+	// Test: Signed cookies are readable but encrypted ones are not
+	d := &Data{ID: "abc", Values: map[string]string{"user": "alice"}}
+	value, err := signed.Save(d, time.Now())
+	require.NoError(t, err)
+	payload, _, _ := strings.Cut(value, ".")
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	require.NoError(t, err)
+	assert.Contains(t, string(decoded), "alice")
+	value, err = encrypted.Save(d, time.Now())
+	require.NoError(t, err)
+	decoded, err = base64.RawURLEncoding.DecodeString(value)
+	require.NoError(t, err)
+	assert.NotContains(t, string(decoded), "alice")
+
+	// Test: Short keys are refused
+	_, err = NewCookieStore([]byte("short"))
+	assert.Error(t, err)
+	_, err = NewEncryptedCookieStore([]byte("short"))
+	assert.Error(t, err)
+
+	// Test: Oversized sessions do not fit in a cookie
+	d.Values["big"] = strings.Repeat("x", maxCookieBytes)
+	_, err = signed.Save(d, time.Now())
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestKeyRotation(t *testing.T) {
+	for name, newStore := range map[string]func(keys ...[]byte) (Store, error){
+		"signed":    func(keys ...[]byte) (Store, error) { return NewCookieStore(keys...) },
+		"encrypted": func(keys ...[]byte) (Store, error) { return NewEncryptedCookieStore(keys...) },
+	} {
+		oldStore, err := newStore(key(1))
+		require.NoError(t, err)
+		d := &Data{ID: "abc", Values: map[string]string{"user": "alice"}}
+		value, err := oldStore.Save(d, time.Now())
+		require.NoError(t, err)
+
+		// Test: A new primary key still accepts cookies from the old one
+		rotated, err := newStore(key(2), key(1))
+		require.NoError(t, err)
+		loaded, err := rotated.Load(value)
+		require.NoError(t, err, name)
+		assert.Equal(t, "alice", loaded.Values["user"], name)
+
+		// Test: New cookies use the new key, so dropping the old key keeps them valid
+		value, err = rotated.Save(d, time.Now())
+		require.NoError(t, err)
+		_, err = oldStore.Load(value)
+		assert.ErrorIs(t, err, ErrInvalid, name)
+		current, err := newStore(key(2))
+		require.NoError(t, err)
+		_, err = current.Load(value)
+		assert.NoError(t, err, name)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	clock := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return clock }
+	m := New(store)
+	m.now = func() time.Time { return clock }
+	m.IdleTimeout = 10 * time.Minute
+	m.AbsoluteTimeout = time.Hour
+	var seen string
+	handler := m.Middleware(counter(&seen))
+
+	// Test: Activity within the idle timeout keeps the session alive
+	value := do(t, handler, "")
+	for i := 0; i < 5; i++ {
+		clock = clock.Add(9 * time.Minute)
+		value = do(t, handler, value)
+	}
+	assert.Equal(t, "IIIII", seen)
+
+	// Test: The absolute timeout ends even an active session
+	clock = clock.Add(9 * time.Minute)
+	value = do(t, handler, value)
+	clock = clock.Add(9 * time.Minute)
+	do(t, handler, value)
+	assert.Equal(t, "", seen)
+
+	// Test: Idle sessions expire
+	value = do(t, handler, "")
+	clock = clock.Add(11 * time.Minute)
+	do(t, handler, value)
+	assert.Equal(t, "", seen)
+}
+
+func TestRegenerateAndDestroy(t *testing.T) {
+	store := NewMemoryStore()
+	m := New(store)
+	login := m.Middleware(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		sess := FromRequest(req)
+		sess.Regenerate()
+		sess.Set("user", "alice")
+		w.WriteStatusLine(response.StatusNoContent)
+		w.WriteHeaders(nil)
+		return nil
+	})
+	logout := m.Middleware(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		FromRequest(req).Destroy()
+		w.WriteStatusLine(response.StatusNoContent)
+		w.WriteHeaders(nil)
+		return nil
+	})
+	var count string
+	visit := m.Middleware(counter(&count))
+
+	// Test: Untouched new sessions are not saved
+	assert.Equal(t, "", do(t, m.Middleware(func(w *response.Writer, req *request.Request) *server.HandlerError {
+		w.WriteStatusLine(response.StatusNoContent)
+		w.WriteHeaders(nil)
+		return nil
+	}), ""))
+	assert.Equal(t, 0, store.Len())
+
+	// Test: Regenerate issues a new ID, keeps values and forgets the old ID
+	before := do(t, visit, "")
+	after := do(t, login, before)
+	assert.NotEqual(t, before, after)
+	assert.Equal(t, 1, store.Len())
+	do(t, visit, before)
+	assert.Equal(t, "", count)
+	do(t, visit, after)
+	assert.Equal(t, "I", count)
+
+	// Test: Destroy removes the session and expires the cookie
+	assert.Equal(t, "-", do(t, logout, after))
+	do(t, visit, after)
+	assert.Equal(t, "", count)
+
+	// Test: Without the middleware there is no session
+	assert.Nil(t, FromRequest(servertest.NewRequest(t, "GET", "/", nil)))
+}
@@ -0,0 +1,273 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCookieBytes is the most a browser is guaranteed to keep for one cookie (RFC 6265 Section 6.1).
+const maxCookieBytes = 4096
+
+var (
+	// ErrNotFound means a cookie names no session the store knows about.
+	ErrNotFound = errors.New("session not found")
+	// ErrInvalid means a cookie was tampered with, corrupted, or sealed with an unknown key.
+	ErrInvalid = errors.New("session cookie invalid")
+	// ErrTooLarge means a session does not fit in a cookie.
+	ErrTooLarge = errors.New("session too large for a cookie")
+)
+
+// Store persists sessions between requests. The cookie value a Store hands out is all the
+// client keeps: either the whole session, protected cryptographically, or an ID for
+// server-side storage.
+type Store interface {
+	// Load returns the session a cookie value refers to.
+	Load(value string) (*Data, error)
+	// Save stores d until expires and returns the cookie value that refers to it.
+	Save(d *Data, expires time.Time) (string, error)
+	// Delete forgets the session with the given ID. Cookie stores have nothing to forget.
+	Delete(id string) error
+}
+
+// CookieStore keeps the whole session in the cookie, signed with HMAC-SHA256 so the client
+// can read but not alter it. Do not put secrets in a signed session.
+//
+// The first key signs; every key verifies, so keys can be rotated by adding a new key in
+// front and removing the old one once the longest session lifetime has passed.
+type CookieStore struct {
+	keys [][]byte
+}
+
+// NewCookieStore creates a signed CookieStore. Each key must be at least 32 bytes.
+func NewCookieStore(keys ...[]byte) (*CookieStore, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("session: at least one key is required")
+	}
+	for _, key := range keys {
+		if len(key) < 32 {
+			return nil, errors.New("session: signing keys must be at least 32 bytes")
+		}
+	}
+	return &CookieStore{keys: keys}, nil
+}
+
+func (cs *CookieStore) Save(d *Data, expires time.Time) (string, error) {
+	payload, err := json.Marshal(d)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	value := encoded + "." + base64.RawURLEncoding.EncodeToString(sign(cs.keys[0], encoded))
+	if len(value) > maxCookieBytes {
+		return "", ErrTooLarge
+	}
+	return value, nil
+}
+
+func (cs *CookieStore) Load(value string) (*Data, error) {
+	encoded, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, ErrInvalid
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	verified := false
+	for _, key := range cs.keys {
+		if hmac.Equal(mac, sign(key, encoded)) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+	return decodeData(payload)
+}
+
+func (cs *CookieStore) Delete(id string) error {
+	return nil
+}
+
+// sign returns the HMAC-SHA256 of message under key.
+func sign(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// EncryptedCookieStore keeps the whole session in the cookie, sealed with AES-GCM so the
+// client can neither read nor alter it. Keys rotate the same way as for CookieStore.
+type EncryptedCookieStore struct {
+	aeads []cipher.AEAD
+}
+
+// NewEncryptedCookieStore creates an EncryptedCookieStore. Each key must be 16, 24 or 32
+// bytes, selecting AES-128, AES-192 or AES-256.
+func NewEncryptedCookieStore(keys ...[]byte) (*EncryptedCookieStore, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("session: at least one key is required")
+	}
+	store := &EncryptedCookieStore{}
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("session: %w", err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("session: %w", err)
+		}
+		store.aeads = append(store.aeads, aead)
+	}
+	return store, nil
+}
+
+// sealLabel is authenticated with every session so a ciphertext made for some other
+// purpose with the same key is not accepted as a session.
+var sealLabel = []byte("session")
+
+func (es *EncryptedCookieStore) Save(d *Data, expires time.Time) (string, error) {
+	payload, err := json.Marshal(d)
+	if err != nil {
+		return "", err
+	}
+
+	aead := es.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(payload)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, payload, sealLabel)
+
+	value := base64.RawURLEncoding.EncodeToString(sealed)
+	if len(value) > maxCookieBytes {
+		return "", ErrTooLarge
+	}
+	return value, nil
+}
+
+func (es *EncryptedCookieStore) Load(value string) (*Data, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	for _, aead := range es.aeads {
+		if len(sealed) < aead.NonceSize() {
+			continue
+		}
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		payload, err := aead.Open(nil, nonce, ciphertext, sealLabel)
+		if err == nil {
+			return decodeData(payload)
+		}
+	}
+	return nil, ErrInvalid
+}
+
+func (es *EncryptedCookieStore) Delete(id string) error {
+	return nil
+}
+
+// decodeData unmarshals a session payload.
+func decodeData(payload []byte) (*Data, error) {
+	var d Data
+	if err := json.Unmarshal(payload, &d); err != nil || d.ID == "" {
+		return nil, ErrInvalid
+	}
+	return &d, nil
+}
+
+// sweepInterval is how often MemoryStore drops expired sessions.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps sessions in process memory; the cookie only holds the session ID.
+// Sessions are lost on restart and are not shared between server instances.
+type MemoryStore struct {
+	mu        sync.Mutex
+	sessions  map[string]memoryEntry
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type memoryEntry struct {
+	data    *Data
+	expires time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]memoryEntry),
+		now:      time.Now,
+	}
+}
+
+func (ms *MemoryStore) Load(value string) (*Data, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	entry, ok := ms.sessions[value]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !ms.now().Before(entry.expires) {
+		delete(ms.sessions, value)
+		return nil, ErrNotFound
+	}
+	// Hand out a copy so request handlers never share a map
+	d := *entry.data
+	d.Values = maps.Clone(entry.data.Values)
+	return &d, nil
+}
+
+func (ms *MemoryStore) Save(d *Data, expires time.Time) (string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	now := ms.now()
+	if now.Sub(ms.lastSweep) >= sweepInterval {
+		for id, entry := range ms.sessions {
+			if !now.Before(entry.expires) {
+				delete(ms.sessions, id)
+			}
+		}
+		ms.lastSweep = now
+	}
+
+	ms.sessions[d.ID] = memoryEntry{data: d, expires: expires}
+	return d.ID, nil
+}
+
+func (ms *MemoryStore) Delete(id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.sessions, id)
+	return nil
+}
+
+// Len returns how many sessions are stored, including expired ones not yet swept.
+func (ms *MemoryStore) Len() int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return len(ms.sessions)
+}
@@ -0,0 +1,150 @@
+package tracing
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// scopeName is the instrumentation scope recorded on exported spans.
+const scopeName = "github.com/kiefbc/http-server-1.1/internal/tracing"
+
+// OTLPExporter writes each span as one line of OTLP/JSON, an ExportTraceServiceRequest
+// holding just that span, the format the OpenTelemetry Collector's file receiver reads.
+type OTLPExporter struct {
+	service string
+
+	mu     sync.Mutex
+	out    io.Writer
+	closer io.Closer
+}
+
+// NewOTLPExporter writes spans to out, attributed to the service name.
+func NewOTLPExporter(out io.Writer, service string) *OTLPExporter {
+	return &OTLPExporter{service: service, out: out}
+}
+
+// OpenOTLPFile appends spans to the file at path, creating it if needed.
+func OpenOTLPFile(path, service string) (*OTLPExporter, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	e := NewOTLPExporter(file, service)
+	e.closer = file
+	return e, nil
+}
+
+// Export implements Exporter.
+func (e *OTLPExporter) Export(span *Span) error {
+	line, err := json.Marshal(e.request(span))
+	if err != nil {
+		return fmt.Errorf("encoding span: %w", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.out.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file opened by OpenOTLPFile.
+func (e *OTLPExporter) Close() error {
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
+}
+
+// OTLP/JSON message shapes. IDs are hex and 64-bit integers are strings, as the OTLP
+// JSON encoding requires.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		TraceState        string         `json:"traceState,omitempty"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Flags             uint32         `json:"flags"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code int `json:"code,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	}
+)
+
+// otlpKindServer is SPAN_KIND_SERVER.
+const otlpKindServer = 2
+
+func (e *OTLPExporter) request(span *Span) otlpRequest {
+	s := otlpSpan{
+		TraceID:           span.Context.TraceID.String(),
+		SpanID:            span.Context.SpanID.String(),
+		TraceState:        span.Context.TraceState,
+		Flags:             uint32(span.Context.Flags),
+		Name:              span.Name,
+		Kind:              otlpKindServer,
+		StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+		Status:            otlpStatus{Code: int(span.Status)},
+	}
+	if span.ParentID.IsValid() {
+		s.ParentSpanID = span.ParentID.String()
+	}
+	for _, attr := range span.Attributes {
+		s.Attributes = append(s.Attributes, otlpAttribute(attr.Key, attr.Value))
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpKeyValue{otlpAttribute("service.name", e.service)}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: scopeName}, Spans: []otlpSpan{s}}},
+	}}}
+}
+
+// otlpAttribute encodes value as an OTLP AnyValue; unknown types are formatted as strings.
+func otlpAttribute(key string, value any) otlpKeyValue {
+	var v map[string]any
+	switch value := value.(type) {
+	case string:
+		v = map[string]any{"stringValue": value}
+	case bool:
+		v = map[string]any{"boolValue": value}
+	case int64:
+		v = map[string]any{"intValue": strconv.FormatInt(value, 10)}
+	case int:
+		v = map[string]any{"intValue": strconv.Itoa(value)}
+	case float64:
+		v = map[string]any{"doubleValue": value}
+	default:
+		v = map[string]any{"stringValue": fmt.Sprint(value)}
+	}
+	return otlpKeyValue{Key: key, Value: v}
+}
@@ -0,0 +1,195 @@
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kiefbc/http-server-1.1/internal/headers"
+)
+
+// TraceID identifies a whole trace across every hop.
+type TraceID [16]byte
+
+// SpanID identifies one span within a trace.
+type SpanID [8]byte
+
+// String returns the lowercase hex form used in traceparent.
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether id is not all zeros.
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// String returns the lowercase hex form used in traceparent.
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether id is not all zeros.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// FlagSampled is the trace-flags bit saying the caller may have recorded the trace.
+const FlagSampled byte = 0x01
+
+// maxTracestateMembers is the most list-members tracestate may carry (W3C Trace Context Section 3.3.1.1).
+const maxTracestateMembers = 32
+
+// ErrInvalidTraceparent and ErrInvalidTracestate are returned for headers that do not follow
+// W3C Trace Context. Either way the header must not be propagated.
+var (
+	ErrInvalidTraceparent = errors.New("invalid traceparent")
+	ErrInvalidTracestate  = errors.New("invalid tracestate")
+)
+
+// SpanContext is what crosses process boundaries: the trace, the span that is the parent of
+// whatever comes next, the trace flags and the vendor-specific tracestate.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Flags      byte
+	TraceState string
+}
+
+// Sampled reports whether the sampled flag is set.
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+// Traceparent formats sc as a version 00 traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+// ParseTraceparent parses a traceparent header value (W3C Trace Context Section 3.2).
+// Versions above 00 are parsed as 00 and any fields they append are ignored.
+func ParseTraceparent(value string) (SpanContext, error) {
+	var sc SpanContext
+	if len(value) < 55 || !isLowerHex(value[:2]) || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return sc, ErrInvalidTraceparent
+	}
+	version := value[:2]
+	if version == "ff" || (version == "00" && len(value) != 55) || (len(value) > 55 && value[55] != '-') {
+		return sc, ErrInvalidTraceparent
+	}
+
+	traceID, parentID, flags := value[3:35], value[36:52], value[53:55]
+	if !isLowerHex(traceID) || !isLowerHex(parentID) || !isLowerHex(flags) {
+		return sc, ErrInvalidTraceparent
+	}
+	hex.Decode(sc.TraceID[:], []byte(traceID))
+	hex.Decode(sc.SpanID[:], []byte(parentID))
+	var flag [1]byte
+	hex.Decode(flag[:], []byte(flags))
+	sc.Flags = flag[0]
+	if !sc.TraceID.IsValid() || !sc.SpanID.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	return sc, nil
+}
+
+// ParseTracestate validates a tracestate header value (W3C Trace Context Section 3.3) and
+// returns it with optional whitespace and empty list-members removed.
+func ParseTracestate(value string) (string, error) {
+	var members []string
+	seen := make(map[string]bool)
+	for _, member := range strings.Split(value, ",") {
+		member = strings.Trim(member, " \t")
+		if member == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(member, "=")
+		if !ok || !validTracestateKey(key) || !validTracestateValue(val) || seen[key] {
+			return "", ErrInvalidTracestate
+		}
+		seen[key] = true
+		members = append(members, member)
+	}
+	if len(members) > maxTracestateMembers {
+		return "", ErrInvalidTracestate
+	}
+	return strings.Join(members, ","), nil
+}
+
+// Extract reads the caller's span context from request headers. ok is false when there is
+// no valid traceparent; an invalid tracestate is dropped on its own.
+func Extract(h headers.Headers) (sc SpanContext, ok bool) {
+	traceparent, found := h.Get("traceparent")
+	if !found {
+		return sc, false
+	}
+	sc, err := ParseTraceparent(traceparent)
+	if err != nil {
+		return sc, false
+	}
+	if tracestate, found := h.Get("tracestate"); found {
+		sc.TraceState, _ = ParseTracestate(tracestate)
+	}
+	return sc, true
+}
+
+// NewTraceID returns a random trace ID.
+func NewTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+// NewSpanID returns a random span ID.
+func NewSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// validTracestateKey accepts simple-key and multi-tenant-key (tenant@system).
+func validTracestateKey(key string) bool {
+	tenant, system, multi := strings.Cut(key, "@")
+	if !multi {
+		return len(key) <= 256 && key != "" && isLowerAlpha(key[0]) && keyChars(key[1:])
+	}
+	return tenant != "" && len(tenant) <= 241 && (isLowerAlpha(tenant[0]) || isDigit(tenant[0])) && keyChars(tenant[1:]) &&
+		system != "" && len(system) <= 14 && isLowerAlpha(system[0]) && keyChars(system[1:])
+}
+
+func keyChars(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !isLowerAlpha(c) && !isDigit(c) && c != '_' && c != '-' && c != '*' && c != '/' {
+			return false
+		}
+	}
+	return true
+}
+
+// validTracestateValue accepts 1 to 256 printable characters other than ',' and '=' that do
+// not end in a space.
+func validTracestateValue(val string) bool {
+	if val == "" || len(val) > 256 || val[len(val)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(val); i++ {
+		c := val[i]
+		if c < 0x20 || c > 0x7e || c == ',' || c == '=' {
+			return false
+		}
+	}
+	return true
+}
+
+func isLowerAlpha(c byte) bool { return c >= 'a' && c <= 'z' }
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
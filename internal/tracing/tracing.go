@@ -0,0 +1,152 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/ipfilter"
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+)
+
+// Attribute is a span attribute. Value is a string, bool, int64 or float64.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// Status is the outcome of a span.
+type Status int
+
+const (
+	StatusUnset Status = iota
+	StatusOK
+	StatusError
+)
+
+// Span records one request handled by the server. It is not safe for concurrent use; a
+// handler may add attributes from the goroutine handling the request.
+type Span struct {
+	Name string
+	// Context is this span's context; its SpanID is what downstream calls see as their parent.
+	Context SpanContext
+	// ParentID is the caller's span, zero when this span started the trace.
+	ParentID   SpanID
+	Start      time.Time
+	End        time.Time
+	Attributes []Attribute
+	Status     Status
+}
+
+// SetAttribute adds an attribute, replacing any earlier one with the same key.
+func (s *Span) SetAttribute(key string, value any) {
+	for i := range s.Attributes {
+		if s.Attributes[i].Key == key {
+			s.Attributes[i].Value = value
+			return
+		}
+	}
+	s.Attributes = append(s.Attributes, Attribute{key, value})
+}
+
+// Exporter sends finished spans somewhere. It must be safe for concurrent use.
+type Exporter interface {
+	Export(span *Span) error
+}
+
+// Tracer is middleware that continues the caller's trace, or starts one, and records a
+// server span for each request.
+type Tracer struct {
+	exporter Exporter
+	now      func() time.Time
+
+	// OnStart, when set, is called once the span has its request attributes and before the
+	// handler runs.
+	OnStart func(span *Span)
+	// OnEnd, when set, is called with the finished span, sampled or not, before it is exported.
+	OnEnd func(span *Span)
+	// OnError, when set, receives export errors.
+	OnError func(err error)
+}
+
+// New creates a Tracer exporting sampled spans to exporter, which may be nil to only
+// propagate trace context and run the hooks.
+func New(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter, now: time.Now}
+}
+
+type spanKey struct{}
+
+// FromRequest returns the request's span, whose Context is what outgoing calls should carry.
+func FromRequest(req *request.Request) (*Span, bool) {
+	span, ok := req.Context().Value(spanKey{}).(*Span)
+	return span, ok
+}
+
+// Middleware records a span per request. A valid traceparent continues the caller's trace
+// with its flags and tracestate; otherwise a new sampled trace is started. It finishes the
+// response with server.Finish and goes after access logging and metrics in the chain
+// (see server.Chain).
+func (t *Tracer) Middleware(next server.Handler) server.Handler {
+	return func(w *response.Writer, req *request.Request) *server.HandlerError {
+		span := &Span{Name: req.RequestLine.Method, Start: t.now()}
+		if parent, ok := Extract(req.Headers); ok {
+			span.Context = parent
+			span.ParentID = parent.SpanID
+		} else {
+			span.Context = SpanContext{TraceID: NewTraceID(), Flags: FlagSampled}
+		}
+		span.Context.SpanID = NewSpanID()
+		setRequestAttributes(span, req)
+		if t.OnStart != nil {
+			t.OnStart(span)
+		}
+
+		req = req.WithContext(context.WithValue(req.Context(), spanKey{}, span))
+		handlerErr := next(w, req)
+
+		server.Finish(w, handlerErr)
+
+		span.End = t.now()
+		status := int(w.StatusCode())
+		span.SetAttribute("http.response.status_code", int64(status))
+		span.SetAttribute("http.response.body.size", w.BytesWritten())
+		if status >= 500 {
+			// Server spans leave 4xx unset: those are the client's errors (OpenTelemetry HTTP semantic conventions)
+			span.Status = StatusError
+		}
+		if t.OnEnd != nil {
+			t.OnEnd(span)
+		}
+		if t.exporter != nil && span.Context.Sampled() {
+			if err := t.exporter.Export(span); err != nil && t.OnError != nil {
+				t.OnError(err)
+			}
+		}
+		return handlerErr
+	}
+}
+
+// setRequestAttributes adds the OpenTelemetry HTTP server attributes known from req.
+func setRequestAttributes(span *Span, req *request.Request) {
+	path, query, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	span.SetAttribute("http.request.method", req.RequestLine.Method)
+	span.SetAttribute("url.path", path)
+	if query != "" {
+		span.SetAttribute("url.query", query)
+	}
+	span.SetAttribute("url.scheme", "http")
+	span.SetAttribute("network.protocol.version", req.RequestLine.HttpVersion)
+	if host, ok := req.Headers.Get("Host"); ok {
+		span.SetAttribute("server.address", host)
+	}
+	if client, ok := ipfilter.ClientIP(req); ok {
+		span.SetAttribute("client.address", client.String())
+	}
+	if userAgent, ok := req.Headers.Get("User-Agent"); ok {
+		span.SetAttribute("user_agent.original", userAgent)
+	}
+	span.SetAttribute("http.request.body.size", int64(len(req.Body)))
+}
@@ -0,0 +1,210 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kiefbc/http-server-1.1/internal/request"
+	"github.com/kiefbc/http-server-1.1/internal/response"
+	"github.com/kiefbc/http-server-1.1/internal/server"
+	"github.com/kiefbc/http-server-1.1/internal/servertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceparent(t *testing.T) {
+	// Test: A valid header is decoded and formats back the same
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	assert.True(t, sc.Sampled())
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sc.Traceparent())
+
+	// Test: Later versions may append fields
+	sc, err = ParseTraceparent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+	require.NoError(t, err)
+	assert.False(t, sc.Sampled())
+
+	// Test: Malformed headers are rejected
+	for _, value := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0g",
+		"00_4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.extra",
+	} {
+		_, err := ParseTraceparent(value)
+		assert.ErrorIs(t, err, ErrInvalidTraceparent, value)
+	}
+}
+
+func TestTracestate(t *testing.T) {
+	// Test: Valid lists are normalised
+	state, err := ParseTracestate(" rojo=00f067aa0ba902b7 ,, congo=t61rcWkgMzE,tenant1@vendor=x ")
+	require.NoError(t, err)
+	assert.Equal(t, "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE,tenant1@vendor=x", state)
+
+	// Test: Bad keys, values, duplicates and overlong lists are rejected
+	for _, value := range []string{
+		"Rojo=1",
+		"rojo",
+		"rojo=a=b",
+		"rojo=1,rojo=2",
+		"rojo=a\x01b",
+		"tenant@VENDOR=1",
+		strings.Repeat("x", 257) + "=1",
+	} {
+		_, err := ParseTracestate(value)
+		assert.ErrorIs(t, err, ErrInvalidTracestate, value)
+	}
+	var members []string
+	for i := range 33 {
+		members = append(members, string(rune('a'+i%26))+strings.Repeat("k", i/26)+"=1")
+	}
+	_, err = ParseTracestate(strings.Join(members, ","))
+	assert.ErrorIs(t, err, ErrInvalidTracestate)
+}
+
+// recorder is an Exporter that keeps the spans it is given.
+type recorder struct {
+	spans []*Span
+	err   error
+}
+
+func (r *recorder) Export(span *Span) error {
+	r.spans = append(r.spans, span)
+	return r.err
+}
+
+// do runs handler behind tr for a GET /items?page=2 request with the extra headers.
+func do(t *testing.T, tr *Tracer, handler server.Handler, extra map[string]string) {
+	t.Helper()
+	req := servertest.NewRequest(t, "GET", "/items?page=2", extra)
+	req.Headers.Replace("User-Agent", "test")
+	req.RemoteAddr = "192.0.2.7:50000"
+	servertest.Do(t, tr.Middleware(handler), req)
+}
+
+func TestMiddleware(t *testing.T) {
+	exported := &recorder{}
+	tr := New(exported)
+	clock := time.Date(2025, 3, 4, 5, 6, 7, 0, time.UTC)
+	tr.now = func() time.Time {
+		now := clock
+		clock = clock.Add(10 * time.Millisecond)
+		return now
+	}
+	var started []*Span
+	tr.OnStart = func(span *Span) { started = append(started, span) }
+
+	var seen *Span
+	hello := func(w *response.Writer, req *request.Request) *server.HandlerError {
+		seen, _ = FromRequest(req)
+		seen.SetAttribute("app.items", int64(3))
+		body := []byte("hello")
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+		return nil
+	}
+
+	// Test: A caller's trace is continued with a new span, its tracestate and request attributes
+	do(t, tr, hello, map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "tracestate": "congo=t61rcWkgMzE"})
+	require.Len(t, exported.spans, 1)
+	span := exported.spans[0]
+	assert.Same(t, seen, span)
+	assert.Equal(t, []*Span{span}, started)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.Context.TraceID.String())
+	assert.Equal(t, "00f067aa0ba902b7", span.ParentID.String())
+	assert.NotEqual(t, span.ParentID, span.Context.SpanID)
+	assert.Equal(t, "congo=t61rcWkgMzE", span.Context.TraceState)
+	assert.Equal(t, 10*time.Millisecond, span.End.Sub(span.Start))
+	assert.Equal(t, StatusUnset, span.Status)
+	assert.Contains(t, span.Attributes, Attribute{"http.request.method", "GET"})
+	assert.Contains(t, span.Attributes, Attribute{"url.path", "/items"})
+	assert.Contains(t, span.Attributes, Attribute{"url.query", "page=2"})
+	assert.Contains(t, span.Attributes, Attribute{"client.address", "192.0.2.7"})
+	assert.Contains(t, span.Attributes, Attribute{"http.response.status_code", int64(200)})
+	assert.Contains(t, span.Attributes, Attribute{"http.response.body.size", int64(5)})
+	assert.Contains(t, span.Attributes, Attribute{"app.items", int64(3)})
+
+	// Test: Without a valid traceparent a new sampled trace starts and tracestate is dropped
+	do(t, tr, hello, map[string]string{"traceparent": "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "tracestate": "congo=t61rcWkgMzE"})
+	span = exported.spans[1]
+	assert.NotEqual(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.Context.TraceID.String())
+	assert.True(t, span.Context.TraceID.IsValid())
+	assert.False(t, span.ParentID.IsValid())
+	assert.True(t, span.Context.Sampled())
+	assert.Empty(t, span.Context.TraceState)
+
+	// Test: Unsampled traces run the hooks but are not exported
+	do(t, tr, hello, map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"})
+	assert.Len(t, started, 3)
+	assert.Len(t, exported.spans, 2)
+
+	// Test: Server errors mark the span and export errors are reported
+	exported.err = errors.New("disk full")
+	var exportErr error
+	tr.OnError = func(err error) { exportErr = err }
+	do(t, tr, func(w *response.Writer, req *request.Request) *server.HandlerError {
+		return &server.HandlerError{StatusCode: response.StatusBadGateway, Message: "Bad Gateway"}
+	}, nil)
+	span = exported.spans[2]
+	assert.Equal(t, StatusError, span.Status)
+	assert.Contains(t, span.Attributes, Attribute{"http.response.status_code", int64(502)})
+	assert.EqualError(t, exportErr, "disk full")
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var out bytes.Buffer
+	e := NewOTLPExporter(&out, "httpserver")
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	require.NoError(t, err)
+	span := &Span{
+		Name:     "GET",
+		Context:  SpanContext{TraceID: sc.TraceID, SpanID: SpanID{1, 2, 3, 4, 5, 6, 7, 8}, Flags: FlagSampled},
+		ParentID: sc.SpanID,
+		Start:    time.Unix(1700000000, 5),
+		End:      time.Unix(1700000001, 0),
+		Status:   StatusError,
+	}
+	span.SetAttribute("http.request.method", "GET")
+	span.SetAttribute("http.response.status_code", int64(500))
+
+	// Test: Each span is one OTLP/JSON line
+	require.NoError(t, e.Export(span))
+	require.NoError(t, e.Export(span))
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+
+	var decoded struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []map[string]any
+			}
+			ScopeSpans []struct {
+				Spans []map[string]any
+			}
+		}
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &decoded))
+	resource := decoded.ResourceSpans[0]
+	assert.Equal(t, map[string]any{"key": "service.name", "value": map[string]any{"stringValue": "httpserver"}}, resource.Resource.Attributes[0])
+	got := resource.ScopeSpans[0].Spans[0]
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", got["traceId"])
+	assert.Equal(t, "0102030405060708", got["spanId"])
+	assert.Equal(t, "00f067aa0ba902b7", got["parentSpanId"])
+	assert.Equal(t, float64(2), got["kind"])
+	assert.Equal(t, "1700000000000000005", got["startTimeUnixNano"])
+	assert.Equal(t, map[string]any{"code": float64(2)}, got["status"])
+	assert.Contains(t, got["attributes"], map[string]any{"key": "http.response.status_code", "value": map[string]any{"intValue": "500"}})
+}